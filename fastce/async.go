@@ -0,0 +1,201 @@
+package fastce
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	j "github.com/creativecactus/fast-cloudevents-go/jsonce"
+
+	"github.com/valyala/fasthttp"
+)
+
+/*
+  █████╗ ███████╗██╗   ██╗███╗   ██╗ ██████╗
+ ██╔══██╗██╔════╝╚██╗ ██╔╝████╗  ██║██╔════╝
+ ███████║███████╗ ╚████╔╝ ██╔██╗ ██║██║
+ ██╔══██║╚════██║  ╚██╔╝  ██║╚██╗██║██║
+ ██║  ██║███████║   ██║   ██║ ╚████║╚██████╗
+ ╚═╝  ╚═╝╚══════╝   ╚═╝   ╚═╝  ╚═══╝ ╚═════╝
+*/
+
+// PartitionKeyExtension is the extension whose events are handled in order, by the same worker
+// https://github.com/cloudevents/spec/blob/v1.0/extensions/partitioning.md
+const PartitionKeyExtension = "partitionkey"
+
+// Async configures a CEServer to accept events into a queue and reply 202 immediately
+// Events are handled later by a pool of workers, so any events returned by the handler are
+// discarded and errors are passed to OnError. Events with the same partitionkey extension
+// are handled in the order they were received.
+// Async only applies to servers started with Start, StartCE, StartHandler or ListenAndServe*.
+type Async struct {
+	Workers    int           // Optional, the number of events handled concurrently, defaults to 4
+	QueueSize  int           // Optional, the number of events accepted but not yet handled, defaults to 1024
+	FullStatus int           // Optional, the status sent when the queue is full, 503 (default) or 429
+	RetryAfter time.Duration // Optional, sent as Retry-After when the queue is full, defaults to 1 second
+
+	// OnError receives the events of a failed handler call, defaults to logging them with the Logger of the server
+	OnError func(ctx context.Context, ces j.CloudEvents, err error)
+}
+
+// asyncJob is a batch of events to be handled in order by one worker
+type asyncJob struct {
+	ctx     context.Context
+	handler Handler
+	ces     j.CloudEvents
+}
+
+// asyncQueue is the bounded queue and worker pool of an Async server
+type asyncQueue struct {
+	opts Async
+
+	depth int64  // Events enqueued or being handled, accessed atomically
+	next  uint64 // Round robin counter for events without a partition key, accessed atomically
+
+	workers []chan asyncJob
+	wg      sync.WaitGroup // Workers running
+	once    sync.Once      // Closes workers
+}
+
+// newAsyncQueue starts the workers of an Async configuration
+func newAsyncQueue(opts Async, logger Logger) *asyncQueue {
+	if opts.Workers < 1 {
+		opts.Workers = 4
+	}
+	if opts.QueueSize < 1 {
+		opts.QueueSize = 1024
+	}
+	if opts.FullStatus == 0 {
+		opts.FullStatus = fasthttp.StatusServiceUnavailable
+	}
+	if opts.RetryAfter == 0 {
+		opts.RetryAfter = time.Second
+	}
+	if opts.OnError == nil {
+		if logger == nil {
+			logger = NewStdLogger(nil, LevelWarn)
+		}
+		opts.OnError = func(ctx context.Context, ces j.CloudEvents, err error) {
+			meta, _ := MetadataFrom(ctx)
+			var pe PanicError
+			if errors.As(err, &pe) {
+				logPanic(logger, pe, "method", meta.Method, "path", meta.Path)
+			}
+			logEvents(logger, LevelWarn, "Event failed", ces, meta.Mode, "error", err)
+		}
+	}
+
+	q := &asyncQueue{opts: opts}
+	for i := 0; i < opts.Workers; i++ {
+		// Each channel can hold the whole queue, so sends never block once reserved
+		jobs := make(chan asyncJob, opts.QueueSize)
+		q.workers = append(q.workers, jobs)
+		q.wg.Add(1)
+		go q.work(jobs)
+	}
+	return q
+}
+
+// work handles jobs until the channel is closed
+func (q *asyncQueue) work(jobs chan asyncJob) {
+	defer q.wg.Done()
+	for job := range jobs {
+		if _, err := callHandler(job.ctx, job.handler, job.ces); err != nil {
+			q.opts.OnError(job.ctx, job.ces, err)
+		}
+		atomic.AddInt64(&q.depth, -int64(len(job.ces)))
+	}
+}
+
+// reserve claims space in the queue for n events, or reports that it is full
+func (q *asyncQueue) reserve(n int) bool {
+	for {
+		depth := atomic.LoadInt64(&q.depth)
+		if depth+int64(n) > int64(q.opts.QueueSize) {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&q.depth, depth, depth+int64(n)) {
+			return true
+		}
+	}
+}
+
+// worker returns the index of the worker which handles an event
+func (q *asyncQueue) worker(ce j.CloudEvent) int {
+	key, ok := ce.Extensions[PartitionKeyExtension]
+	if !ok {
+		return int(atomic.AddUint64(&q.next, 1) % uint64(len(q.workers)))
+	}
+	h := fnv.New32a()
+	fmt.Fprint(h, key)
+	return int(h.Sum32() % uint32(len(q.workers)))
+}
+
+// enqueue accepts events to be handled by the workers, or returns an error to send instead
+func (q *asyncQueue) enqueue(ctx context.Context, handler Handler, ces j.CloudEvents) error {
+	if len(ces) > q.opts.QueueSize {
+		return fmt.Errorf("%w: %d events exceeds the queue size of %d", ErrTooLarge, len(ces), q.opts.QueueSize)
+	}
+	if !q.reserve(len(ces)) {
+		return StatusError{
+			Err:        fmt.Errorf("Queue full: %d events queued", q.Depth()),
+			Status:     q.opts.FullStatus,
+			RetryAfter: q.opts.RetryAfter,
+		}
+	}
+
+	// Split the batch per worker, keeping the order of events within each
+	batches := make([]j.CloudEvents, len(q.workers))
+	for _, ce := range ces {
+		i := q.worker(ce)
+		batches[i] = append(batches[i], ce)
+	}
+	for i, batch := range batches {
+		if len(batch) > 0 {
+			q.workers[i] <- asyncJob{ctx: ctx, handler: handler, ces: batch}
+		}
+	}
+	return nil
+}
+
+// Depth returns the number of events enqueued or being handled
+func (q *asyncQueue) Depth() int {
+	return int(atomic.LoadInt64(&q.depth))
+}
+
+// drain stops accepting jobs and waits for the workers to finish those queued
+// It must only be called once no more events can be enqueued.
+func (q *asyncQueue) drain() {
+	q.once.Do(func() {
+		for _, jobs := range q.workers {
+			close(jobs)
+		}
+	})
+	q.wg.Wait()
+}
+
+// serveAsync validates events and enqueues them for the workers of the server
+// The handler, including any middleware, is called later by a worker, with a context which is
+// cancelled if Shutdown gives up waiting. HandlerTimeout does not apply.
+func (srv *CEServer) serveAsync(ctx *fasthttp.RequestCtx, q *asyncQueue, mode j.Mode, ces j.CloudEvents, handler Handler) error {
+	for i, ce := range ces {
+		if _, err := ce.Valid(); err != nil {
+			return fmt.Errorf("%w: %d: %s", ErrInvalidEvent, i, err.Error())
+		}
+	}
+	meta := newMetadata(ctx, mode)
+	meta.Header, meta.RequestCtx = nil, nil // Not valid once the response is sent
+	return q.enqueue(WithMetadata(srv.state.ctx, meta), handler, ces)
+}
+
+// QueueDepth returns the number of events accepted by an Async server but not yet handled
+func (srv *CEServer) QueueDepth() int {
+	if srv.state == nil || srv.state.queue == nil {
+		return 0
+	}
+	return srv.state.queue.Depth()
+}
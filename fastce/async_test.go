@@ -0,0 +1,126 @@
+package fastce
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	jsonce "github.com/creativecactus/fast-cloudevents-go/jsonce"
+
+	"github.com/valyala/fasthttp"
+)
+
+// partitioned generates events with ids 0..n-1 and the given partition key
+func partitioned(key string, n int) jsonce.CloudEvents {
+	ces := jsonce.GenerateValidEvents(uint(n))
+	for i := range ces {
+		ces[i].Id = fmt.Sprintf("%s%d", key, i)
+		ces[i].Extensions[PartitionKeyExtension] = key
+	}
+	return ces
+}
+
+func TestAsync(t *testing.T) {
+	lock := sync.Mutex{}
+	handled := []string{}
+	release := make(chan struct{})
+	srv := &CEServer{Async: &Async{Workers: 3, QueueSize: 6, RetryAfter: 2 * time.Second}}
+	err := srv.StartCE("127.0.0.1:0", jsonce.DefaultCEToMap, jsonce.DefaultMapToCE, func(ces jsonce.CloudEvents) (jsonce.CloudEvents, error) {
+		<-release
+		lock.Lock()
+		defer lock.Unlock()
+		for _, ce := range ces {
+			handled = append(handled, ce.Id)
+		}
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatalf("TestAsync: %s", err.Error())
+	}
+
+	cec, err := NewCEClient("POST", srv.Addr())
+	if err != nil {
+		t.Fatalf("TestAsync: %s", err.Error())
+	}
+	defer cec.Release()
+	send := func(ces jsonce.CloudEvents) *fasthttp.Response {
+		if err := cec.SendEvents(jsonce.DefaultCEToMap, ces, jsonce.ModeBatch); err != nil {
+			t.Fatalf("TestAsync: %s", err.Error())
+		}
+		if err := cec.Send(); err != nil {
+			t.Fatalf("TestAsync: %s", err.Error())
+		}
+		return cec.Response
+	}
+
+	// Events are accepted without waiting for the handler
+	for i := 0; i < 3; i++ {
+		if res := send(partitioned("a", 2)); res.StatusCode() != fasthttp.StatusAccepted {
+			t.Fatalf("TestAsync: want 202, have %d", res.StatusCode())
+		}
+	}
+	if depth := srv.QueueDepth(); depth != 6 {
+		t.Fatalf("TestAsync: want depth 6, have %d", depth)
+	}
+
+	// The queue is full, so further events are refused until it drains
+	res := send(partitioned("b", 1))
+	if res.StatusCode() != fasthttp.StatusServiceUnavailable || string(res.Header.Peek("Retry-After")) != "2" {
+		t.Fatalf("TestAsync: want 503 with Retry-After 2, have %d %q", res.StatusCode(), res.Header.Peek("Retry-After"))
+	}
+
+	// Shutdown drains the queue, and events with the same partition key stay in order
+	close(release)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err = srv.Shutdown(ctx); err != nil {
+		t.Fatalf("TestAsync: %s", err.Error())
+	}
+	if depth := srv.QueueDepth(); depth != 0 {
+		t.Fatalf("TestAsync: want depth 0 after shutdown, have %d", depth)
+	}
+	if len(handled) != 6 {
+		t.Fatalf("TestAsync: want 6 events handled, have %v", handled)
+	}
+	for i, id := range handled {
+		if id != fmt.Sprintf("a%d", i%2) {
+			t.Fatalf("TestAsync: events handled out of order: %v", handled)
+		}
+	}
+}
+
+func TestAsyncShutdownUnfinished(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	srv := &CEServer{Async: &Async{Workers: 1}}
+	err := srv.StartCE("127.0.0.1:0", jsonce.DefaultCEToMap, jsonce.DefaultMapToCE, func(ces jsonce.CloudEvents) (jsonce.CloudEvents, error) {
+		<-release
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatalf("TestAsyncShutdownUnfinished: %s", err.Error())
+	}
+
+	cec, err := NewCEClient("POST", srv.Addr())
+	if err != nil {
+		t.Fatalf("TestAsyncShutdownUnfinished: %s", err.Error())
+	}
+	defer cec.Release()
+	if err = cec.SendEvents(jsonce.DefaultCEToMap, partitioned("a", 3), jsonce.ModeBatch); err != nil {
+		t.Fatalf("TestAsyncShutdownUnfinished: %s", err.Error())
+	}
+	if err = cec.Send(); err != nil {
+		t.Fatalf("TestAsyncShutdownUnfinished: %s", err.Error())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = srv.Shutdown(ctx)
+	var se ShutdownError
+	if !errors.As(err, &se) || se.Unfinished != 3 {
+		t.Fatalf("TestAsyncShutdownUnfinished: want ShutdownError with 3 unfinished, have %v", err)
+	}
+}
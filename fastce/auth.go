@@ -0,0 +1,471 @@
+package fastce
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+/*
+  █████╗ ██╗   ██╗████████╗██╗  ██╗
+ ██╔══██╗██║   ██║╚══██╔══╝██║  ██║
+ ███████║██║   ██║   ██║   ███████║
+ ██╔══██║██║   ██║   ██║   ██╔══██║
+ ██║  ██║╚██████╔╝   ██║   ██║  ██║
+ ╚═╝  ╚═╝ ╚═════╝    ╚═╝   ╚═╝  ╚═╝
+*/
+
+// The headers of HMAC signatures, see HMACAuth and HMACSigner
+const (
+	HeaderSignature          = "X-Fastce-Signature" // v1=<hex HMAC-SHA256 of timestamp "." body>
+	HeaderSignatureTimestamp = "X-Fastce-Timestamp" // Unix seconds
+	HeaderSignatureKeyId     = "X-Fastce-Key-Id"    // Optional, selects the key
+)
+
+// principalKey is the fasthttp user value holding the Principal of a request
+const principalKey = "fastce.principal"
+
+// Principal describes the sender of an authenticated request
+// It is available to handlers as Metadata.Principal.
+type Principal struct {
+	Scheme  string                 // The kind of credential accepted, "bearer", "hmac" or "jwt"
+	Subject string                 // The name of the token, the id of the HMAC key, or the "sub" claim of a JWT
+	Claims  map[string]interface{} // The claims of a JWT
+}
+
+// Authenticator checks the credentials of a request before its events are read
+// Errors should wrap ErrUnauthorized, so the request is refused with 401.
+type Authenticator interface {
+	Authenticate(req *fasthttp.Request) (Principal, error)
+}
+
+// AuthenticatorFunc allows a function to be used as an Authenticator
+type AuthenticatorFunc func(req *fasthttp.Request) (Principal, error)
+
+// Authenticate implements Authenticator
+func (f AuthenticatorFunc) Authenticate(req *fasthttp.Request) (Principal, error) {
+	return f(req)
+}
+
+// AnyOf accepts a request if any of the authenticators accepts it, trying them in order
+// If all refuse the request, the error of the last is returned.
+func AnyOf(auths ...Authenticator) Authenticator {
+	return AuthenticatorFunc(func(req *fasthttp.Request) (p Principal, err error) {
+		err = fmt.Errorf("%w: no authenticators", ErrUnauthorized)
+		for _, auth := range auths {
+			if p, err = auth.Authenticate(req); err == nil {
+				return
+			}
+		}
+		return
+	})
+}
+
+// authenticate checks a request with the Authenticator of the server, and records its Principal
+func (srv *CEServer) authenticate(ctx *fasthttp.RequestCtx) error {
+	if srv.Authenticator == nil {
+		return nil
+	}
+	p, err := srv.Authenticator.Authenticate(&ctx.Request)
+	if err != nil {
+		return err
+	}
+	ctx.SetUserValue(principalKey, &p)
+	return nil
+}
+
+// principalOf returns the Principal recorded for a request, or nil
+func principalOf(ctx *fasthttp.RequestCtx) *Principal {
+	p, _ := ctx.UserValue(principalKey).(*Principal)
+	return p
+}
+
+// Signer adds credentials to a request, after its body is final
+type Signer interface {
+	Sign(req *fasthttp.Request) error
+}
+
+/*
+ ██████╗ ███████╗ █████╗ ██████╗ ███████╗██████╗
+ ██╔══██╗██╔════╝██╔══██╗██╔══██╗██╔════╝██╔══██╗
+ ██████╔╝█████╗  ███████║██████╔╝█████╗  ██████╔╝
+ ██╔══██╗██╔══╝  ██╔══██║██╔══██╗██╔══╝  ██╔══██╗
+ ██████╔╝███████╗██║  ██║██║  ██║███████╗██║  ██║
+ ╚═════╝ ╚══════╝╚═╝  ╚═╝╚═╝  ╚═╝╚══════╝╚═╝  ╚═╝
+*/
+
+// BearerAuth accepts requests with an "Authorization: Bearer <token>" header for one of Tokens
+type BearerAuth struct {
+	Tokens map[string]string // Subject per token
+}
+
+// Authenticate implements Authenticator
+func (a BearerAuth) Authenticate(req *fasthttp.Request) (Principal, error) {
+	token, ok := bearerToken(req)
+	if !ok {
+		return Principal{}, fmt.Errorf("%w: missing bearer token", ErrUnauthorized)
+	}
+	// Compare against every token, so the time taken does not reveal which almost matched
+	subject, found := "", false
+	for t, s := range a.Tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			subject, found = s, true
+		}
+	}
+	if !found {
+		return Principal{}, fmt.Errorf("%w: unknown bearer token", ErrUnauthorized)
+	}
+	return Principal{Scheme: "bearer", Subject: subject}, nil
+}
+
+// bearerToken returns the token of an Authorization header
+func bearerToken(req *fasthttp.Request) (string, bool) {
+	h := string(req.Header.Peek("Authorization"))
+	if len(h) < 7 || !strings.EqualFold(h[:7], "Bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(h[7:]), true
+}
+
+// BearerSigner adds an "Authorization: Bearer <Token>" header to requests
+type BearerSigner struct {
+	Token string
+}
+
+// Sign implements Signer
+func (s BearerSigner) Sign(req *fasthttp.Request) error {
+	req.Header.Set("Authorization", "Bearer "+s.Token)
+	return nil
+}
+
+/*
+ ██╗  ██╗███╗   ███╗ █████╗  ██████╗
+ ██║  ██║████╗ ████║██╔══██╗██╔════╝
+ ███████║██╔████╔██║███████║██║
+ ██╔══██║██║╚██╔╝██║██╔══██║██║
+ ██║  ██║██║ ╚═╝ ██║██║  ██║╚██████╗
+ ╚═╝  ╚═╝╚═╝     ╚═╝╚═╝  ╚═╝ ╚═════╝
+*/
+
+// HMACAuth accepts requests signed with HMAC-SHA256 over their timestamp and raw body, as by HMACSigner
+// The signature is the hex HMAC of `<HeaderSignatureTimestamp>.<body>` as sent, before any decompression.
+// Requests whose timestamp is further than Window from now are refused, limiting replays.
+type HMACAuth struct {
+	Keys   map[string][]byte // Secret per key id, the key id "" is used for requests without one
+	Window time.Duration     // Optional, the accepted age of a timestamp, defaults to 5 minutes
+
+	now func() time.Time // Replaced in tests
+}
+
+// Authenticate implements Authenticator
+func (a HMACAuth) Authenticate(req *fasthttp.Request) (Principal, error) {
+	window := a.Window
+	if window <= 0 {
+		window = 5 * time.Minute
+	}
+	now := time.Now()
+	if a.now != nil {
+		now = a.now()
+	}
+
+	ts := string(req.Header.Peek(HeaderSignatureTimestamp))
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return Principal{}, fmt.Errorf("%w: missing or malformed %s", ErrUnauthorized, HeaderSignatureTimestamp)
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > window || age < -window {
+		return Principal{}, fmt.Errorf("%w: signature timestamp outside of %s", ErrUnauthorized, window)
+	}
+
+	id := string(req.Header.Peek(HeaderSignatureKeyId))
+	key, ok := a.Keys[id]
+	if !ok {
+		return Principal{}, fmt.Errorf("%w: unknown key id %q", ErrUnauthorized, id)
+	}
+	sig := string(req.Header.Peek(HeaderSignature))
+	if !strings.HasPrefix(sig, "v1=") {
+		return Principal{}, fmt.Errorf("%w: missing or malformed %s", ErrUnauthorized, HeaderSignature)
+	}
+	have, err := hex.DecodeString(sig[3:])
+	if err != nil || !hmac.Equal(have, bodyHMAC(key, ts, req.Body())) {
+		return Principal{}, fmt.Errorf("%w: invalid signature", ErrUnauthorized)
+	}
+	return Principal{Scheme: "hmac", Subject: id}, nil
+}
+
+// bodyHMAC returns the HMAC-SHA256 of a timestamp and body
+func bodyHMAC(key []byte, ts string, body []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// HMACSigner signs requests for HMACAuth with the current time
+type HMACSigner struct {
+	KeyId string // Optional, sent if set
+	Key   []byte
+
+	now func() time.Time // Replaced in tests
+}
+
+// Sign implements Signer
+func (s HMACSigner) Sign(req *fasthttp.Request) error {
+	now := time.Now()
+	if s.now != nil {
+		now = s.now()
+	}
+	ts := strconv.FormatInt(now.Unix(), 10)
+	req.Header.Set(HeaderSignatureTimestamp, ts)
+	req.Header.Set(HeaderSignature, "v1="+hex.EncodeToString(bodyHMAC(s.Key, ts, req.Body())))
+	if len(s.KeyId) > 0 {
+		req.Header.Set(HeaderSignatureKeyId, s.KeyId)
+	} else {
+		req.Header.Del(HeaderSignatureKeyId)
+	}
+	return nil
+}
+
+/*
+      ██╗██╗    ██╗████████╗
+      ██║██║    ██║╚══██╔══╝
+      ██║██║ █╗ ██║   ██║
+ ██   ██║██║███╗██║   ██║
+ ╚█████╔╝╚███╔███╔╝   ██║
+  ╚════╝  ╚══╝╚══╝    ╚═╝
+*/
+
+// JWTAuth accepts requests with a bearer JSON Web Token signed by one of Keys
+// Supported algorithms are HS256 ([]byte keys), RS256 (*rsa.PublicKey) and ES256 (*ecdsa.PublicKey
+// on P-256). The algorithm must match the type of the key, and the "exp" and "nbf" claims are checked.
+type JWTAuth struct {
+	Keys     map[string]interface{} // Key per "kid", the kid "" is used for tokens without one
+	Issuer   string                 // Optional, the required "iss" claim
+	Audience string                 // Optional, a required member of the "aud" claim
+	Leeway   time.Duration          // Optional, allowed clock skew for "exp" and "nbf"
+
+	now func() time.Time // Replaced in tests
+}
+
+// jwtHeader is the JOSE header of a token
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+	Kid string `json:"kid,omitempty"`
+}
+
+// Authenticate implements Authenticator
+func (a JWTAuth) Authenticate(req *fasthttp.Request) (Principal, error) {
+	token, ok := bearerToken(req)
+	if !ok {
+		return Principal{}, fmt.Errorf("%w: missing bearer token", ErrUnauthorized)
+	}
+	claims, err := a.verify(token)
+	if err != nil {
+		return Principal{}, fmt.Errorf("%w: %s", ErrUnauthorized, err.Error())
+	}
+	sub, _ := claims["sub"].(string)
+	return Principal{Scheme: "jwt", Subject: sub, Claims: claims}, nil
+}
+
+// verify checks the signature and claims of a token, returning its claims
+func (a JWTAuth) verify(token string) (claims map[string]interface{}, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("Malformed token")
+	}
+	var header jwtHeader
+	if err = jwtDecode(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("Malformed header: %s", err.Error())
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("Malformed signature: %s", err.Error())
+	}
+	key, ok := a.Keys[header.Kid]
+	if !ok {
+		return nil, fmt.Errorf("Unknown kid %q", header.Kid)
+	}
+	if err = jwtVerify(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+
+	if err = jwtDecode(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("Malformed claims: %s", err.Error())
+	}
+	now := time.Now()
+	if a.now != nil {
+		now = a.now()
+	}
+	if exp, ok := claims["exp"].(float64); ok && now.After(time.Unix(int64(exp), 0).Add(a.Leeway)) {
+		return nil, errors.New("Token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Before(time.Unix(int64(nbf), 0).Add(-a.Leeway)) {
+		return nil, errors.New("Token not yet valid")
+	}
+	if len(a.Issuer) > 0 && claims["iss"] != a.Issuer {
+		return nil, fmt.Errorf("Unexpected issuer %v", claims["iss"])
+	}
+	if len(a.Audience) > 0 && !jwtHasAudience(claims["aud"], a.Audience) {
+		return nil, fmt.Errorf("Unexpected audience %v", claims["aud"])
+	}
+	return claims, nil
+}
+
+// jwtDecode decodes a base64url JSON part of a token
+func jwtDecode(part string, v interface{}) error {
+	p, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(p, v)
+}
+
+// jwtHasAudience reports whether an "aud" claim, a string or array of strings, contains aud
+func jwtHasAudience(claim interface{}, aud string) bool {
+	switch v := claim.(type) {
+	case string:
+		return v == aud
+	case []interface{}:
+		for _, a := range v {
+			if a == aud {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// jwtVerify checks a signature with a key of the type expected by alg
+func jwtVerify(alg string, key interface{}, signed, sig []byte) error {
+	digest := sha256.Sum256(signed)
+	switch k := key.(type) {
+	case []byte:
+		if alg == "HS256" {
+			mac := hmac.New(sha256.New, k)
+			mac.Write(signed)
+			if !hmac.Equal(sig, mac.Sum(nil)) {
+				return errors.New("Invalid signature")
+			}
+			return nil
+		}
+	case *rsa.PublicKey:
+		if alg == "RS256" {
+			if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig); err != nil {
+				return errors.New("Invalid signature")
+			}
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		if alg == "ES256" {
+			if len(sig) != 64 {
+				return errors.New("Invalid signature")
+			}
+			r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+			if !ecdsa.Verify(k, digest[:], r, s) {
+				return errors.New("Invalid signature")
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("Algorithm %q does not match the key", alg)
+}
+
+// JWTSigner adds a bearer JSON Web Token to requests, signed when each request is sent
+// Key is a []byte for HS256, *rsa.PrivateKey for RS256 or *ecdsa.PrivateKey (P-256) for ES256.
+type JWTSigner struct {
+	Alg    string
+	Kid    string                 // Optional, sent in the header if set
+	Key    interface{}            // The private key, see above
+	Claims map[string]interface{} // Optional, such as "sub", "iss" and "aud"
+	TTL    time.Duration          // Optional, sets "iat" and "exp" this far ahead if greater than 0
+
+	now func() time.Time // Replaced in tests
+}
+
+// Sign implements Signer
+func (s JWTSigner) Sign(req *fasthttp.Request) error {
+	token, err := s.Token()
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// Token returns a new signed token
+func (s JWTSigner) Token() (string, error) {
+	claims := map[string]interface{}{}
+	for k, v := range s.Claims {
+		claims[k] = v
+	}
+	if s.TTL > 0 {
+		now := time.Now()
+		if s.now != nil {
+			now = s.now()
+		}
+		claims["iat"] = now.Unix()
+		claims["exp"] = now.Add(s.TTL).Unix()
+	}
+	header, err := json.Marshal(jwtHeader{Alg: s.Alg, Typ: "JWT", Kid: s.Kid})
+	if err != nil {
+		return "", fmt.Errorf("Marshal JWT header: %s", err.Error())
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("Marshal JWT claims: %s", err.Error())
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sig, err := jwtSign(s.Alg, s.Key, []byte(signed))
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// jwtSign signs with a key of the type expected by alg
+func jwtSign(alg string, key interface{}, signed []byte) ([]byte, error) {
+	digest := sha256.Sum256(signed)
+	switch k := key.(type) {
+	case []byte:
+		if alg == "HS256" {
+			mac := hmac.New(sha256.New, k)
+			mac.Write(signed)
+			return mac.Sum(nil), nil
+		}
+	case *rsa.PrivateKey:
+		if alg == "RS256" {
+			return rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		}
+	case *ecdsa.PrivateKey:
+		if alg == "ES256" {
+			r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+			if err != nil {
+				return nil, err
+			}
+			// The signature is r and s as 32 byte big-endian integers
+			sig := make([]byte, 64)
+			rb, sb := r.Bytes(), s.Bytes()
+			copy(sig[32-len(rb):32], rb)
+			copy(sig[64-len(sb):], sb)
+			return sig, nil
+		}
+	}
+	return nil, fmt.Errorf("Algorithm %q does not match the key", alg)
+}
@@ -0,0 +1,158 @@
+package fastce
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"testing"
+	"time"
+
+	jsonce "github.com/creativecactus/fast-cloudevents-go/jsonce"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestBearerAuth(t *testing.T) {
+	srv := &CEServer{Authenticator: BearerAuth{Tokens: map[string]string{"secret": "billing"}}}
+	subject := make(chan string, 1)
+	err := srv.StartHandler("127.0.0.1:0", jsonce.DefaultCEToMap, jsonce.DefaultMapToCE, HandlerFunc(func(ctx context.Context, ces jsonce.CloudEvents) (jsonce.CloudEvents, error) {
+		meta, _ := MetadataFrom(ctx)
+		subject <- meta.Principal.Subject
+		return nil, nil
+	}))
+	if err != nil {
+		t.Fatalf("TestBearerAuth: %s", err.Error())
+	}
+	defer srv.Shutdown(context.Background())
+
+	cec, err := NewCEClient("POST", srv.Addr())
+	if err != nil {
+		t.Fatalf("TestBearerAuth: %s", err.Error())
+	}
+	defer cec.Release()
+	send := func() int {
+		if err := cec.SendEvents(jsonce.DefaultCEToMap, jsonce.GenerateValidEvents(1), jsonce.ModeStructure); err != nil {
+			t.Fatalf("TestBearerAuth: %s", err.Error())
+		}
+		if err := cec.Send(); err != nil {
+			t.Fatalf("TestBearerAuth: %s", err.Error())
+		}
+		return cec.Response.StatusCode()
+	}
+
+	if status := send(); status != fasthttp.StatusUnauthorized {
+		t.Fatalf("TestBearerAuth: want 401 without a token, have %d", status)
+	}
+	cec.Signer = BearerSigner{Token: "wrong"}
+	if status := send(); status != fasthttp.StatusUnauthorized {
+		t.Fatalf("TestBearerAuth: want 401 with a wrong token, have %d", status)
+	}
+	cec.Signer = BearerSigner{Token: "secret"}
+	if status := send(); status != fasthttp.StatusNoContent {
+		t.Fatalf("TestBearerAuth: want 204, have %d", status)
+	}
+	if s := <-subject; s != "billing" {
+		t.Fatalf("TestBearerAuth: want principal billing, have %q", s)
+	}
+}
+
+func TestHMACAuth(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	auth := HMACAuth{Keys: map[string][]byte{"k1": []byte("one"), "k2": []byte("two")}, Window: time.Minute}
+	auth.now = func() time.Time { return now }
+	signer := HMACSigner{KeyId: "k2", Key: []byte("two")}
+	signer.now = func() time.Time { return now }
+
+	req := &fasthttp.Request{}
+	if err := SendEvents(jsonce.DefaultCEToMap, req, jsonce.GenerateValidEvents(2), jsonce.ModeBatch); err != nil {
+		t.Fatalf("TestHMACAuth: %s", err.Error())
+	}
+	if err := signer.Sign(req); err != nil {
+		t.Fatalf("TestHMACAuth: %s", err.Error())
+	}
+	p, err := auth.Authenticate(req)
+	if err != nil || p.Scheme != "hmac" || p.Subject != "k2" {
+		t.Fatalf("TestHMACAuth: want principal k2, have %+v %v", p, err)
+	}
+
+	// Replays outside of the window are refused
+	now = now.Add(2 * time.Minute)
+	if _, err = auth.Authenticate(req); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("TestHMACAuth: want a stale timestamp refused, have %v", err)
+	}
+	now = now.Add(-2 * time.Minute)
+
+	// As are changed bodies and unknown keys
+	req.AppendBodyString(" ")
+	if _, err = auth.Authenticate(req); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("TestHMACAuth: want a changed body refused, have %v", err)
+	}
+	signer.KeyId = "k3"
+	signer.Sign(req)
+	if _, err = auth.Authenticate(req); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("TestHMACAuth: want an unknown key refused, have %v", err)
+	}
+}
+
+func TestJWTAuth(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("TestJWTAuth: %s", err.Error())
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("TestJWTAuth: %s", err.Error())
+	}
+	now := time.Unix(1600000000, 0)
+	auth := JWTAuth{
+		Keys: map[string]interface{}{
+			"hs": []byte("shared"),
+			"rs": &rsaKey.PublicKey,
+			"es": &ecKey.PublicKey,
+		},
+		Issuer:   "orders",
+		Audience: "ingest",
+	}
+	auth.now = func() time.Time { return now }
+	claims := map[string]interface{}{"sub": "svc", "iss": "orders", "aud": []string{"ingest", "audit"}}
+
+	for _, s := range []JWTSigner{
+		{Alg: "HS256", Kid: "hs", Key: []byte("shared"), Claims: claims, TTL: time.Minute},
+		{Alg: "RS256", Kid: "rs", Key: rsaKey, Claims: claims, TTL: time.Minute},
+		{Alg: "ES256", Kid: "es", Key: ecKey, Claims: claims, TTL: time.Minute},
+	} {
+		s.now = func() time.Time { return now }
+		req := &fasthttp.Request{}
+		if err = s.Sign(req); err != nil {
+			t.Fatalf("TestJWTAuth: %s: %s", s.Alg, err.Error())
+		}
+		p, err := auth.Authenticate(req)
+		if err != nil || p.Scheme != "jwt" || p.Subject != "svc" {
+			t.Fatalf("TestJWTAuth: %s: want principal svc, have %+v %v", s.Alg, p, err)
+		}
+
+		now = now.Add(2 * time.Minute)
+		if _, err = auth.Authenticate(req); !errors.Is(err, ErrUnauthorized) {
+			t.Fatalf("TestJWTAuth: %s: want an expired token refused, have %v", s.Alg, err)
+		}
+		now = now.Add(-2 * time.Minute)
+	}
+
+	// The algorithm must match the key, so a public key cannot be used as an HMAC secret
+	forged := JWTSigner{Alg: "HS256", Kid: "rs", Key: []byte("anything"), Claims: claims}
+	req := &fasthttp.Request{}
+	forged.Sign(req)
+	if _, err = auth.Authenticate(req); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("TestJWTAuth: want a mismatched algorithm refused, have %v", err)
+	}
+
+	// Claims are checked
+	wrong := JWTSigner{Alg: "HS256", Kid: "hs", Key: []byte("shared"), Claims: map[string]interface{}{"iss": "orders", "aud": "other"}}
+	wrong.Sign(req)
+	if _, err = auth.Authenticate(req); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("TestJWTAuth: want a wrong audience refused, have %v", err)
+	}
+}
@@ -0,0 +1,340 @@
+package fastce
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/url"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	j "github.com/creativecactus/fast-cloudevents-go/jsonce"
+
+	"github.com/valyala/fasthttp"
+)
+
+/*
+ ██████╗  █████╗ ██╗      █████╗ ███╗   ██╗ ██████╗███████╗██████╗
+ ██╔══██╗██╔══██╗██║     ██╔══██╗████╗  ██║██╔════╝██╔════╝██╔══██╗
+ ██████╔╝███████║██║     ███████║██╔██╗ ██║██║     █████╗  ██████╔╝
+ ██╔══██╗██╔══██║██║     ██╔══██║██║╚██╗██║██║     ██╔══╝  ██╔══██╗
+ ██████╔╝██║  ██║███████╗██║  ██║██║ ╚████║╚██████╗███████╗██║  ██║
+ ╚═════╝ ╚═╝  ╚═╝╚══════╝╚═╝  ╚═╝╚═╝  ╚═══╝ ╚═════╝╚══════╝╚═╝  ╚═╝
+*/
+
+// ErrNoEndpoints is returned by Balancer.Send when every endpoint has been removed after failures
+var ErrNoEndpoints = fmt.Errorf("%w: no healthy endpoints", ErrUnavailable)
+
+// Balance is how a Balancer chooses an endpoint for each request
+type Balance int
+
+const (
+	// BalanceRoundRobin uses each healthy endpoint in turn
+	BalanceRoundRobin Balance = iota
+	// BalanceLeastOutstanding uses the healthy endpoint with the fewest requests in flight
+	BalanceLeastOutstanding
+	// BalanceConsistentHash uses the partitionkey extension of the first event to choose an endpoint,
+	// so events with the same key go to the same endpoint while it is healthy. Events without one are sent round-robin.
+	BalanceConsistentHash
+)
+
+// BalancerOptions configures a Balancer
+// The zero value is ready to use.
+type BalancerOptions struct {
+	Balance        Balance       // Optional, defaults to BalanceRoundRobin
+	MaxFailures    int           // Optional, consecutive failures which remove an endpoint, defaults to 3
+	HealthInterval time.Duration // Optional, how often removed endpoints are probed, defaults to 5 seconds
+	HealthTimeout  time.Duration // Optional, the limit on each probe, defaults to HealthInterval
+	HealthMethod   string        // Optional, the method of probes, defaults to GET
+	HealthPath     string        // Optional, the path probed instead of that of the endpoint
+	Replicas       int           // Optional, points per endpoint on the ring of BalanceConsistentHash, defaults to 100
+
+	Client ClientOptions // Optional, the options of the Client of each endpoint
+	Setup  func(*Client) // Optional, called with the Client of each endpoint, eg. to set Limits, Signer or Metrics
+	Logger Logger        // Optional, receives a record when an endpoint is removed or restored
+}
+
+// EndpointStatus describes one endpoint of a Balancer
+type EndpointStatus struct {
+	URL         string
+	Healthy     bool
+	Outstanding int   // Requests in flight
+	Failures    int   // Consecutive failures
+	LastError   error // The error of the last failure
+}
+
+// endpoint is one URL of a Balancer
+type endpoint struct {
+	client      *Client
+	outstanding int64 // Updated atomically
+	healthy     bool
+	failures    int
+	lastError   error
+}
+
+// ringPoint places an endpoint on the ring of BalanceConsistentHash
+type ringPoint struct {
+	hash  uint32
+	index int
+}
+
+// Balancer sends events to one of several endpoints, removing endpoints which fail
+// Removed endpoints are probed every HealthInterval, and restored once a probe is answered
+// with a status below 500. A Balancer is safe for concurrent use, and must be closed to stop the probes.
+type Balancer struct {
+	opts      BalancerOptions
+	endpoints []*endpoint
+	ring      []ringPoint
+	next      uint64 // Round-robin counter, updated atomically
+
+	lock sync.Mutex // Guards the health of endpoints
+	stop chan struct{}
+	once sync.Once
+	done chan struct{}
+}
+
+// NewBalancer creates a Balancer sending with method to each of URLs, and starts probing removed endpoints
+func NewBalancer(method string, URLs []string, opts BalancerOptions) (*Balancer, error) {
+	if len(URLs) == 0 {
+		return nil, errors.New("Balancer: no endpoints")
+	}
+	b := &Balancer{
+		opts: opts,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	for _, URL := range URLs {
+		client, err := NewClient(method, URL, opts.Client)
+		if err != nil {
+			return nil, err
+		}
+		if opts.Setup != nil {
+			opts.Setup(client)
+		}
+		b.endpoints = append(b.endpoints, &endpoint{client: client, healthy: true})
+	}
+
+	replicas := opts.Replicas
+	if replicas <= 0 {
+		replicas = 100
+	}
+	for i, e := range b.endpoints {
+		for r := 0; r < replicas; r++ {
+			b.ring = append(b.ring, ringPoint{hash: hashKey(fmt.Sprintf("%s#%d", e.client.URL, r)), index: i})
+		}
+	}
+	sort.Slice(b.ring, func(a, c int) bool {
+		return b.ring[a].hash < b.ring[c].hash
+	})
+
+	go b.probeLoop()
+	return b, nil
+}
+
+// hashKey hashes a partition key, mixing the bits so that similar keys spread around the ring
+func hashKey(key interface{}) uint32 {
+	h := fnv.New32a()
+	fmt.Fprint(h, key)
+	x := h.Sum32()
+	x ^= x >> 16
+	x *= 0x85ebca6b
+	x ^= x >> 13
+	x *= 0xc2b2ae35
+	x ^= x >> 16
+	return x
+}
+
+// Send sends events to an endpoint, as Client.Send, and returns the URL of the endpoint used
+func (b *Balancer) Send(ctx context.Context, ces j.CloudEvents, mode j.Mode) (res j.CloudEvents, served string, err error) {
+	e, err := b.pick(ces)
+	if err != nil {
+		return nil, "", err
+	}
+	atomic.AddInt64(&e.outstanding, 1)
+	res, err = e.client.Send(ctx, ces, mode)
+	atomic.AddInt64(&e.outstanding, -1)
+	b.record(e, err)
+	return res, e.client.URL, err
+}
+
+// pick chooses a healthy endpoint for events
+func (b *Balancer) pick(ces j.CloudEvents) (*endpoint, error) {
+	b.lock.Lock()
+	healthy := make([]bool, len(b.endpoints))
+	found := false
+	for i, e := range b.endpoints {
+		healthy[i] = e.healthy
+		found = found || e.healthy
+	}
+	b.lock.Unlock()
+	if !found {
+		return nil, ErrNoEndpoints
+	}
+
+	if b.opts.Balance == BalanceConsistentHash && len(ces) > 0 {
+		if key, ok := ces[0].Extensions[PartitionKeyExtension]; ok {
+			h := hashKey(key)
+			start := sort.Search(len(b.ring), func(i int) bool {
+				return b.ring[i].hash >= h
+			})
+			for i := 0; i < len(b.ring); i++ {
+				p := b.ring[(start+i)%len(b.ring)]
+				if healthy[p.index] {
+					return b.endpoints[p.index], nil
+				}
+			}
+		}
+	}
+
+	// Start from the next endpoint in turn, so ties of BalanceLeastOutstanding are shared
+	start := int(atomic.AddUint64(&b.next, 1) % uint64(len(b.endpoints)))
+	var chosen *endpoint
+	for i := range b.endpoints {
+		k := (start + i) % len(b.endpoints)
+		if !healthy[k] {
+			continue
+		}
+		e := b.endpoints[k]
+		if b.opts.Balance != BalanceLeastOutstanding {
+			return e, nil
+		}
+		if chosen == nil || atomic.LoadInt64(&e.outstanding) < atomic.LoadInt64(&chosen.outstanding) {
+			chosen = e
+		}
+	}
+	return chosen, nil
+}
+
+// isEndpointFailure reports whether the error of Client.Send means the endpoint is failing
+// Statuses below 500 and per event results are answers from a working endpoint, and cancellation is the caller's.
+func isEndpointFailure(err error) bool {
+	var se StatusError
+	var be BatchError
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, context.Canceled):
+		return false
+	case errors.As(err, &se) && se.Status < fasthttp.StatusInternalServerError:
+		return false
+	case errors.As(err, &be):
+		return false
+	}
+	return true
+}
+
+// record counts the outcome of a request, removing the endpoint after MaxFailures
+func (b *Balancer) record(e *endpoint, err error) {
+	maxFailures := b.opts.MaxFailures
+	if maxFailures <= 0 {
+		maxFailures = 3
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if !isEndpointFailure(err) {
+		e.failures = 0
+		return
+	}
+	e.failures++
+	e.lastError = err
+	if e.healthy && e.failures >= maxFailures {
+		e.healthy = false
+		loggerOrNop(b.opts.Logger).Log(LevelWarn, "Endpoint removed", "uri", e.client.URL, "failures", e.failures, "error", err)
+	}
+}
+
+// probeLoop probes removed endpoints every HealthInterval until Close
+func (b *Balancer) probeLoop() {
+	defer close(b.done)
+	interval := b.opts.HealthInterval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-ticker.C:
+		}
+		b.lock.Lock()
+		removed := []*endpoint{}
+		for _, e := range b.endpoints {
+			if !e.healthy {
+				removed = append(removed, e)
+			}
+		}
+		b.lock.Unlock()
+		for _, e := range removed {
+			b.probe(e, interval)
+		}
+	}
+}
+
+// probe sends a health check to a removed endpoint, and restores it if answered with a status below 500
+func (b *Balancer) probe(e *endpoint, interval time.Duration) {
+	timeout := b.opts.HealthTimeout
+	if timeout <= 0 {
+		timeout = interval
+	}
+	method := b.opts.HealthMethod
+	if method == "" {
+		method = fasthttp.MethodGet
+	}
+	URL := e.client.URL
+	if b.opts.HealthPath != "" {
+		if u, err := url.Parse(URL); err == nil {
+			u.Path = b.opts.HealthPath
+			URL = u.String()
+		}
+	}
+
+	req := fasthttp.AcquireRequest()
+	res := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(res)
+	b.opts.Client.prepare(req, method, URL)
+	err := e.client.HostClient().DoTimeout(req, res, timeout)
+	if err == nil && res.StatusCode() >= fasthttp.StatusInternalServerError {
+		err = StatusError{Status: res.StatusCode()}
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if err != nil {
+		e.lastError = err
+		return
+	}
+	e.healthy = true
+	e.failures = 0
+	loggerOrNop(b.opts.Logger).Log(LevelInfo, "Endpoint restored", "uri", e.client.URL)
+}
+
+// Endpoints returns the status of each endpoint, in the order given to NewBalancer
+func (b *Balancer) Endpoints() []EndpointStatus {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	statuses := []EndpointStatus{}
+	for _, e := range b.endpoints {
+		statuses = append(statuses, EndpointStatus{
+			URL:         e.client.URL,
+			Healthy:     e.healthy,
+			Outstanding: int(atomic.LoadInt64(&e.outstanding)),
+			Failures:    e.failures,
+			LastError:   e.lastError,
+		})
+	}
+	return statuses
+}
+
+// Close stops probing removed endpoints
+func (b *Balancer) Close() {
+	b.once.Do(func() {
+		close(b.stop)
+	})
+	<-b.done
+}
@@ -0,0 +1,150 @@
+package fastce
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	jsonce "github.com/creativecactus/fast-cloudevents-go/jsonce"
+
+	"github.com/valyala/fasthttp"
+)
+
+// balancedServer answers every request with 204, or 503 while down is set, until ln is closed
+func balancedServer(t *testing.T, down *int32) (URL string, ln net.Listener) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("balancedServer: %s", err.Error())
+	}
+	srv := &fasthttp.Server{Handler: func(ctx *fasthttp.RequestCtx) {
+		if atomic.LoadInt32(down) == 1 {
+			ctx.SetStatusCode(fasthttp.StatusServiceUnavailable)
+			return
+		}
+		ctx.SetStatusCode(fasthttp.StatusNoContent)
+	}}
+	go srv.Serve(ln)
+	return "http://" + ln.Addr().String(), ln
+}
+
+func TestBalancer(t *testing.T) {
+	downs := make([]int32, 3)
+	URLs := []string{}
+	for i := range downs {
+		URL, ln := balancedServer(t, &downs[i])
+		defer ln.Close()
+		URLs = append(URLs, URL)
+	}
+	b, err := NewBalancer("POST", URLs, BalancerOptions{MaxFailures: 2, HealthInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("TestBalancer: %s", err.Error())
+	}
+	defer b.Close()
+	send := func() (string, error) {
+		_, served, err := b.Send(context.Background(), jsonce.GenerateValidEvents(1), jsonce.ModeStructure)
+		return served, err
+	}
+
+	served := map[string]int{}
+	for i := 0; i < 6; i++ {
+		URL, err := send()
+		if err != nil {
+			t.Fatalf("TestBalancer: %s", err.Error())
+		}
+		served[URL]++
+	}
+	if served[URLs[0]] != 2 || served[URLs[1]] != 2 || served[URLs[2]] != 2 {
+		t.Fatalf("TestBalancer: want round-robin, have %v", served)
+	}
+
+	// A failing endpoint is removed after MaxFailures
+	atomic.StoreInt32(&downs[1], 1)
+	failures := 0
+	for i := 0; i < 6; i++ {
+		if URL, err := send(); err != nil {
+			if URL != URLs[1] {
+				t.Fatalf("TestBalancer: want failures from %s only, have %s", URLs[1], URL)
+			}
+			failures++
+		}
+	}
+	if status := b.Endpoints()[1]; failures != 2 || status.Healthy || status.LastError == nil {
+		t.Fatalf("TestBalancer: want %s removed after 2 failures, have %d %+v", URLs[1], failures, status)
+	}
+
+	// And restored by a probe once it recovers
+	atomic.StoreInt32(&downs[1], 0)
+	deadline := time.Now().Add(time.Second)
+	for !b.Endpoints()[1].Healthy {
+		if time.Now().After(deadline) {
+			t.Fatalf("TestBalancer: want %s restored", URLs[1])
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// With every endpoint removed, sends fail fast
+	for i := range downs {
+		atomic.StoreInt32(&downs[i], 1)
+	}
+	for i := 0; i < 6; i++ {
+		send()
+	}
+	if _, err = send(); !errors.Is(err, ErrNoEndpoints) || !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("TestBalancer: want ErrNoEndpoints, have %v", err)
+	}
+}
+
+func TestBalancerConsistentHash(t *testing.T) {
+	downs := make([]int32, 3)
+	URLs := []string{}
+	for i := range downs {
+		URL, ln := balancedServer(t, &downs[i])
+		defer ln.Close()
+		URLs = append(URLs, URL)
+	}
+	b, err := NewBalancer("POST", URLs, BalancerOptions{Balance: BalanceConsistentHash, MaxFailures: 1, HealthInterval: time.Minute})
+	if err != nil {
+		t.Fatalf("TestBalancerConsistentHash: %s", err.Error())
+	}
+	defer b.Close()
+	send := func(key string) string {
+		ces := jsonce.GenerateValidEvents(1)
+		ces[0].Extensions[PartitionKeyExtension] = key
+		_, served, _ := b.Send(context.Background(), ces, jsonce.ModeStructure)
+		return served
+	}
+
+	owners := map[string]string{}
+	used := map[string]bool{}
+	for k := 0; k < 30; k++ {
+		key := fmt.Sprintf("key-%d", k)
+		owners[key] = send(key)
+		used[owners[key]] = true
+		if again := send(key); again != owners[key] {
+			t.Fatalf("TestBalancerConsistentHash: %s moved from %s to %s", key, owners[key], again)
+		}
+	}
+	if len(used) != 3 {
+		t.Fatalf("TestBalancerConsistentHash: want keys spread over every endpoint, have %v", used)
+	}
+
+	// Removing an endpoint only moves the keys it owned
+	atomic.StoreInt32(&downs[0], 1)
+	for key, owner := range owners {
+		if owner == URLs[0] {
+			send(key)
+			break
+		}
+	}
+	for key, owner := range owners {
+		if have := send(key); owner != URLs[0] && have != owner {
+			t.Fatalf("TestBalancerConsistentHash: %s moved from %s to %s", key, owner, have)
+		} else if have == URLs[0] {
+			t.Fatalf("TestBalancerConsistentHash: %s sent to a removed endpoint", key)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package fastce
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+/*
+ ██████╗ ██████╗ ███████╗ █████╗ ██╗  ██╗███████╗██████╗
+ ██╔══██╗██╔══██╗██╔════╝██╔══██╗██║ ██╔╝██╔════╝██╔══██╗
+ ██████╔╝██████╔╝█████╗  ███████║█████╔╝ █████╗  ██████╔╝
+ ██╔══██╗██╔══██╗██╔══╝  ██╔══██║██╔═██╗ ██╔══╝  ██╔══██╗
+ ██████╔╝██║  ██║███████╗██║  ██║██║  ██╗███████╗██║  ██║
+ ╚═════╝ ╚═╝  ╚═╝╚══════╝╚═╝  ╚═╝╚═╝  ╚═╝╚══════╝╚═╝  ╚═╝
+*/
+
+// ErrCircuitOpen is wrapped by errors of requests refused by an open Breaker
+var ErrCircuitOpen = errors.New("Circuit open")
+
+// BreakerState is the state of the circuit of one target
+type BreakerState int
+
+const (
+	// BreakerClosed lets every request through
+	BreakerClosed BreakerState = iota
+	// BreakerOpen refuses every request until CoolDown has passed
+	BreakerOpen
+	// BreakerHalfOpen lets Probes requests through, closing if they all succeed
+	BreakerHalfOpen
+)
+
+// String returns the name of a state, eg. "open"
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("state(%d)", int(s))
+}
+
+// BreakerOpenError is returned instead of sending a request while the circuit of its target is open
+type BreakerOpenError struct {
+	Target     string
+	RetryAfter time.Duration // Until the circuit lets a probe through
+}
+
+// Error implements error
+func (e BreakerOpenError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("%s: %s for %s", ErrCircuitOpen.Error(), e.Target, e.RetryAfter)
+	}
+	return fmt.Sprintf("%s: %s", ErrCircuitOpen.Error(), e.Target)
+}
+
+// Unwrap allows errors.Is(err, ErrCircuitOpen)
+func (e BreakerOpenError) Unwrap() error {
+	return ErrCircuitOpen
+}
+
+// Breaker is a circuit breaker per target URL, shared by any number of CEClients and Clients
+// A circuit opens after ConsecutiveFailures, or once FailureRate of the requests in a Window
+// have failed. While open, requests fail immediately with a BreakerOpenError. After CoolDown,
+// Probes requests are let through, and the circuit closes if they all succeed or opens again.
+// Each attempt of a RetryPolicy is a request, and an open circuit is not retried.
+type Breaker struct {
+	ConsecutiveFailures int           // Optional, opens after this many failures in a row, defaults to 5
+	FailureRate         float64       // Optional, opens once this fraction of requests in a Window failed, eg. 0.5
+	MinRequests         int           // Optional, the requests in a Window before FailureRate applies, defaults to 10
+	Window              time.Duration // Optional, the period FailureRate is measured over, defaults to 10 seconds
+	CoolDown            time.Duration // Optional, how long a circuit stays open, defaults to 5 seconds
+	Probes              int           // Optional, the requests let through when half open, defaults to 1
+
+	// IsFailure decides whether a request failed, defaults to an error or a status of 500 or more
+	IsFailure func(status int, err error) bool
+	// OnStateChange is called after the circuit of a target changes state
+	OnStateChange func(target string, from, to BreakerState)
+	// Metrics optionally counts state changes and refused requests
+	Metrics Metrics
+
+	lock     sync.Mutex
+	circuits map[string]*circuit
+	now      func() time.Time // Replaced in tests
+}
+
+// circuit is the state of one target of a Breaker
+type circuit struct {
+	state    BreakerState
+	failures int       // In a row
+	requests int       // In the current window
+	failed   int       // In the current window
+	window   time.Time // When the current window started
+	opened   time.Time
+	probes   int // Let through while half open
+	passed   int // Probes which succeeded
+}
+
+// breakerTransition is a state change to report once the lock is released
+type breakerTransition struct {
+	target   string
+	from, to BreakerState
+}
+
+// State returns the state of the circuit of a target
+func (b *Breaker) State(target string) BreakerState {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if c, ok := b.circuits[target]; ok {
+		return c.state
+	}
+	return BreakerClosed
+}
+
+// Allow reports whether a request to target may be sent, returning a BreakerOpenError if not
+// If it may, done must be called with its outcome.
+func (b *Breaker) Allow(target string) (done func(status int, err error), err error) {
+	b.lock.Lock()
+	now := b.clock()
+	c := b.circuit(target, now)
+	var changes []breakerTransition
+	if c.state == BreakerOpen {
+		if wait := b.coolDown() - now.Sub(c.opened); wait > 0 {
+			b.lock.Unlock()
+			metricsOrNop(b.Metrics).Add(MetricBreakerRejections, 1, "target", target)
+			return nil, BreakerOpenError{Target: target, RetryAfter: wait}
+		}
+		changes = append(changes, b.transition(target, c, BreakerHalfOpen, now))
+	}
+	if c.state == BreakerHalfOpen {
+		if c.probes >= b.probes() {
+			b.lock.Unlock()
+			b.notify(changes)
+			metricsOrNop(b.Metrics).Add(MetricBreakerRejections, 1, "target", target)
+			return nil, BreakerOpenError{Target: target}
+		}
+		c.probes++
+	}
+	state := c.state
+	b.lock.Unlock()
+	b.notify(changes)
+
+	return func(status int, err error) {
+		b.record(target, state, status, err)
+	}, nil
+}
+
+// record counts the outcome of a request allowed in state
+func (b *Breaker) record(target string, state BreakerState, status int, err error) {
+	isFailure := b.IsFailure
+	if isFailure == nil {
+		isFailure = func(status int, err error) bool {
+			return err != nil || status >= fasthttp.StatusInternalServerError
+		}
+	}
+	failed := isFailure(status, err)
+
+	b.lock.Lock()
+	now := b.clock()
+	c := b.circuit(target, now)
+	var changes []breakerTransition
+	switch {
+	case c.state != state:
+		// The circuit changed while the request was in flight, so its outcome no longer applies
+	case c.state == BreakerHalfOpen && failed:
+		changes = append(changes, b.transition(target, c, BreakerOpen, now))
+	case c.state == BreakerHalfOpen:
+		if c.passed++; c.passed >= b.probes() {
+			changes = append(changes, b.transition(target, c, BreakerClosed, now))
+		}
+	case c.state == BreakerClosed:
+		c.requests++
+		if failed {
+			c.failed++
+			c.failures++
+		} else {
+			c.failures = 0
+		}
+		if b.tripped(c) {
+			changes = append(changes, b.transition(target, c, BreakerOpen, now))
+		}
+	}
+	b.lock.Unlock()
+	b.notify(changes)
+}
+
+// tripped reports whether a closed circuit has failed enough to open
+func (b *Breaker) tripped(c *circuit) bool {
+	consecutive := b.ConsecutiveFailures
+	if consecutive < 1 {
+		consecutive = 5
+	}
+	if c.failures >= consecutive {
+		return true
+	}
+	min := b.MinRequests
+	if min < 1 {
+		min = 10
+	}
+	return b.FailureRate > 0 && c.requests >= min && float64(c.failed)/float64(c.requests) >= b.FailureRate
+}
+
+// circuit returns the circuit of a target, starting a new window if the current one has passed
+func (b *Breaker) circuit(target string, now time.Time) *circuit {
+	if b.circuits == nil {
+		b.circuits = map[string]*circuit{}
+	}
+	c, ok := b.circuits[target]
+	if !ok {
+		c = &circuit{window: now}
+		b.circuits[target] = c
+	}
+	window := b.Window
+	if window <= 0 {
+		window = 10 * time.Second
+	}
+	if now.Sub(c.window) >= window {
+		c.window, c.requests, c.failed = now, 0, 0
+	}
+	return c
+}
+
+// transition changes the state of a circuit, resetting its counts
+func (b *Breaker) transition(target string, c *circuit, to BreakerState, now time.Time) breakerTransition {
+	t := breakerTransition{target: target, from: c.state, to: to}
+	c.state = to
+	c.failures, c.requests, c.failed, c.window = 0, 0, 0, now
+	c.probes, c.passed = 0, 0
+	if to == BreakerOpen {
+		c.opened = now
+	}
+	return t
+}
+
+// notify reports state changes to OnStateChange and Metrics
+func (b *Breaker) notify(changes []breakerTransition) {
+	for _, t := range changes {
+		metricsOrNop(b.Metrics).Add(MetricBreakerTransitions, 1, "target", t.target, "state", t.to.String())
+		if b.OnStateChange != nil {
+			b.OnStateChange(t.target, t.from, t.to)
+		}
+	}
+}
+
+func (b *Breaker) clock() time.Time {
+	if b.now != nil {
+		return b.now()
+	}
+	return time.Now()
+}
+
+func (b *Breaker) coolDown() time.Duration {
+	if b.CoolDown > 0 {
+		return b.CoolDown
+	}
+	return 5 * time.Second
+}
+
+func (b *Breaker) probes() int {
+	if b.Probes > 0 {
+		return b.Probes
+	}
+	return 1
+}
+
+// breakerTarget returns the target of a request, its URL without any query
+func breakerTarget(req *fasthttp.Request) string {
+	uri := req.URI()
+	return fmt.Sprintf("%s://%s%s", uri.Scheme(), uri.Host(), uri.Path())
+}
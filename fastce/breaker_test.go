@@ -0,0 +1,121 @@
+package fastce
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	jsonce "github.com/creativecactus/fast-cloudevents-go/jsonce"
+)
+
+func TestBreaker(t *testing.T) {
+	now := time.Unix(0, 0)
+	changes := []string{}
+	b := &Breaker{ConsecutiveFailures: 3, CoolDown: time.Second, OnStateChange: func(target string, from, to BreakerState) {
+		changes = append(changes, fmt.Sprintf("%s>%s", from, to))
+	}}
+	b.now = func() time.Time { return now }
+	fail := errors.New("refused")
+	request := func(status int, err error) error {
+		done, aerr := b.Allow("t")
+		if aerr == nil {
+			done(status, err)
+		}
+		return aerr
+	}
+
+	// Successes reset the count of consecutive failures
+	for _, status := range []int{500, 500, 200, 500, 500} {
+		if err := request(status, nil); err != nil {
+			t.Fatalf("TestBreaker: %s", err.Error())
+		}
+	}
+	if s := b.State("t"); s != BreakerClosed {
+		t.Fatalf("TestBreaker: want closed, have %s", s)
+	}
+	request(0, fail)
+	var oe BreakerOpenError
+	if err := request(200, nil); !errors.As(err, &oe) || oe.RetryAfter != time.Second || !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("TestBreaker: want BreakerOpenError, have %v", err)
+	}
+
+	// After CoolDown one probe is let through, and a failed probe opens the circuit again
+	now = now.Add(time.Second)
+	done, err := b.Allow("t")
+	if err != nil {
+		t.Fatalf("TestBreaker: want a probe, have %s", err.Error())
+	}
+	if err = request(200, nil); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("TestBreaker: want one probe only, have %v", err)
+	}
+	done(503, nil)
+	if s := b.State("t"); s != BreakerOpen {
+		t.Fatalf("TestBreaker: want open after a failed probe, have %s", s)
+	}
+
+	now = now.Add(time.Second)
+	if err = request(204, nil); err != nil {
+		t.Fatalf("TestBreaker: %s", err.Error())
+	}
+	if have := fmt.Sprint(changes); have != "[closed>open open>half-open half-open>open open>half-open half-open>closed]" {
+		t.Fatalf("TestBreaker: unexpected changes %s", have)
+	}
+}
+
+func TestBreakerFailureRate(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := &Breaker{FailureRate: 0.5, MinRequests: 4, Window: time.Minute}
+	b.now = func() time.Time { return now }
+	for i, status := range []int{200, 500, 200, 500} {
+		done, err := b.Allow("t")
+		if err != nil {
+			t.Fatalf("TestBreakerFailureRate: %d: %s", i, err.Error())
+		}
+		done(status, nil)
+	}
+	if s := b.State("t"); s != BreakerOpen {
+		t.Fatalf("TestBreakerFailureRate: want open, have %s", s)
+	}
+	if s := b.State("other"); s != BreakerClosed {
+		t.Fatalf("TestBreakerFailureRate: want other targets closed, have %s", s)
+	}
+}
+
+func TestClientBreaker(t *testing.T) {
+	srv := &CEServer{}
+	if err := srv.StartCE("127.0.0.1:0", jsonce.DefaultCEToMap, jsonce.DefaultMapToCE, func(ces jsonce.CloudEvents) (jsonce.CloudEvents, error) {
+		return nil, nil
+	}); err != nil {
+		t.Fatalf("TestClientBreaker: %s", err.Error())
+	}
+	addr := srv.Addr()
+	srv.Shutdown(context.Background())
+
+	reg := NewRegistry()
+	client, err := NewClient("POST", addr, ClientOptions{})
+	if err != nil {
+		t.Fatalf("TestClientBreaker: %s", err.Error())
+	}
+	client.Breaker = &Breaker{ConsecutiveFailures: 2, CoolDown: time.Minute, Metrics: reg}
+	attempts := 0
+	client.Retry = &RetryPolicy{MaxAttempts: 5, BaseDelay: time.Millisecond, OnAttempt: func(a Attempt) {
+		attempts++
+	}}
+
+	// The retries stop once the circuit opens, and later sends fail fast
+	ces := jsonce.GenerateValidEvents(1)
+	if _, err = client.Send(context.Background(), ces, jsonce.ModeStructure); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("TestClientBreaker: want ErrCircuitOpen, have %v", err)
+	}
+	if attempts != 3 {
+		t.Fatalf("TestClientBreaker: want 2 failures and 1 refusal, have %d attempts", attempts)
+	}
+	if _, err = client.Send(context.Background(), ces, jsonce.ModeStructure); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("TestClientBreaker: want ErrCircuitOpen, have %v", err)
+	}
+	if s := client.Breaker.State(addr + "/"); s != BreakerOpen {
+		t.Fatalf("TestClientBreaker: want %s/ open, have %s", addr, s)
+	}
+}
@@ -0,0 +1,280 @@
+package fastce
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	j "github.com/creativecactus/fast-cloudevents-go/jsonce"
+
+	"github.com/valyala/fasthttp"
+)
+
+/*
+  ██████╗██╗     ██╗███████╗███╗   ██╗████████╗
+ ██╔════╝██║     ██║██╔════╝████╗  ██║╚══██╔══╝
+ ██║     ██║     ██║█████╗  ██╔██╗ ██║   ██║
+ ██║     ██║     ██║██╔══╝  ██║╚██╗██║   ██║
+ ╚██████╗███████╗██║███████╗██║ ╚████║   ██║
+  ╚═════╝╚══════╝╚═╝╚══════╝╚═╝  ╚═══╝   ╚═╝
+*/
+
+// ClientOptions configures the connections and requests of a CEClient or Client
+// The zero value is ready to use.
+type ClientOptions struct {
+	Timeout             time.Duration // Optional, the limit on each attempt of a request, defaults to 30 seconds
+	DialTimeout         time.Duration // Optional, the limit on establishing a connection, defaults to 3 seconds
+	ReadTimeout         time.Duration // Optional, the limit on each read from a connection
+	WriteTimeout        time.Duration // Optional, the limit on each write to a connection
+	MaxConns            int           // Optional, connections per host, defaults to fasthttp.DefaultMaxConnsPerHost
+	MaxConnDuration     time.Duration // Optional, connections are closed once this old
+	MaxIdleConnDuration time.Duration // Optional, idle keep-alive connections are closed after this, defaults to fasthttp.DefaultMaxIdleConnDuration
+	DisableKeepAlive    bool          // Closes the connection after each request
+	IPv4Only            bool          // Dials IPv4 addresses only, rather than both IPv4 and IPv6
+
+	UserAgent string            // Optional, defaults to that of fasthttp
+	Headers   map[string]string // Optional, set on every request
+	TLS       *ClientTLS        // Optional, the certificates used for HTTPS, and enables HTTPS for any URL scheme
+}
+
+// hostClient creates the fasthttp client of a URL
+func (o ClientOptions) hostClient(URL *url.URL) (*fasthttp.HostClient, error) {
+	c := &fasthttp.HostClient{
+		Addr:                URL.Host,
+		Name:                o.UserAgent,
+		IsTLS:               URL.Scheme == "https", // See TLS for certificates
+		MaxConns:            o.MaxConns,
+		MaxConnDuration:     o.MaxConnDuration,
+		MaxIdleConnDuration: o.MaxIdleConnDuration,
+		ReadTimeout:         o.ReadTimeout,
+		WriteTimeout:        o.WriteTimeout,
+	}
+	dialTimeout := o.DialTimeout
+	if dialTimeout <= 0 {
+		dialTimeout = 3 * time.Second
+	}
+	c.Dial = func(addr string) (net.Conn, error) {
+		if o.IPv4Only {
+			return fasthttp.DialTimeout(addr, dialTimeout)
+		}
+		return fasthttp.DialDualStackTimeout(addr, dialTimeout)
+	}
+	if o.TLS != nil {
+		cfg, err := o.TLS.TLSConfig()
+		if err != nil {
+			return nil, fmt.Errorf("TLS failed: %s", err.Error())
+		}
+		c.IsTLS = true
+		c.TLSConfig = cfg
+	}
+	// fasthttp only adds the default port when it dials itself
+	if URL.Port() == "" {
+		port := "80"
+		if c.IsTLS {
+			port = "443"
+		}
+		c.Addr = net.JoinHostPort(URL.Hostname(), port)
+	}
+	return c, nil
+}
+
+// prepare sets the method, URL and default headers of a request
+func (o ClientOptions) prepare(req *fasthttp.Request, method, URL string) {
+	req.SetRequestURI(URL)
+	req.Header.SetMethod(method)
+	for k, v := range o.Headers {
+		req.Header.Set(k, v)
+	}
+	if o.DisableKeepAlive {
+		req.SetConnectionClose()
+	}
+}
+
+// Client sends events to one URL, and is safe for concurrent use
+// Each call to Send uses its own pooled request and response, sharing the connections of the Client.
+// Calls beyond MaxConns wait for a connection to become free. The exported fields must be set
+// before the first call to Send, and not changed after.
+type Client struct {
+	Method  string
+	URL     string
+	CEToMap j.CEToMap // Optional, defaults to jsonce.DefaultCEToMap
+	MapToCE j.MapToCE // Optional, defaults to jsonce.DefaultMapToCE
+
+	Limits        Limits         // Optional, bounds the events accepted in responses
+	Compression   Compression    // Optional, compresses requests and accepts compressed responses
+	Metrics       Metrics        // Optional, records measurements of requests, eg. a Registry
+	Logger        Logger         // Optional, receives a record for each request and rejected response
+	Signer        Signer         // Optional, adds credentials to each request as it is sent, must be safe for concurrent use
+	Retry         *RetryPolicy   // Optional, resends requests which fail in a retryable way
+	MaxRetryAfter time.Duration  // Optional, see CEClient.MaxRetryAfter
+	Breaker       *Breaker       // Optional, fails requests fast while the URL is failing
+	Modes         []j.Mode       // Optional, the modes SendNegotiated tries in order, defaults to DefaultModes
+	ProbeModes    bool           // Asks the URL which modes it accepts with OPTIONS before the first SendNegotiated
+	DeadLetter    DeadLetterSink // Optional, receives the events Send and Deliver fail to deliver, with extensions describing why
+	RequestHooks  []RequestHook  // Optional, see CEClient.RequestHooks, must be safe for concurrent use
+	ResponseHooks []ResponseHook // Optional, see CEClient.ResponseHooks, must be safe for concurrent use
+
+	opts  ClientOptions
+	host  *fasthttp.HostClient
+	once  sync.Once     // Applies Limits to host
+	slots chan struct{} // One per connection, held by each call
+	modes modeCache     // What SendNegotiated has learned of the modes of URL
+}
+
+// NewClient creates a Client for a given URI and method
+func NewClient(method, URLString string, opts ClientOptions) (*Client, error) {
+	URL, err := parseClientURL(URLString)
+	if err != nil {
+		return nil, err
+	}
+	host, err := opts.hostClient(URL)
+	if err != nil {
+		return nil, err
+	}
+	conns := opts.MaxConns
+	if conns <= 0 {
+		conns = fasthttp.DefaultMaxConnsPerHost
+	}
+	return &Client{
+		Method: method,
+		URL:    URL.String(),
+		opts:   opts,
+		host:   host,
+		slots:  make(chan struct{}, conns),
+	}, nil
+}
+
+// HostClient returns the underlying fasthttp client, eg. to read connection statistics
+func (c *Client) HostClient() *fasthttp.HostClient {
+	return c.host
+}
+
+// acquire waits for a free connection and returns a CEClient sharing the connections and settings of the Client
+// It must be released once the response has been read.
+func (c *Client) acquire(ctx context.Context) (*CEClient, error) {
+	select {
+	case c.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("HTTP Error: %w", ctx.Err())
+	}
+	c.once.Do(func() {
+		c.host.MaxResponseBodySize = c.Limits.MaxBodySize
+	})
+	cec := &CEClient{
+		Request:       fasthttp.AcquireRequest(),
+		Response:      fasthttp.AcquireResponse(),
+		Client:        c.host,
+		Limits:        c.Limits,
+		Compression:   c.Compression,
+		Metrics:       c.Metrics,
+		Logger:        c.Logger,
+		Signer:        c.Signer,
+		Retry:         c.Retry,
+		Timeout:       c.opts.Timeout,
+		MaxRetryAfter: c.MaxRetryAfter,
+		Breaker:       c.Breaker,
+		DeadLetter:    c.DeadLetter,
+		RequestHooks:  c.RequestHooks,
+		ResponseHooks: c.ResponseHooks,
+		shared:        true,
+	}
+	c.opts.prepare(cec.Request, c.Method, c.URL)
+	return cec, nil
+}
+
+// release returns a CEClient from acquire
+func (c *Client) release(cec *CEClient) {
+	cec.Release()
+	<-c.slots
+}
+
+// mappers returns the mappers of the Client, or the defaults
+func (c *Client) mappers() (j.CEToMap, j.MapToCE) {
+	CEToMap, MapToCE := c.CEToMap, c.MapToCE
+	if CEToMap == nil {
+		CEToMap = j.DefaultCEToMap
+	}
+	if MapToCE == nil {
+		MapToCE = j.DefaultMapToCE
+	}
+	return CEToMap, MapToCE
+}
+
+// Send sends events in the given mode and returns any events sent in reply
+// Statuses of 400 and above are returned as a StatusError, and per event results as a BatchError.
+// ctx limits the time spent on the request, including any retries. Events which fail are
+// passed to DeadLetter. Binary and structured modes send one event, so more are refused.
+func (c *Client) Send(ctx context.Context, ces j.CloudEvents, mode j.Mode) (res j.CloudEvents, err error) {
+	res, d, err := c.send(ctx, ces, mode)
+	if err != nil {
+		c.deadLetter(failedEvents(ces, d, err))
+	}
+	return res, err
+}
+
+// send implements Send without dead lettering, returning what a DeadLetter of the events would say
+func (c *Client) send(ctx context.Context, ces j.CloudEvents, mode j.Mode) (res j.CloudEvents, d DeadLetter, err error) {
+	if mode != j.ModeBatch && len(ces) > 1 {
+		err = fmt.Errorf("Could not send %d events in %s mode, which sends only one", len(ces), modeName(mode))
+		return nil, DeadLetter{Target: c.URL, Time: time.Now()}, err
+	}
+	CEToMap, MapToCE := c.mappers()
+	cec, err := c.acquire(ctx)
+	if err != nil {
+		return nil, DeadLetter{Target: c.URL, Time: time.Now()}, err
+	}
+	defer c.release(cec)
+
+	if err = cec.SendEvents(CEToMap, ces, mode); err != nil {
+		return nil, cec.failure(0), err
+	}
+	if err = cec.send(ctx); err != nil {
+		cec.responded(0, nil, err)
+		return nil, cec.failure(0), err
+	}
+	res, err = cec.replies(MapToCE)
+	return res, cec.failure(cec.Response.StatusCode()), err
+}
+
+// deadLetter passes annotated events to DeadLetter, if set
+func (c *Client) deadLetter(dead j.CloudEvents) {
+	sendDeadLetters(c.DeadLetter, c.Metrics, c.Logger, dead)
+}
+
+// Deliver is CEClient.Deliver for a Client, resending the events reported as retryable
+func (c *Client) Deliver(ctx context.Context, ces j.CloudEvents, mode j.Mode, attempts int) (res j.CloudEvents, err error) {
+	CEToMap, MapToCE := c.mappers()
+	cec, err := c.acquire(ctx)
+	if err != nil {
+		c.deadLetter(failedEvents(ces, DeadLetter{Target: c.URL, Time: time.Now()}, err))
+		return
+	}
+	defer c.release(cec)
+	return cec.deliver(ctx, CEToMap, MapToCE, ces, mode, attempts)
+}
+
+// replies reads the events of a response, or the error it describes, and passes them to ResponseHooks
+// Successful statuses without a body, such as 202 from an Async server, hold no events.
+// Statuses of 400 and above are returned as a StatusError, with any Retry-After.
+func (cec *CEClient) replies(MapToCE j.MapToCE) (res j.CloudEvents, err error) {
+	status := cec.Response.StatusCode()
+	defer func() {
+		cec.responded(status, res, err)
+	}()
+	switch {
+	case status == fasthttp.StatusNoContent, status < 300 && len(cec.Response.Body()) == 0:
+		return nil, nil
+	case status >= 400:
+		return nil, fmt.Errorf("HTTP Error: %w", StatusError{
+			Status:     status,
+			Err:        errors.New(string(cec.Response.Body())),
+			RetryAfter: ResponseRetryAfter(cec.Response),
+		})
+	}
+	res, _, err = cec.recvEvents(MapToCE)
+	return
+}
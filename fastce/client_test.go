@@ -0,0 +1,206 @@
+package fastce
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	jsonce "github.com/creativecactus/fast-cloudevents-go/jsonce"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestClientConcurrent(t *testing.T) {
+	headers := make(chan string, 100)
+	srv := &CEServer{}
+	err := srv.StartHandler("127.0.0.1:0", jsonce.DefaultCEToMap, jsonce.DefaultMapToCE, HandlerFunc(func(ctx context.Context, ces jsonce.CloudEvents) (jsonce.CloudEvents, error) {
+		meta, _ := MetadataFrom(ctx)
+		headers <- fmt.Sprintf("%s %s", meta.Header.Peek("X-Tenant"), meta.Header.UserAgent())
+		return ces, nil
+	}))
+	if err != nil {
+		t.Fatalf("TestClientConcurrent: %s", err.Error())
+	}
+	defer srv.Shutdown(context.Background())
+
+	client, err := NewClient("POST", srv.Addr(), ClientOptions{
+		MaxConns:  4,
+		UserAgent: "orders/1.0",
+		Headers:   map[string]string{"X-Tenant": "a"},
+	})
+	if err != nil {
+		t.Fatalf("TestClientConcurrent: %s", err.Error())
+	}
+
+	wg := sync.WaitGroup{}
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ces := jsonce.GenerateValidEvents(3)
+			for k := range ces {
+				ces[k].Id = fmt.Sprintf("%d-%d", i, k)
+			}
+			res, err := client.Send(context.Background(), ces, jsonce.ModeBatch)
+			if err == nil && (len(res) != 3 || res[0].Id != ces[0].Id) {
+				err = fmt.Errorf("%d: unexpected replies %v", i, res)
+			}
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("TestClientConcurrent: %s", err.Error())
+		}
+	}
+	if h := <-headers; h != "a orders/1.0" {
+		t.Fatalf("TestClientConcurrent: want default headers, have %q", h)
+	}
+}
+
+func TestClientContext(t *testing.T) {
+	srv := &CEServer{}
+	err := srv.StartCE("127.0.0.1:0", jsonce.DefaultCEToMap, jsonce.DefaultMapToCE, func(ces jsonce.CloudEvents) (jsonce.CloudEvents, error) {
+		if ces[0].Type == "slow" {
+			time.Sleep(500 * time.Millisecond)
+		}
+		return nil, Permanent(errors.New("Refused"))
+	})
+	if err != nil {
+		t.Fatalf("TestClientContext: %s", err.Error())
+	}
+	defer srv.Shutdown(context.Background())
+
+	client, err := NewClient("POST", srv.Addr(), ClientOptions{})
+	if err != nil {
+		t.Fatalf("TestClientContext: %s", err.Error())
+	}
+
+	// Statuses of 400 and above are returned as errors
+	var se StatusError
+	_, err = client.Send(context.Background(), jsonce.GenerateValidEvents(1), jsonce.ModeStructure)
+	if !errors.As(err, &se) || se.Status != fasthttp.StatusBadRequest {
+		t.Fatalf("TestClientContext: want a 400 StatusError, have %v", err)
+	}
+
+	// The deadline of the context limits the request
+	ces := jsonce.GenerateValidEvents(1)
+	ces[0].Type = "slow"
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err = client.Send(ctx, ces, jsonce.ModeStructure); err == nil {
+		t.Fatalf("TestClientContext: want a timeout")
+	}
+	if d := time.Since(start); d > 400*time.Millisecond {
+		t.Fatalf("TestClientContext: want the request abandoned at the deadline, took %s", d)
+	}
+}
+
+func TestClientAccepted(t *testing.T) {
+	handled := make(chan int, 10)
+	srv := &CEServer{Async: &Async{}}
+	err := srv.StartHandler("127.0.0.1:0", jsonce.DefaultCEToMap, jsonce.DefaultMapToCE, HandlerFunc(func(ctx context.Context, ces jsonce.CloudEvents) (jsonce.CloudEvents, error) {
+		handled <- len(ces)
+		return nil, nil
+	}))
+	if err != nil {
+		t.Fatalf("TestClientAccepted: %s", err.Error())
+	}
+	defer srv.Shutdown(context.Background())
+
+	sink := &deadLetters{}
+	client, err := NewClient("POST", srv.Addr(), ClientOptions{})
+	if err != nil {
+		t.Fatalf("TestClientAccepted: %s", err.Error())
+	}
+	client.DeadLetter = sink
+
+	// An Async server replies 202 without a body, which holds no events rather than failing
+	res, err := client.Send(context.Background(), jsonce.GenerateValidEvents(2), jsonce.ModeBatch)
+	if err != nil || len(res) != 0 {
+		t.Fatalf("TestClientAccepted: want no replies, have %v %v", res, err)
+	}
+	if _, err = client.Deliver(context.Background(), jsonce.GenerateValidEvents(2), jsonce.ModeBatch, 3); err != nil {
+		t.Fatalf("TestClientAccepted: %s", err.Error())
+	}
+	if _, err = client.Send(context.Background(), jsonce.GenerateValidEvents(1), jsonce.ModeBinary); err != nil {
+		t.Fatalf("TestClientAccepted: %s", err.Error())
+	}
+	if dead := sink.take(); len(dead) != 0 {
+		t.Fatalf("TestClientAccepted: want no dead letters, have %d", len(dead))
+	}
+
+	// Each event is handled once, so none were resent
+	total := 0
+	for total < 5 {
+		select {
+		case n := <-handled:
+			total += n
+		case <-time.After(5 * time.Second):
+			t.Fatalf("TestClientAccepted: want 5 events handled, have %d", total)
+		}
+	}
+	select {
+	case <-handled:
+		t.Fatalf("TestClientAccepted: want no events resent")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestClientDefaultPort(t *testing.T) {
+	for URL, addr := range map[string]string{
+		"http://localhost/events":      "localhost:80",
+		"https://localhost/events":     "localhost:443",
+		"http://[::1]/events":          "[::1]:80",
+		"http://localhost:8080/events": "localhost:8080",
+	} {
+		client, err := NewClient("POST", URL, ClientOptions{})
+		if err != nil {
+			t.Fatalf("TestClientDefaultPort: %s", err.Error())
+		}
+		if have := client.HostClient().Addr; have != addr {
+			t.Fatalf("TestClientDefaultPort: %s: want %s, have %s", URL, addr, have)
+		}
+	}
+}
+
+func TestClientSingleEventModes(t *testing.T) {
+	handled := make(chan int, 10)
+	srv := &CEServer{}
+	err := srv.StartHandler("127.0.0.1:0", jsonce.DefaultCEToMap, jsonce.DefaultMapToCE, HandlerFunc(func(ctx context.Context, ces jsonce.CloudEvents) (jsonce.CloudEvents, error) {
+		handled <- len(ces)
+		return nil, nil
+	}))
+	if err != nil {
+		t.Fatalf("TestClientSingleEventModes: %s", err.Error())
+	}
+	defer srv.Shutdown(context.Background())
+	sink := &deadLetters{}
+	client, err := NewClient("POST", srv.Addr(), ClientOptions{})
+	if err != nil {
+		t.Fatalf("TestClientSingleEventModes: %s", err.Error())
+	}
+	client.DeadLetter = sink
+
+	// Events a mode cannot send are refused rather than dropped
+	for _, mode := range []jsonce.Mode{jsonce.ModeStructure, jsonce.ModeBinary} {
+		if _, err = client.Send(context.Background(), jsonce.GenerateValidEvents(2), mode); err == nil {
+			t.Fatalf("TestClientSingleEventModes: want error in %s mode", modeName(mode))
+		}
+		if dead := sink.take(); len(dead) != 2 {
+			t.Fatalf("TestClientSingleEventModes: want 2 events dead lettered, have %d", len(dead))
+		}
+	}
+	select {
+	case n := <-handled:
+		t.Fatalf("TestClientSingleEventModes: want no request, have %d events handled", n)
+	default:
+	}
+}
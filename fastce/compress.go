@@ -0,0 +1,238 @@
+package fastce
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/valyala/fasthttp"
+)
+
+/*
+  ██████╗ ██████╗ ███╗   ███╗██████╗ ██████╗ ███████╗███████╗███████╗
+ ██╔════╝██╔═══██╗████╗ ████║██╔══██╗██╔══██╗██╔════╝██╔════╝██╔════╝
+ ██║     ██║   ██║██╔████╔██║██████╔╝██████╔╝█████╗  ███████╗███████╗
+ ██║     ██║   ██║██║╚██╔╝██║██╔═══╝ ██╔══██╗██╔══╝  ╚════██║╚════██║
+ ╚██████╗╚██████╔╝██║ ╚═╝ ██║██║     ██║  ██║███████╗███████║███████║
+  ╚═════╝ ╚═════╝ ╚═╝     ╚═╝╚═╝     ╚═╝  ╚═╝╚══════╝╚══════╝╚══════╝
+*/
+
+// Content-Encodings understood by GetEvents and RecvEvents, and usable for Compression
+// Brotli is not supported, to avoid an additional dependency.
+const (
+	EncodingIdentity = "identity"
+	EncodingGzip     = "gzip"
+	EncodingDeflate  = "deflate"
+	EncodingZstd     = "zstd"
+)
+
+// Encodings lists the supported Content-Encodings in order of preference
+var Encodings = []string{EncodingGzip, EncodingZstd, EncodingDeflate}
+
+// Compression configures the Content-Encoding of outgoing bodies
+// For a CEClient, requests are compressed with Encoding, and compressed responses are requested.
+// For a CEServer, responses are compressed only if the request's Accept-Encoding allows it,
+// preferring Encoding. The zero value of CEServer.Compression is DefaultCompression.
+type Compression struct {
+	Encoding string // The preferred encoding, EncodingIdentity disables compression
+	MinSize  int    // Bodies smaller than this many bytes are left uncompressed
+}
+
+// DefaultCompression is used by servers which do not configure Compression
+var DefaultCompression = Compression{
+	Encoding: EncodingGzip,
+	MinSize:  1024,
+}
+
+// enabled reports whether the Compression would ever compress anything
+func (c Compression) enabled() bool {
+	return len(c.Encoding) > 0 && c.Encoding != EncodingIdentity
+}
+
+// Decompress returns a ReqRes whose Body is decoded according to the Content-Encoding header
+// The decoded body is bounded by Limits.MaxBodySize to protect against decompression bombs,
+// or by fasthttp.DefaultMaxRequestBodySize if no limit is set.
+// Unknown encodings result in an error wrapping ErrUnsupportedMediaType.
+func (rr ReqRes) Decompress() (ReqRes, error) {
+	head, err := rr.Header()
+	if err != nil {
+		return rr, fmt.Errorf("Could not access Header: %s", err.Error())
+	}
+	encoding := string(head.Peek("Content-Encoding"))
+	if len(encoding) == 0 {
+		return rr, nil
+	}
+
+	body, err := rr.Body()
+	if err != nil {
+		return rr, fmt.Errorf("Could not read body: %s", err.Error())
+	}
+	max := rr.Limits.MaxBodySize
+	if max <= 0 {
+		max = fasthttp.DefaultMaxRequestBodySize
+	}
+
+	// Encodings are listed in the order they were applied
+	encodings := strings.Split(encoding, ",")
+	for i := len(encodings) - 1; i >= 0; i-- {
+		if body, err = decompress(encodings[i], body, max); err != nil {
+			return rr, err
+		}
+	}
+	rr.body = body
+	return rr, nil
+}
+
+// decompress decodes body in a single encoding, reading at most max bytes
+func decompress(encoding string, body []byte, max int) (p []byte, err error) {
+	var r io.Reader
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", EncodingIdentity:
+		return body, nil
+	case EncodingGzip, "x-gzip":
+		gr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("%w: Could not read gzip: %s", ErrInvalidEvent, err.Error())
+		}
+		defer gr.Close()
+		r = gr
+	case EncodingDeflate:
+		// HTTP deflate is zlib wrapped, but some senders use raw deflate
+		zr, err := zlib.NewReader(bytes.NewReader(body))
+		if err != nil {
+			zr = flate.NewReader(bytes.NewReader(body))
+		}
+		defer zr.Close()
+		r = zr
+	case EncodingZstd:
+		zr, err := zstd.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("%w: Could not read zstd: %s", ErrInvalidEvent, err.Error())
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		return nil, fmt.Errorf("%w: Content-Encoding %s", ErrUnsupportedMediaType, encoding)
+	}
+
+	p, err = ioutil.ReadAll(io.LimitReader(r, int64(max)+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: Could not decompress %s: %s", ErrInvalidEvent, encoding, err.Error())
+	}
+	if len(p) > max {
+		return nil, LimitError{Limit: "MaxBodySize", Max: max}
+	}
+	return p, nil
+}
+
+// compress encodes body in a single encoding
+func compress(encoding string, body []byte) (p []byte, err error) {
+	buf := bytes.Buffer{}
+	var w io.WriteCloser
+	switch encoding {
+	case EncodingGzip:
+		w = gzip.NewWriter(&buf)
+	case EncodingDeflate:
+		w = zlib.NewWriter(&buf)
+	case EncodingZstd:
+		if w, err = zstd.NewWriter(&buf); err != nil {
+			return nil, fmt.Errorf("Could not create zstd writer: %s", err.Error())
+		}
+	default:
+		return nil, fmt.Errorf("%w: Content-Encoding %s", ErrUnsupportedMediaType, encoding)
+	}
+	if _, err = w.Write(body); err != nil {
+		w.Close()
+		return nil, fmt.Errorf("Could not compress %s: %s", encoding, err.Error())
+	}
+	if err = w.Close(); err != nil {
+		return nil, fmt.Errorf("Could not compress %s: %s", encoding, err.Error())
+	}
+	return buf.Bytes(), nil
+}
+
+// CompressRequest encodes the body of a request with c.Encoding, if it is at least c.MinSize
+// It also advertises the supported encodings with Accept-Encoding, so that the response may be compressed.
+func CompressRequest(req *fasthttp.Request, c Compression) (err error) {
+	if !c.enabled() {
+		return nil
+	}
+	req.Header.Set("Accept-Encoding", strings.Join(Encodings, ", "))
+
+	body := req.Body()
+	if len(body) < c.MinSize || len(req.Header.Peek("Content-Encoding")) > 0 {
+		return nil
+	}
+	p, err := compress(c.Encoding, body)
+	if err != nil {
+		return err
+	}
+	req.SetBody(p)
+	req.Header.Set("Content-Encoding", c.Encoding)
+	return nil
+}
+
+// CompressResponse encodes the body of a response with an encoding accepted by the request, if any
+// c.Encoding is used if it is accepted, otherwise the first accepted of Encodings.
+// Bodies smaller than c.MinSize are left as they are.
+func CompressResponse(req *fasthttp.Request, res *fasthttp.Response, c Compression) (err error) {
+	if !c.enabled() {
+		return nil
+	}
+	res.Header.Add("Vary", "Accept-Encoding")
+
+	body := res.Body()
+	if len(body) < c.MinSize || len(res.Header.Peek("Content-Encoding")) > 0 {
+		return nil
+	}
+	encoding := negotiateEncoding(string(req.Header.Peek("Accept-Encoding")), c.Encoding)
+	if len(encoding) == 0 {
+		return nil
+	}
+	p, err := compress(encoding, body)
+	if err != nil {
+		return err
+	}
+	res.SetBody(p)
+	res.Header.Set("Content-Encoding", encoding)
+	return nil
+}
+
+// negotiateEncoding picks a supported encoding from an Accept-Encoding header, or "" for none
+func negotiateEncoding(accept string, preferred string) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := "1"
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q = strings.TrimPrefix(param, "q=")
+			}
+		}
+		accepted[name] = strings.Trim(q, "0.") != ""
+	}
+
+	ok := func(encoding string) bool {
+		if v, listed := accepted[encoding]; listed {
+			return v
+		}
+		return accepted["*"]
+	}
+	if ok(preferred) && preferred != EncodingIdentity {
+		return preferred
+	}
+	for _, encoding := range Encodings {
+		if ok(encoding) {
+			return encoding
+		}
+	}
+	return ""
+}
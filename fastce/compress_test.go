@@ -0,0 +1,118 @@
+package fastce
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	jsonce "github.com/creativecactus/fast-cloudevents-go/jsonce"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestCompressRequest(t *testing.T) {
+	for _, encoding := range Encodings {
+		for _, mode := range []jsonce.Mode{jsonce.ModeBinary, jsonce.ModeStructure, jsonce.ModeBatch} {
+			req := &fasthttp.Request{}
+			ces := jsonce.GenerateValidEvents(3)
+			if err := SendEvents(jsonce.DefaultCEToMap, req, ces, mode); err != nil {
+				t.Fatalf("TestCompressRequest: %s", err.Error())
+			}
+			plain := append([]byte{}, req.Body()...)
+			if err := CompressRequest(req, Compression{Encoding: encoding}); err != nil {
+				t.Fatalf("TestCompressRequest: %s: %s", encoding, err.Error())
+			}
+			if string(req.Header.Peek("Content-Encoding")) != encoding || bytes.Equal(plain, req.Body()) {
+				t.Fatalf("TestCompressRequest: %s: body was not compressed", encoding)
+			}
+
+			res, _, err := GetEvents(jsonce.DefaultMapToCE, req)
+			if err != nil {
+				t.Fatalf("TestCompressRequest: %s mode %d: %s", encoding, mode, err.Error())
+			}
+			if res[0].Id != ces[0].Id || res[0].Source != ces[0].Source {
+				t.Fatalf("TestCompressRequest: %s mode %d: event differs after decompression", encoding, mode)
+			}
+		}
+	}
+}
+
+func TestCompressMinSize(t *testing.T) {
+	req := &fasthttp.Request{}
+	if err := SendEvents(jsonce.DefaultCEToMap, req, jsonce.GenerateValidEvents(1), jsonce.ModeStructure); err != nil {
+		t.Fatalf("TestCompressMinSize: %s", err.Error())
+	}
+	if err := CompressRequest(req, Compression{Encoding: EncodingGzip, MinSize: 1 << 20}); err != nil {
+		t.Fatalf("TestCompressMinSize: %s", err.Error())
+	}
+	if len(req.Header.Peek("Content-Encoding")) > 0 {
+		t.Fatalf("TestCompressMinSize: small body was compressed")
+	}
+	if len(req.Header.Peek("Accept-Encoding")) == 0 {
+		t.Fatalf("TestCompressMinSize: compressed responses were not requested")
+	}
+}
+
+func TestDecompressBomb(t *testing.T) {
+	bomb, err := compress(EncodingGzip, make([]byte, 1<<20))
+	if err != nil {
+		t.Fatalf("TestDecompressBomb: %s", err.Error())
+	}
+	req := &fasthttp.Request{}
+	req.Header.SetContentType(jsonce.ModeStructure.ContentTypePlus("json"))
+	req.Header.Set("Content-Encoding", EncodingGzip)
+	req.SetBody(bomb)
+
+	_, _, err = GetEventsWithLimits(jsonce.DefaultMapToCE, req, Limits{MaxBodySize: 1024})
+	var le LimitError
+	if !errors.As(err, &le) || le.Limit != "MaxBodySize" {
+		t.Fatalf("TestDecompressBomb: want MaxBodySize LimitError, have %v", err)
+	}
+
+	req.Header.Set("Content-Encoding", "br")
+	_, _, err = GetEvents(jsonce.DefaultMapToCE, req)
+	if StatusFromError(err) != fasthttp.StatusUnsupportedMediaType {
+		t.Fatalf("TestDecompressBomb: want 415 for unknown encoding, have %v", err)
+	}
+}
+
+func TestCompressResponse(t *testing.T) {
+	ctx := &fasthttp.RequestCtx{}
+	ces := jsonce.GenerateValidEvents(20)
+	if err := SendEvents(jsonce.DefaultCEToMap, &ctx.Request, ces, jsonce.ModeBatch); err != nil {
+		t.Fatalf("TestCompressResponse: %s", err.Error())
+	}
+	ctx.Request.Header.Set("Accept-Encoding", "deflate;q=0.5, zstd, gzip;q=0")
+
+	(&CEServer{}).serveEvents(ctx, jsonce.DefaultCEToMap, jsonce.DefaultMapToCE, EventHandler(func(ces jsonce.CloudEvents) (jsonce.CloudEvents, error) {
+		return ces, nil
+	}))
+	if encoding := string(ctx.Response.Header.Peek("Content-Encoding")); encoding != EncodingZstd {
+		t.Fatalf("TestCompressResponse: want zstd response, have %q", encoding)
+	}
+
+	res, _, err := RecvEvents(jsonce.DefaultMapToCE, &ctx.Response)
+	if err != nil {
+		t.Fatalf("TestCompressResponse: %s", err.Error())
+	}
+	if len(res) != len(ces) {
+		t.Fatalf("TestCompressResponse: want %d events, have %d", len(ces), len(res))
+	}
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	for _, c := range []struct {
+		accept, preferred, want string
+	}{
+		{"", EncodingGzip, ""},
+		{"gzip, deflate", EncodingDeflate, EncodingDeflate},
+		{"gzip;q=0, deflate", EncodingGzip, EncodingDeflate},
+		{"*", EncodingZstd, EncodingZstd},
+		{"*, gzip;q=0.0", EncodingGzip, EncodingZstd},
+		{"br", EncodingGzip, ""},
+	} {
+		if have := negotiateEncoding(c.accept, c.preferred); have != c.want {
+			t.Errorf("TestNegotiateEncoding: %q preferring %s: want %q, have %q", c.accept, c.preferred, c.want, have)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package fastce
+
+import (
+	"context"
+	"net"
+
+	j "github.com/creativecactus/fast-cloudevents-go/jsonce"
+
+	"github.com/valyala/fasthttp"
+)
+
+/*
+  ██████╗ ██████╗ ███╗   ██╗████████╗███████╗██╗  ██╗████████╗
+ ██╔════╝██╔═══██╗████╗  ██║╚══██╔══╝██╔════╝╚██╗██╔╝╚══██╔══╝
+ ██║     ██║   ██║██╔██╗ ██║   ██║   █████╗   ╚███╔╝    ██║
+ ██║     ██║   ██║██║╚██╗██║   ██║   ██╔══╝   ██╔██╗    ██║
+ ╚██████╗╚██████╔╝██║ ╚████║   ██║   ███████╗██╔╝ ██╗   ██║
+  ╚═════╝ ╚═════╝ ╚═╝  ╚═══╝   ╚═╝   ╚══════╝╚═╝  ╚═╝   ╚═╝
+*/
+
+// Metadata describes the HTTP request a batch of events was received in
+// Header and RequestCtx are only valid until the handler returns.
+type Metadata struct {
+	Mode        j.Mode // The mode the events were received in, which is also used to reply
+	ContentType string
+	Method      string
+	Path        string
+	RemoteIP    net.IP
+
+	// TraceParent and TraceState are the W3C trace context headers of the request, if any
+	TraceParent string
+	TraceState  string
+
+	Principal *Principal // The sender of the request, if the server has an Authenticator
+
+	Header     *fasthttp.RequestHeader // The raw headers of the request
+	RequestCtx *fasthttp.RequestCtx    // For access to the underlying request and response
+}
+
+// metadataKey is the context key of *Metadata
+type metadataKey struct{}
+
+// WithMetadata returns a copy of ctx carrying meta
+func WithMetadata(ctx context.Context, meta Metadata) context.Context {
+	return context.WithValue(ctx, metadataKey{}, &meta)
+}
+
+// MetadataFrom returns the Metadata of the request being handled
+// ok is false if ctx was not created by a CEServer or WithMetadata.
+func MetadataFrom(ctx context.Context) (meta Metadata, ok bool) {
+	m, ok := ctx.Value(metadataKey{}).(*Metadata)
+	if !ok {
+		return
+	}
+	return *m, true
+}
+
+// newMetadata collects the Metadata of a request
+func newMetadata(ctx *fasthttp.RequestCtx, mode j.Mode) Metadata {
+	return Metadata{
+		Mode:        mode,
+		ContentType: string(ctx.Request.Header.ContentType()),
+		Method:      string(ctx.Method()),
+		Path:        string(ctx.Path()),
+		RemoteIP:    ctx.RemoteIP(),
+		TraceParent: string(ctx.Request.Header.Peek("traceparent")),
+		TraceState:  string(ctx.Request.Header.Peek("tracestate")),
+		Principal:   principalOf(ctx),
+		Header:      &ctx.Request.Header,
+		RequestCtx:  ctx,
+	}
+}
+
+// handlerContext creates the context passed to a Handler
+// It is cancelled when the client disconnects, HandlerTimeout passes, or Shutdown gives up waiting.
+// The returned cancel function must be called once the handler returns.
+func (srv *CEServer) handlerContext(ctx *fasthttp.RequestCtx, mode j.Mode) (context.Context, context.CancelFunc) {
+	parent := context.Background()
+	if state := srv.state; state != nil {
+		parent = state.ctx
+	}
+	parent = WithMetadata(parent, newMetadata(ctx, mode))
+
+	var hctx context.Context
+	var cancel context.CancelFunc
+	if srv.HandlerTimeout > 0 {
+		hctx, cancel = context.WithTimeout(parent, srv.HandlerTimeout)
+	} else {
+		hctx, cancel = context.WithCancel(parent)
+	}
+
+	stopWatch := func() {}
+	if conn := ctx.Conn(); conn != nil {
+		stopWatch = watchDisconnect(conn, cancel)
+	}
+	return hctx, func() {
+		stopWatch()
+		cancel()
+	}
+}
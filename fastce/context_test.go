@@ -0,0 +1,112 @@
+package fastce
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	jsonce "github.com/creativecactus/fast-cloudevents-go/jsonce"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestMetadata(t *testing.T) {
+	metas := make(chan Metadata, 1)
+	srv := &CEServer{}
+	err := srv.StartHandler("127.0.0.1:0", jsonce.DefaultCEToMap, jsonce.DefaultMapToCE, HandlerFunc(func(ctx context.Context, ces jsonce.CloudEvents) (jsonce.CloudEvents, error) {
+		meta, ok := MetadataFrom(ctx)
+		if !ok {
+			return nil, fmt.Errorf("no metadata")
+		}
+		meta.Header, meta.RequestCtx = nil, nil // Not valid after the handler returns
+		metas <- meta
+		return ces, nil
+	}))
+	if err != nil {
+		t.Fatalf("TestMetadata: %s", err.Error())
+	}
+	defer srv.Shutdown(context.Background())
+
+	cec, err := NewCEClient("POST", srv.Addr()+"/meta")
+	if err != nil {
+		t.Fatalf("TestMetadata: %s", err.Error())
+	}
+	defer cec.Release()
+	cec.Request.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	if _, err = ClientTester(cec, jsonce.GenerateValidEvents(2), jsonce.ModeBatch, 2); err != nil {
+		t.Fatalf("TestMetadata: %s", err.Error())
+	}
+
+	meta := <-metas
+	if meta.Mode != jsonce.ModeBatch || meta.Method != "POST" || meta.Path != "/meta" || !meta.RemoteIP.IsLoopback() {
+		t.Fatalf("TestMetadata: unexpected metadata %+v", meta)
+	}
+	if meta.ContentType != "application/cloudevents-batch+json" || meta.TraceParent != "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01" {
+		t.Fatalf("TestMetadata: unexpected headers %+v", meta)
+	}
+}
+
+func TestHandlerTimeout(t *testing.T) {
+	srv := &CEServer{HandlerTimeout: 10 * time.Millisecond}
+	handler := srv.RequestHandler(jsonce.DefaultCEToMap, jsonce.DefaultMapToCE, HandlerFunc(func(ctx context.Context, ces jsonce.CloudEvents) (jsonce.CloudEvents, error) {
+		<-ctx.Done()
+		return nil, Retryable(ctx.Err())
+	}))
+
+	ctx := &fasthttp.RequestCtx{}
+	if err := SendEvents(jsonce.DefaultCEToMap, &ctx.Request, jsonce.GenerateValidEvents(1), jsonce.ModeStructure); err != nil {
+		t.Fatalf("TestHandlerTimeout: %s", err.Error())
+	}
+	handler(ctx)
+	if code := ctx.Response.StatusCode(); code != fasthttp.StatusServiceUnavailable {
+		t.Fatalf("TestHandlerTimeout: want 503, have %d", code)
+	}
+}
+
+func TestHandlerGracefulShutdown(t *testing.T) {
+	entered := make(chan struct{}, 1)
+	release := make(chan struct{})
+	errs := make(chan error, 1)
+	srv := &CEServer{}
+	err := srv.StartHandler("127.0.0.1:0", jsonce.DefaultCEToMap, jsonce.DefaultMapToCE, HandlerFunc(func(ctx context.Context, ces jsonce.CloudEvents) (jsonce.CloudEvents, error) {
+		entered <- struct{}{}
+		<-release
+		errs <- ctx.Err()
+		return ces, nil
+	}))
+	if err != nil {
+		t.Fatalf("TestHandlerGracefulShutdown: %s", err.Error())
+	}
+
+	cec, err := NewCEClient("PUT", srv.Addr())
+	if err != nil {
+		t.Fatalf("TestHandlerGracefulShutdown: %s", err.Error())
+	}
+	defer cec.Release()
+	sent := make(chan error, 1)
+	go func() {
+		_, err := ClientTester(cec, jsonce.GenerateValidEvents(1), jsonce.ModeStructure, 1)
+		sent <- err
+	}()
+	<-entered
+
+	// Handlers may finish their work while the server is shutting down
+	stopped := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		stopped <- srv.Shutdown(ctx)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	if err = <-errs; err != nil {
+		t.Fatalf("TestHandlerGracefulShutdown: context cancelled during graceful shutdown: %s", err.Error())
+	}
+	if err = <-sent; err != nil {
+		t.Fatalf("TestHandlerGracefulShutdown: %s", err.Error())
+	}
+	if err = <-stopped; err != nil {
+		t.Fatalf("TestHandlerGracefulShutdown: %s", err.Error())
+	}
+}
@@ -0,0 +1,354 @@
+package fastce
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	j "github.com/creativecactus/fast-cloudevents-go/jsonce"
+)
+
+/*
+ ██████╗ ███████╗ █████╗ ██████╗   ██╗     ███████╗████████╗████████╗███████╗██████╗
+ ██╔══██╗██╔════╝██╔══██╗██╔══██╗  ██║     ██╔════╝╚══██╔══╝╚══██╔══╝██╔════╝██╔══██╗
+ ██║  ██║█████╗  ███████║██║  ██║  ██║     █████╗     ██║      ██║   █████╗  ██████╔╝
+ ██║  ██║██╔══╝  ██╔══██║██║  ██║  ██║     ██╔══╝     ██║      ██║   ██╔══╝  ██╔══██╗
+ ██████╔╝███████╗██║  ██║██████╔╝  ███████╗███████╗   ██║      ██║   ███████╗██║  ██║
+ ╚═════╝ ╚══════╝╚═╝  ╚═╝╚═════╝   ╚══════╝╚══════╝   ╚═╝      ╚═╝   ╚══════╝╚═╝  ╚═╝
+*/
+
+// The extensions describing why an event was dead lettered, see DeadLetter
+const (
+	DeadLetterStatusExtension   = "deadletterstatus"
+	DeadLetterErrorExtension    = "deadlettererror"
+	DeadLetterAttemptsExtension = "deadletterattempts"
+	DeadLetterTargetExtension   = "deadlettertarget"
+	DeadLetterTimeExtension     = "deadlettertime"
+)
+
+// deadLetterExtensions are removed by StripDeadLetter
+var deadLetterExtensions = []string{
+	DeadLetterStatusExtension,
+	DeadLetterErrorExtension,
+	DeadLetterAttemptsExtension,
+	DeadLetterTargetExtension,
+	DeadLetterTimeExtension,
+}
+
+// DeadLetterSink receives events which a client gave up on, see CEClient.DeadLetter
+// Its ctx is not that of the failed request, which is often done, but is limited by DeadLetterTimeout.
+type DeadLetterSink interface {
+	DeadLetter(ctx context.Context, ces j.CloudEvents) error
+}
+
+// DeadLetterFunc implements DeadLetterSink with a function
+type DeadLetterFunc func(ctx context.Context, ces j.CloudEvents) error
+
+// DeadLetter implements DeadLetterSink
+func (f DeadLetterFunc) DeadLetter(ctx context.Context, ces j.CloudEvents) error {
+	return f(ctx, ces)
+}
+
+// DeadLetter describes why an event was not delivered
+type DeadLetter struct {
+	Status   int       // Of the last response, 0 if there was none
+	Error    string    // The reason given for the event, or the error of its request
+	Attempts int       // Requests made with the event, including retries
+	Target   string    // The URL the event was sent to
+	Time     time.Time // When the event was given up on
+}
+
+// Annotate returns a copy of ce with the extensions describing d
+func (d DeadLetter) Annotate(ce j.CloudEvent) j.CloudEvent {
+	extensions := map[string]interface{}{}
+	for k, v := range ce.Extensions {
+		extensions[k] = v
+	}
+	extensions[DeadLetterStatusExtension] = strconv.Itoa(d.Status)
+	extensions[DeadLetterErrorExtension] = d.Error
+	extensions[DeadLetterAttemptsExtension] = strconv.Itoa(d.Attempts)
+	extensions[DeadLetterTargetExtension] = d.Target
+	extensions[DeadLetterTimeExtension] = d.Time.UTC().Format(time.RFC3339Nano)
+	ce.Extensions = extensions
+	return ce
+}
+
+// DeadLetterOf reads back the DeadLetter an event was annotated with
+func DeadLetterOf(ce j.CloudEvent) (d DeadLetter, ok bool) {
+	if _, ok = ce.Extensions[DeadLetterErrorExtension]; !ok {
+		return d, false
+	}
+	extension := func(name string) string {
+		return fmt.Sprint(ce.Extensions[name])
+	}
+	d.Status, _ = strconv.Atoi(extension(DeadLetterStatusExtension))
+	d.Error = extension(DeadLetterErrorExtension)
+	d.Attempts, _ = strconv.Atoi(extension(DeadLetterAttemptsExtension))
+	d.Target = extension(DeadLetterTargetExtension)
+	d.Time, _ = time.Parse(time.RFC3339Nano, extension(DeadLetterTimeExtension))
+	return d, true
+}
+
+// StripDeadLetter returns a copy of ce without the extensions added by DeadLetter.Annotate
+func StripDeadLetter(ce j.CloudEvent) j.CloudEvent {
+	extensions := map[string]interface{}{}
+	for k, v := range ce.Extensions {
+		if !j.InSlice(k, deadLetterExtensions) {
+			extensions[k] = v
+		}
+	}
+	ce.Extensions = extensions
+	return ce
+}
+
+// DeadLetterTimeout limits the time a DeadLetterSink may take to receive the events of one failure
+var DeadLetterTimeout = 30 * time.Second
+
+// sendDeadLetters passes annotated events to sink, if there is one
+// The events are still reported as failed to the caller, so errors of the sink are only logged.
+// The sink is not given the ctx of the request, as the events often failed because it is done.
+func sendDeadLetters(sink DeadLetterSink, metrics Metrics, logger Logger, dead j.CloudEvents) {
+	if sink == nil || len(dead) == 0 {
+		return
+	}
+	metrics = metricsOrNop(metrics)
+	ctx, cancel := context.WithTimeout(context.Background(), DeadLetterTimeout)
+	defer cancel()
+	if err := sink.DeadLetter(ctx, dead); err != nil {
+		metrics.Add(MetricDeadLetters, float64(len(dead)), "side", "client", "result", "failed")
+		logEvents(loggerOrNop(logger), LevelError, "Dead letter failed", dead, j.ModeBatch, "error", err)
+		return
+	}
+	metrics.Add(MetricDeadLetters, float64(len(dead)), "side", "client", "result", "ok")
+}
+
+// deadLetter passes annotated events to the DeadLetter sink of the client, if it has one
+func (cec *CEClient) deadLetter(dead j.CloudEvents) {
+	sendDeadLetters(cec.DeadLetter, cec.Metrics, cec.Logger, dead)
+}
+
+// failure returns a DeadLetter for the last request of the client
+func (cec *CEClient) failure(status int) DeadLetter {
+	return DeadLetter{
+		Status:   status,
+		Attempts: cec.attempts,
+		Target:   string(cec.Request.URI().FullURI()),
+		Time:     time.Now(),
+	}
+}
+
+// failedEvents returns the events which failed with err, annotated with d
+// A BatchError reports which events failed and why. Only those it reports OK are left out, as
+// others may not have been handled, and fail with err like every event of other errors.
+func failedEvents(ces j.CloudEvents, d DeadLetter, err error) (dead j.CloudEvents) {
+	if err == nil {
+		return nil
+	}
+	var be BatchError
+	results := map[[2]string]EventResult{}
+	if errors.As(err, &be) {
+		for _, r := range be.Results {
+			results[[2]string{r.Source, r.Id}] = r
+		}
+	}
+	for _, ce := range ces {
+		d.Error = err.Error()
+		if r, ok := results[[2]string{ce.Source, ce.Id}]; ok {
+			if r.Outcome == OutcomeOK {
+				continue
+			}
+			d.Error = r.Reason
+		}
+		dead = append(dead, d.Annotate(ce))
+	}
+	return dead
+}
+
+/*
+ ███████╗██╗██╗     ███████╗
+ ██╔════╝██║██║     ██╔════╝
+ █████╗  ██║██║     █████╗
+ ██╔══╝  ██║██║     ██╔══╝
+ ██║     ██║███████╗███████╗
+ ╚═╝     ╚═╝╚══════╝╚══════╝
+*/
+
+// FileDeadLetter writes dead letters to a file of JSON Lines, one event in structured JSON per line
+// Once the file would grow past MaxBytes it is renamed to Path.1, shifting older files up to Path.MaxFiles.
+// It is safe for concurrent use. Read the events back with RedriveFile.
+type FileDeadLetter struct {
+	Path     string
+	MaxBytes int64     // Optional, the size at which the file is rotated, defaults to 10 MiB
+	MaxFiles int       // Optional, the rotated files kept, defaults to 5
+	CEToMap  j.CEToMap // Optional, defaults to jsonce.DefaultCEToMap
+
+	lock sync.Mutex
+	file *os.File
+	size int64
+}
+
+// DeadLetter implements DeadLetterSink
+func (f *FileDeadLetter) DeadLetter(ctx context.Context, ces j.CloudEvents) error {
+	mapper := f.CEToMap
+	if mapper == nil {
+		mapper = j.DefaultCEToMap
+	}
+	lines := [][]byte{}
+	for _, ce := range ces {
+		cm := j.CEMap{}
+		if err := cm.FromCE(mapper, ce); err != nil {
+			return fmt.Errorf("Could not map event: %s", err.Error())
+		}
+		line, err := json.Marshal(cm)
+		if err != nil {
+			return fmt.Errorf("Could not marshal event: %s", err.Error())
+		}
+		lines = append(lines, append(line, '\n'))
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for _, line := range lines {
+		if err := f.rotate(int64(len(line))); err != nil {
+			return err
+		}
+		n, err := f.file.Write(line)
+		f.size += int64(n)
+		if err != nil {
+			return fmt.Errorf("Could not write dead letter: %s", err.Error())
+		}
+	}
+	return nil
+}
+
+// rotate opens the file, first rotating it if writing n more bytes would exceed MaxBytes
+func (f *FileDeadLetter) rotate(n int64) error {
+	maxBytes := f.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = 10 << 20
+	}
+	if f.file != nil && (f.size == 0 || f.size+n <= maxBytes) {
+		return nil
+	}
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+		maxFiles := f.MaxFiles
+		if maxFiles <= 0 {
+			maxFiles = 5
+		}
+		os.Remove(fmt.Sprintf("%s.%d", f.Path, maxFiles))
+		for i := maxFiles - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", f.Path, i), fmt.Sprintf("%s.%d", f.Path, i+1))
+		}
+		if err := os.Rename(f.Path, f.Path+".1"); err != nil {
+			return fmt.Errorf("Could not rotate dead letters: %s", err.Error())
+		}
+	}
+
+	file, err := os.OpenFile(f.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("Could not open dead letters: %s", err.Error())
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("Could not open dead letters: %s", err.Error())
+	}
+	f.file, f.size = file, info.Size()
+	if f.size > 0 && f.size+n > maxBytes {
+		return f.rotate(n)
+	}
+	return nil
+}
+
+// Close closes the file, which is reopened by the next DeadLetter
+func (f *FileDeadLetter) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// RedriveFile reads the events of a file written by FileDeadLetter and passes each to send, without
+// the extensions of its DeadLetter
+// It stops at the first error, returning the number of events sent, so a later call can skip them.
+func RedriveFile(ctx context.Context, path string, MapToCE j.MapToCE, send func(context.Context, j.CloudEvent) error) (sent int, err error) {
+	if MapToCE == nil {
+		MapToCE = j.DefaultMapToCE
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("Could not open dead letters: %s", err.Error())
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	for n := 1; ; n++ {
+		line, rerr := r.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			if err = ctx.Err(); err != nil {
+				return sent, err
+			}
+			cm := j.CEMap{}
+			if err = json.Unmarshal(line, &cm); err != nil {
+				return sent, fmt.Errorf("Could not read dead letter %d: %s", n, err.Error())
+			}
+			ce, err := cm.ToCE(MapToCE)
+			if err != nil {
+				return sent, fmt.Errorf("Could not read dead letter %d: %s", n, err.Error())
+			}
+			if err = send(ctx, StripDeadLetter(ce)); err != nil {
+				return sent, err
+			}
+			sent++
+		}
+		if rerr == io.EOF {
+			return sent, nil
+		} else if rerr != nil {
+			return sent, fmt.Errorf("Could not read dead letters: %s", rerr.Error())
+		}
+	}
+}
+
+/*
+ ██╗  ██╗████████╗████████╗██████╗
+ ██║  ██║╚══██╔══╝╚══██╔══╝██╔══██╗
+ ███████║   ██║      ██║   ██████╔╝
+ ██╔══██║   ██║      ██║   ██╔═══╝
+ ██║  ██║   ██║      ██║   ██║
+ ╚═╝  ╚═╝   ╚═╝      ╚═╝   ╚═╝
+*/
+
+// HTTPDeadLetter sends dead letters to another CloudEvents endpoint
+// Its Client should not itself have a DeadLetter sink.
+type HTTPDeadLetter struct {
+	Client *Client
+	Mode   j.Mode // Optional, defaults to binary, which sends one request per event
+}
+
+// DeadLetter implements DeadLetterSink
+func (h HTTPDeadLetter) DeadLetter(ctx context.Context, ces j.CloudEvents) error {
+	if h.Mode == j.ModeBatch {
+		_, err := h.Client.Send(ctx, ces, h.Mode)
+		return err
+	}
+	for i := range ces {
+		if _, err := h.Client.Send(ctx, ces[i:i+1], h.Mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,301 @@
+package fastce
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	jsonce "github.com/creativecactus/fast-cloudevents-go/jsonce"
+)
+
+// deadLetters records the events passed to it
+type deadLetters struct {
+	lock sync.Mutex
+	ces  jsonce.CloudEvents
+}
+
+func (d *deadLetters) DeadLetter(ctx context.Context, ces jsonce.CloudEvents) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.ces = append(d.ces, ces...)
+	return nil
+}
+
+func (d *deadLetters) take() jsonce.CloudEvents {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	ces := d.ces
+	d.ces = nil
+	return ces
+}
+
+func TestClientDeadLetter(t *testing.T) {
+	// A "busy" event always fails, and a "reject" event fails the whole request
+	srv := &CEServer{}
+	handler := HandlerFunc(func(ctx context.Context, ces jsonce.CloudEvents) (jsonce.CloudEvents, error) {
+		results := []EventResult{}
+		for _, ce := range ces {
+			if ce.Type == "reject" {
+				return nil, Permanent(errors.New("rejected"))
+			}
+			var err error
+			if ce.Type == "busy" {
+				err = Retryable(errors.New("busy"))
+			}
+			results = append(results, ResultOf(ce, err))
+		}
+		return nil, NewBatchError(results)
+	})
+	if err := srv.StartHandler("127.0.0.1:0", jsonce.DefaultCEToMap, jsonce.DefaultMapToCE, handler); err != nil {
+		t.Fatalf("TestClientDeadLetter: %s", err.Error())
+	}
+	defer srv.Shutdown(context.Background())
+
+	sink := &deadLetters{}
+	client, err := NewClient("POST", srv.Addr(), ClientOptions{})
+	if err != nil {
+		t.Fatalf("TestClientDeadLetter: %s", err.Error())
+	}
+	client.DeadLetter = sink
+
+	// Only the failed event of a batch is dead lettered
+	ces := jsonce.GenerateValidEvents(3)
+	ces[1].Type = "busy"
+	if _, err = client.Send(context.Background(), ces, jsonce.ModeBatch); err == nil {
+		t.Fatalf("TestClientDeadLetter: want error")
+	}
+	dead := sink.take()
+	if len(dead) != 1 || dead[0].Id != ces[1].Id {
+		t.Fatalf("TestClientDeadLetter: want event %s dead lettered, have %v", ces[1].Id, dead)
+	}
+	d, ok := DeadLetterOf(dead[0])
+	if !ok || d.Status != 207 || d.Attempts != 1 || d.Target == "" || d.Time.IsZero() {
+		t.Fatalf("TestClientDeadLetter: unexpected dead letter %+v", d)
+	}
+
+	// Deliver retries it before giving up
+	if _, err = client.Deliver(context.Background(), ces, jsonce.ModeBatch, 3); err == nil {
+		t.Fatalf("TestClientDeadLetter: want error")
+	}
+	dead = sink.take()
+	if len(dead) != 1 || dead[0].Id != ces[1].Id {
+		t.Fatalf("TestClientDeadLetter: want event %s dead lettered, have %v", ces[1].Id, dead)
+	}
+	if d, _ = DeadLetterOf(dead[0]); d.Attempts != 3 || d.Error == "" {
+		t.Fatalf("TestClientDeadLetter: unexpected dead letter %+v", d)
+	}
+
+	// A failed request dead letters every event with its error
+	ces[0].Type = "reject"
+	if _, err = client.Send(context.Background(), ces, jsonce.ModeBatch); err == nil {
+		t.Fatalf("TestClientDeadLetter: want error")
+	}
+	if dead = sink.take(); len(dead) != 3 {
+		t.Fatalf("TestClientDeadLetter: want 3 events dead lettered, have %d", len(dead))
+	}
+	if d, _ = DeadLetterOf(dead[2]); d.Status != 400 || d.Error != err.Error() {
+		t.Fatalf("TestClientDeadLetter: unexpected dead letter %+v", d)
+	}
+	if _, ok = DeadLetterOf(StripDeadLetter(dead[2])); ok {
+		t.Fatalf("TestClientDeadLetter: want extensions stripped")
+	}
+}
+
+func TestClientDeliverDeadLetter(t *testing.T) {
+	sink := &deadLetters{}
+	client, err := NewClient("POST", "http://127.0.0.1:1", ClientOptions{MaxConns: 1})
+	if err != nil {
+		t.Fatalf("TestClientDeliverDeadLetter: %s", err.Error())
+	}
+	client.DeadLetter = sink
+
+	// Events given up on while waiting for a connection are dead lettered too
+	client.slots <- struct{}{}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = client.Deliver(ctx, jsonce.GenerateValidEvents(2), jsonce.ModeBatch, 3); err == nil {
+		t.Fatalf("TestClientDeliverDeadLetter: want error")
+	}
+	dead := sink.take()
+	if len(dead) != 2 {
+		t.Fatalf("TestClientDeliverDeadLetter: want 2 events dead lettered, have %d", len(dead))
+	}
+	if d, _ := DeadLetterOf(dead[0]); d.Target != client.URL || d.Error != err.Error() {
+		t.Fatalf("TestClientDeliverDeadLetter: unexpected dead letter %+v", d)
+	}
+}
+
+func TestClientDeadLetterMissingResults(t *testing.T) {
+	// Only the first event gets a result, so the others may not have been handled
+	srv := &CEServer{}
+	err := srv.StartHandler("127.0.0.1:0", jsonce.DefaultCEToMap, jsonce.DefaultMapToCE, HandlerFunc(func(ctx context.Context, ces jsonce.CloudEvents) (jsonce.CloudEvents, error) {
+		return nil, BatchError{Results: []EventResult{ResultOf(ces[0], Permanent(errors.New("rejected")))}}
+	}))
+	if err != nil {
+		t.Fatalf("TestClientDeadLetterMissingResults: %s", err.Error())
+	}
+	defer srv.Shutdown(context.Background())
+
+	sink := &deadLetters{}
+	client, err := NewClient("POST", srv.Addr(), ClientOptions{})
+	if err != nil {
+		t.Fatalf("TestClientDeadLetterMissingResults: %s", err.Error())
+	}
+	client.DeadLetter = sink
+
+	ces := jsonce.GenerateValidEvents(3)
+	if _, err = client.Send(context.Background(), ces, jsonce.ModeBatch); err == nil {
+		t.Fatalf("TestClientDeadLetterMissingResults: want error")
+	}
+	dead := sink.take()
+	if len(dead) != 3 {
+		t.Fatalf("TestClientDeadLetterMissingResults: want 3 events dead lettered, have %d", len(dead))
+	}
+	if d, _ := DeadLetterOf(dead[0]); d.Error == err.Error() {
+		t.Fatalf("TestClientDeadLetterMissingResults: want the reason of the result, have %s", d.Error)
+	}
+	if d, _ := DeadLetterOf(dead[2]); d.Error != err.Error() {
+		t.Fatalf("TestClientDeadLetterMissingResults: want the error of the request, have %s", d.Error)
+	}
+}
+
+func TestSendNegotiatedDeadLetter(t *testing.T) {
+	srv, _ := negotiateServer(t, []jsonce.Mode{jsonce.ModeStructure})
+	defer srv.Shutdown(context.Background())
+	sink := &deadLetters{}
+	client, err := NewClient("POST", srv.Addr(), ClientOptions{})
+	if err != nil {
+		t.Fatalf("TestSendNegotiatedDeadLetter: %s", err.Error())
+	}
+	client.DeadLetter = sink
+
+	// Modes refused on the way are not failures of the events
+	if _, _, err = client.SendNegotiated(context.Background(), jsonce.GenerateValidEvents(2)); err != nil {
+		t.Fatalf("TestSendNegotiatedDeadLetter: %s", err.Error())
+	}
+	if dead := sink.take(); len(dead) != 0 {
+		t.Fatalf("TestSendNegotiatedDeadLetter: want no dead letters, have %d", len(dead))
+	}
+}
+
+func TestFileDeadLetter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deadletter")
+	if err != nil {
+		t.Fatalf("TestFileDeadLetter: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "dead.jsonl")
+
+	ces := jsonce.GenerateValidEvents(4)
+	for i := range ces {
+		ces[i] = DeadLetter{Status: 400, Error: "invalid", Attempts: 1}.Annotate(ces[i])
+	}
+	file := &FileDeadLetter{Path: path, MaxBytes: 1, MaxFiles: 2}
+	if err = file.DeadLetter(context.Background(), ces); err != nil {
+		t.Fatalf("TestFileDeadLetter: %s", err.Error())
+	}
+	file.Close()
+
+	// Each event is rotated into its own file, keeping the newest MaxFiles
+	if _, err = os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatalf("TestFileDeadLetter: want at most 2 rotated files")
+	}
+	for i, name := range []string{path + ".2", path + ".1", path} {
+		redriven := jsonce.CloudEvents{}
+		sent, err := RedriveFile(context.Background(), name, nil, func(ctx context.Context, ce jsonce.CloudEvent) error {
+			redriven = append(redriven, ce)
+			return nil
+		})
+		if err != nil || sent != 1 {
+			t.Fatalf("TestFileDeadLetter: want 1 event in %s, have %d %v", name, sent, err)
+		}
+		if redriven[0].Id != ces[i+1].Id {
+			t.Fatalf("TestFileDeadLetter: want event %s in %s, have %s", ces[i+1].Id, name, redriven[0].Id)
+		}
+		if _, ok := DeadLetterOf(redriven[0]); ok {
+			t.Fatalf("TestFileDeadLetter: want extensions stripped")
+		}
+	}
+
+	// RedriveFile stops at the first error
+	sent, err := RedriveFile(context.Background(), path+".1", nil, func(ctx context.Context, ce jsonce.CloudEvent) error {
+		return errors.New("down")
+	})
+	if err == nil || sent != 0 {
+		t.Fatalf("TestFileDeadLetter: want error, have %d sent", sent)
+	}
+}
+
+func TestHTTPDeadLetter(t *testing.T) {
+	received := &deadLetters{}
+	srv := &CEServer{}
+	if err := srv.StartHandler("127.0.0.1:0", jsonce.DefaultCEToMap, jsonce.DefaultMapToCE, HandlerFunc(func(ctx context.Context, ces jsonce.CloudEvents) (jsonce.CloudEvents, error) {
+		return nil, received.DeadLetter(ctx, ces)
+	})); err != nil {
+		t.Fatalf("TestHTTPDeadLetter: %s", err.Error())
+	}
+	defer srv.Shutdown(context.Background())
+	client, err := NewClient("POST", srv.Addr(), ClientOptions{})
+	if err != nil {
+		t.Fatalf("TestHTTPDeadLetter: %s", err.Error())
+	}
+
+	ces := jsonce.GenerateValidEvents(2)
+	for i := range ces {
+		ces[i] = DeadLetter{Status: 503, Error: "down", Attempts: 2, Target: "http://example.com"}.Annotate(ces[i])
+	}
+	if err = (HTTPDeadLetter{Client: client}).DeadLetter(context.Background(), ces); err != nil {
+		t.Fatalf("TestHTTPDeadLetter: %s", err.Error())
+	}
+	have := received.take()
+	if len(have) != 2 {
+		t.Fatalf("TestHTTPDeadLetter: want 2 events, have %d", len(have))
+	}
+	if d, ok := DeadLetterOf(have[1]); !ok || d.Status != 503 || d.Attempts != 2 || d.Target != "http://example.com" {
+		t.Fatalf("TestHTTPDeadLetter: unexpected dead letter %+v", d)
+	}
+}
+
+func TestHTTPDeadLetterExpired(t *testing.T) {
+	received := &deadLetters{}
+	dlq := &CEServer{}
+	if err := dlq.StartHandler("127.0.0.1:0", jsonce.DefaultCEToMap, jsonce.DefaultMapToCE, HandlerFunc(func(ctx context.Context, ces jsonce.CloudEvents) (jsonce.CloudEvents, error) {
+		return nil, received.DeadLetter(ctx, ces)
+	})); err != nil {
+		t.Fatalf("TestHTTPDeadLetterExpired: %s", err.Error())
+	}
+	defer dlq.Shutdown(context.Background())
+	slow := &CEServer{}
+	if err := slow.StartHandler("127.0.0.1:0", jsonce.DefaultCEToMap, jsonce.DefaultMapToCE, HandlerFunc(func(ctx context.Context, ces jsonce.CloudEvents) (jsonce.CloudEvents, error) {
+		time.Sleep(200 * time.Millisecond)
+		return nil, nil
+	})); err != nil {
+		t.Fatalf("TestHTTPDeadLetterExpired: %s", err.Error())
+	}
+	defer slow.Shutdown(context.Background())
+
+	dlqClient, err := NewClient("POST", dlq.Addr(), ClientOptions{})
+	if err != nil {
+		t.Fatalf("TestHTTPDeadLetterExpired: %s", err.Error())
+	}
+	client, err := NewClient("POST", slow.Addr(), ClientOptions{})
+	if err != nil {
+		t.Fatalf("TestHTTPDeadLetterExpired: %s", err.Error())
+	}
+	client.DeadLetter = HTTPDeadLetter{Client: dlqClient, Mode: jsonce.ModeBatch}
+
+	// Events which fail because ctx is done still reach the sink
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err = client.Send(ctx, jsonce.GenerateValidEvents(2), jsonce.ModeBatch); err == nil {
+		t.Fatalf("TestHTTPDeadLetterExpired: want error")
+	}
+	if have := received.take(); len(have) != 2 {
+		t.Fatalf("TestHTTPDeadLetterExpired: want 2 events, have %d", len(have))
+	}
+}
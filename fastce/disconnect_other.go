@@ -0,0 +1,12 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package fastce
+
+import "net"
+
+// watchDisconnect is not supported on this platform, so handlers are only cancelled
+// by shutdown or HandlerTimeout
+func watchDisconnect(conn net.Conn, cancel func()) (stop func()) {
+	return func() {}
+}
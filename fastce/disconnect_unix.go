@@ -0,0 +1,51 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package fastce
+
+import (
+	"net"
+	"syscall"
+	"time"
+)
+
+// watchDisconnect calls cancel if the peer closes conn, until the returned stop function is called
+// The request body has already been read, so the connection is only readable once the peer
+// closes it or sends another request. Data is peeked, never consumed.
+// Connections which do not expose a file descriptor, such as TLS connections, are not watched.
+func watchDisconnect(conn net.Conn, cancel func()) (stop func()) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return func() {}
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, 1)
+		raw.Read(func(fd uintptr) bool {
+			n, _, err := syscall.Recvfrom(int(fd), buf, syscall.MSG_PEEK)
+			for err == syscall.EINTR {
+				n, _, err = syscall.Recvfrom(int(fd), buf, syscall.MSG_PEEK)
+			}
+			if err == syscall.EAGAIN {
+				return false // Wait until readable
+			}
+			if err != nil || n == 0 {
+				cancel() // Reset, or closed by the peer
+			}
+			return true
+		})
+	}()
+
+	return func() {
+		// Wake the watcher, then clear the deadline before the server reads the next request
+		conn.SetReadDeadline(time.Now())
+		<-done
+		conn.SetReadDeadline(time.Time{})
+	}
+}
@@ -0,0 +1,57 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package fastce
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	jsonce "github.com/creativecactus/fast-cloudevents-go/jsonce"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestHandlerDisconnect(t *testing.T) {
+	cancelled := make(chan error, 1)
+	srv := &CEServer{}
+	err := srv.StartHandler("127.0.0.1:0", jsonce.DefaultCEToMap, jsonce.DefaultMapToCE, HandlerFunc(func(ctx context.Context, ces jsonce.CloudEvents) (jsonce.CloudEvents, error) {
+		select {
+		case <-ctx.Done():
+			cancelled <- ctx.Err()
+		case <-time.After(5 * time.Second):
+			cancelled <- nil
+		}
+		return nil, nil
+	}))
+	if err != nil {
+		t.Fatalf("TestHandlerDisconnect: %s", err.Error())
+	}
+	defer srv.Shutdown(context.Background())
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	if err = SendEvents(jsonce.DefaultCEToMap, req, jsonce.GenerateValidEvents(1), jsonce.ModeStructure); err != nil {
+		t.Fatalf("TestHandlerDisconnect: %s", err.Error())
+	}
+	req.SetRequestURI("/")
+	req.Header.SetMethod("POST")
+	req.Header.SetHost("localhost")
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("TestHandlerDisconnect: %s", err.Error())
+	}
+	if _, err = req.WriteTo(conn); err != nil {
+		t.Fatalf("TestHandlerDisconnect: %s", err.Error())
+	}
+	time.Sleep(50 * time.Millisecond) // Let the handler start
+	conn.Close()
+
+	if err = <-cancelled; !errors.Is(err, context.Canceled) {
+		t.Fatalf("TestHandlerDisconnect: want context.Canceled, have %v", err)
+	}
+}
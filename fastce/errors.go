@@ -0,0 +1,170 @@
+package fastce
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+/*
+ ███████╗██████╗ ██████╗  ██████╗ ██████╗ ███████╗
+ ██╔════╝██╔══██╗██╔══██╗██╔═══██╗██╔══██╗██╔════╝
+ █████╗  ██████╔╝██████╔╝██║   ██║██████╔╝███████╗
+ ██╔══╝  ██╔══██╗██╔══██╗██║   ██║██╔══██╗╚════██║
+ ███████╗██║  ██║██║  ██║╚██████╔╝██║  ██║███████║
+ ╚══════╝╚═╝  ╚═╝╚═╝  ╚═╝ ╚═════╝ ╚═╝  ╚═╝╚══════╝
+*/
+
+var (
+	// ErrInvalidEvent is wrapped by errors caused by a malformed or unmappable event (400)
+	ErrInvalidEvent = errors.New("Invalid event")
+	// ErrUnsupportedMediaType is wrapped by errors caused by an unknown content type (415)
+	ErrUnsupportedMediaType = errors.New("Unsupported media type")
+	// ErrTooLarge is wrapped by errors caused by a request exceeding some limit (413)
+	ErrTooLarge = errors.New("Too large")
+	// ErrTooManyRequests is wrapped by errors caused by a sender exceeding some rate (429)
+	ErrTooManyRequests = errors.New("Too many requests")
+	// ErrUnavailable is wrapped by errors which may succeed if retried later (503)
+	ErrUnavailable = errors.New("Unavailable")
+	// ErrPermanent is wrapped by errors which will not succeed if retried (400)
+	ErrPermanent = errors.New("Permanent failure")
+	// ErrUnauthorized is wrapped by errors caused by missing or invalid credentials (401)
+	ErrUnauthorized = errors.New("Unauthorized")
+)
+
+// StatusError attaches an HTTP status to an error, as returned by Retryable and Permanent
+// Handlers may also return a StatusError directly to choose any status.
+type StatusError struct {
+	Err        error
+	Status     int
+	RetryAfter time.Duration // Sent as a Retry-After header if greater than 0
+}
+
+// Error implements error
+func (e StatusError) Error() string {
+	if e.Err == nil {
+		return fasthttp.StatusMessage(e.Status)
+	}
+	return e.Err.Error()
+}
+
+// Unwrap allows errors.Is and errors.As to inspect the underlying error
+func (e StatusError) Unwrap() error {
+	return e.Err
+}
+
+// StatusCode returns the HTTP status of the error
+func (e StatusError) StatusCode() int {
+	return e.Status
+}
+
+// Retryable marks a handler error as temporary, such that the sender may retry (503)
+func Retryable(err error) error {
+	return StatusError{
+		Err:    fmt.Errorf("%w: %s", ErrUnavailable, err.Error()),
+		Status: fasthttp.StatusServiceUnavailable,
+	}
+}
+
+// RetryableAfter marks a handler error as temporary, and suggests when the sender should retry (503)
+func RetryableAfter(err error, after time.Duration) error {
+	return StatusError{
+		Err:        fmt.Errorf("%w: %s", ErrUnavailable, err.Error()),
+		Status:     fasthttp.StatusServiceUnavailable,
+		RetryAfter: after,
+	}
+}
+
+// Permanent marks a handler error as permanent, such that the sender should not retry (400)
+func Permanent(err error) error {
+	return StatusError{
+		Err:    fmt.Errorf("%w: %s", ErrPermanent, err.Error()),
+		Status: fasthttp.StatusBadRequest,
+	}
+}
+
+// invalidEvent marks an error from reading events as ErrInvalidEvent
+// Errors which already map to a status other than 500 are returned as they are.
+func invalidEvent(err error) error {
+	if StatusFromError(err) != fasthttp.StatusInternalServerError {
+		return err
+	}
+	return fmt.Errorf("%w: %s", ErrInvalidEvent, err.Error())
+}
+
+// statusCoder is implemented by errors which know their own HTTP status, such as StatusError
+type statusCoder interface {
+	StatusCode() int
+}
+
+// StatusFromError determines the HTTP status a server should respond with for a given error
+// Errors carrying their own status (StatusError, RouteError) take precedence, then the
+// sentinel errors of this package. Anything else is an internal server error (500).
+func StatusFromError(err error) int {
+	var sc statusCoder
+	switch {
+	case err == nil:
+		return fasthttp.StatusOK
+	case errors.As(err, &sc):
+		return sc.StatusCode()
+	case errors.Is(err, ErrInvalidEvent), errors.Is(err, ErrPermanent):
+		return fasthttp.StatusBadRequest
+	case errors.Is(err, ErrUnauthorized):
+		return fasthttp.StatusUnauthorized
+	case errors.Is(err, ErrUnsupportedMediaType):
+		return fasthttp.StatusUnsupportedMediaType
+	case errors.Is(err, ErrTooLarge):
+		return fasthttp.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrTooManyRequests):
+		return fasthttp.StatusTooManyRequests
+	case errors.Is(err, ErrUnavailable):
+		return fasthttp.StatusServiceUnavailable
+	default:
+		return fasthttp.StatusInternalServerError
+	}
+}
+
+// ErrorResponder writes an error to a response
+// The default choice is DefaultErrorResponder
+type ErrorResponder func(ctx *fasthttp.RequestCtx, err error)
+
+// DefaultErrorResponder responds with the status from StatusFromError and the error as plain text
+// Retry-After is set for errors which carry a StatusError with RetryAfter
+func DefaultErrorResponder(ctx *fasthttp.RequestCtx, err error) {
+	ctx.Error(err.Error(), StatusFromError(err)) // Overwrites any body/headers
+	var se StatusError
+	if errors.As(err, &se) && se.RetryAfter > 0 {
+		ctx.Response.Header.Set("Retry-After", retryAfterSeconds(se.RetryAfter))
+	}
+}
+
+// ResponseRetryAfter returns the wait requested by the Retry-After header of a response, or 0
+// Both delay-seconds and HTTP-date values are understood.
+func ResponseRetryAfter(res *fasthttp.Response) time.Duration {
+	v := string(res.Header.Peek("Retry-After"))
+	if len(v) == 0 {
+		return 0
+	}
+	if s, err := strconv.ParseInt(v, 10, 64); err == nil {
+		if s < 0 {
+			return 0
+		}
+		return time.Duration(s) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// retryAfterSeconds formats a duration as a Retry-After value, rounding up to whole seconds
+func retryAfterSeconds(d time.Duration) string {
+	s := int64((d + time.Second - 1) / time.Second)
+	return strconv.FormatInt(s, 10)
+}
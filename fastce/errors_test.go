@@ -0,0 +1,95 @@
+package fastce
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	jsonce "github.com/creativecactus/fast-cloudevents-go/jsonce"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestStatusFromError(t *testing.T) {
+	for want, err := range map[int]error{
+		fasthttp.StatusOK:                    nil,
+		fasthttp.StatusBadRequest:            fmt.Errorf("wrapped: %w", ErrInvalidEvent),
+		fasthttp.StatusUnsupportedMediaType:  fmt.Errorf("wrapped: %w", ErrUnsupportedMediaType),
+		fasthttp.StatusRequestEntityTooLarge: fmt.Errorf("wrapped: %w", ErrTooLarge),
+		fasthttp.StatusTooManyRequests:       ErrTooManyRequests,
+		fasthttp.StatusServiceUnavailable:    fmt.Errorf("wrapped: %w", Retryable(errors.New("busy"))),
+		fasthttp.StatusConflict:              StatusError{Status: fasthttp.StatusConflict},
+		fasthttp.StatusInternalServerError:   errors.New("unknown"),
+	} {
+		if have := StatusFromError(err); have != want {
+			t.Errorf("TestStatusFromError: %v: want %d, have %d", err, want, have)
+		}
+	}
+	if have := StatusFromError(Permanent(errors.New("bad"))); have != fasthttp.StatusBadRequest {
+		t.Errorf("TestStatusFromError: Permanent: want 400, have %d", have)
+	}
+}
+
+func TestServeEventsStatus(t *testing.T) {
+	echo := func(ces jsonce.CloudEvents) (jsonce.CloudEvents, error) {
+		return ces, nil
+	}
+	serve := func(srv CEServer, handler EventHandler, prepare func(req *fasthttp.Request)) *fasthttp.Response {
+		ctx := &fasthttp.RequestCtx{}
+		err := SendEvents(jsonce.DefaultCEToMap, &ctx.Request, jsonce.GenerateValidEvents(2), jsonce.ModeBatch)
+		if err != nil {
+			t.Fatalf("TestServeEventsStatus: %s", err.Error())
+		}
+		if prepare != nil {
+			prepare(&ctx.Request)
+		}
+		srv.serveEvents(ctx, jsonce.DefaultCEToMap, jsonce.DefaultMapToCE, handler)
+		return &ctx.Response
+	}
+
+	if res := serve(CEServer{}, echo, nil); res.StatusCode() != fasthttp.StatusOK {
+		t.Errorf("TestServeEventsStatus: echo: want 200, have %d", res.StatusCode())
+	}
+
+	res := serve(CEServer{}, echo, func(req *fasthttp.Request) {
+		req.Header.SetContentType("application/cloudevents-batch+xml")
+	})
+	if res.StatusCode() != fasthttp.StatusUnsupportedMediaType {
+		t.Errorf("TestServeEventsStatus: media type: want 415, have %d", res.StatusCode())
+	}
+
+	res = serve(CEServer{}, echo, func(req *fasthttp.Request) {
+		req.SetBodyString(`[{"id":"no source"}]`)
+	})
+	if res.StatusCode() != fasthttp.StatusBadRequest {
+		t.Errorf("TestServeEventsStatus: invalid: want 400, have %d", res.StatusCode())
+	}
+
+	res = serve(CEServer{}, func(ces jsonce.CloudEvents) (jsonce.CloudEvents, error) {
+		return nil, RetryableAfter(errors.New("busy"), 1500*time.Millisecond)
+	}, nil)
+	if res.StatusCode() != fasthttp.StatusServiceUnavailable || string(res.Header.Peek("Retry-After")) != "2" {
+		t.Errorf("TestServeEventsStatus: retryable: want 503 after 2, have %d after %q", res.StatusCode(), res.Header.Peek("Retry-After"))
+	}
+
+	noReply := func(ces jsonce.CloudEvents) (jsonce.CloudEvents, error) {
+		return nil, nil
+	}
+	if res = serve(CEServer{}, noReply, nil); res.StatusCode() != fasthttp.StatusNoContent {
+		t.Errorf("TestServeEventsStatus: no reply: want 204, have %d", res.StatusCode())
+	}
+	if res = serve(CEServer{NoReplyStatus: fasthttp.StatusAccepted}, noReply, nil); res.StatusCode() != fasthttp.StatusAccepted {
+		t.Errorf("TestServeEventsStatus: no reply: want 202, have %d", res.StatusCode())
+	}
+
+	teapot := CEServer{ErrorResponder: func(ctx *fasthttp.RequestCtx, err error) {
+		ctx.SetStatusCode(fasthttp.StatusTeapot)
+	}}
+	res = serve(teapot, func(ces jsonce.CloudEvents) (jsonce.CloudEvents, error) {
+		return nil, errors.New("any")
+	}, nil)
+	if res.StatusCode() != fasthttp.StatusTeapot {
+		t.Errorf("TestServeEventsStatus: responder: want 418, have %d", res.StatusCode())
+	}
+}
@@ -3,8 +3,7 @@ package fastce
 import (
 	"fmt"
 	"log"
-	"net"
-	"net/http"
+	"time"
 
 	j "github.com/creativecactus/fast-cloudevents-go/jsonce"
 
@@ -100,34 +99,30 @@ func SimpleServer(listenAddr string) error {
 	// In cases where HTTP level actions need to be taken (setting headers, routing), use ListenAndServeHTTP
 	// In cases where an external server is used and you don't want to pass the request down to this server,
 	// you can use the fastce.Get*/Set* functions directly (Send*/Recv* for clients).
-	return CEServer{}.ListenAndServeCE(listenAddr, j.DefaultCEToMap, MyMapToCE, handler)
+	// The Logger receives a record for every event accepted or rejected
+	srv := &CEServer{Logger: NewStdLogger(nil, LevelInfo)}
+	// Middleware wraps the handler, outermost first
+	srv.Use(Recovery(), Validate(false))
+	if err := srv.StartCE(listenAddr, j.DefaultCEToMap, MyMapToCE, handler); err != nil {
+		return err
+	}
+	// Allow handlers up to 10 seconds to finish on SIGINT or SIGTERM
+	return srv.ShutdownOnSignal(10 * time.Second)
 }
 
 // ExampleServer shows an example implementation with a fasthttp server.
 // listenAddr should be an interface:port such as 0.0.0.0:0. If port is 0, next available free port is used
 // handler is a function to handle fasthttp.RequestCtx, such as ExampleHandler
-// Returns the server created, a channel for receiving fatal errors (nil if .Shutdown() gracefully),
-// the address used to listen (useful if the provided listenAddr has a 0 port), any init error.
-func ExampleServer(listenAddr string, handler func(ctx *fasthttp.RequestCtx)) (server *fasthttp.Server, shutdownErr <-chan error, addr string, err error) {
-	server = &fasthttp.Server{
-		Handler: handler,
-	}
-
-	listener, err := net.Listen("tcp", listenAddr)
-	if err != nil {
+// Returns the started server, whose Addr() is useful if the provided listenAddr has a 0 port.
+// Call server.Shutdown(ctx) to stop it, and server.Wait() to receive any fatal error.
+func ExampleServer(listenAddr string, handler func(ctx *fasthttp.RequestCtx)) (server *CEServer, err error) {
+	server = &CEServer{}
+	if err = server.Start(listenAddr, handler); err != nil {
 		err = fmt.Errorf("Listen error: %s", err.Error())
 		return
 	}
-	listenAddr = fmt.Sprintf("http://%s", listener.Addr().String())
-
-	shutdown := make(chan error)
-	log.Printf("Listening on %s", listenAddr)
-	go func() {
-		err := server.Serve(listener)
-		shutdown <- err
-	}()
-	shutdownErr = shutdown
-	return server, shutdownErr, listenAddr, nil
+	log.Printf("Listening on %s", server.Addr())
+	return server, nil
 }
 
 // ExampleHandler shows an example implementation of a fasthttp requestCtx handler.
@@ -144,7 +139,7 @@ func ExampleHandler(ctx *fasthttp.RequestCtx) {
 		ces, mode, err := GetEvents(j.DefaultMapToCE, &ctx.Request)
 		if err != nil {
 			log.Printf("ERR: %s", err.Error())
-			ctx.Error(err.Error(), http.StatusBadRequest)
+			DefaultErrorResponder(ctx, err)
 			return
 		} else {
 			log.Printf("OK : Received %d events in mode %d\n", len(ces), mode)
@@ -153,3 +148,24 @@ func ExampleHandler(ctx *fasthttp.RequestCtx) {
 		SetEvents(j.DefaultCEToMap, &ctx.Response, ces, mode)
 	}
 }
+
+// ExampleMux shows an example of routing events to handlers with a Mux
+// Orders are handled on any path, and sensor readings only on /sensors/*
+// Anything else is echoed back by the default handler.
+func ExampleMux() *Mux {
+	mux := &Mux{
+		Unmatched: UnmatchedDefault,
+		Default: func(ces j.CloudEvents) (j.CloudEvents, error) {
+			return ces, nil
+		},
+	}
+	mux.HandleTypePrefix("com.example.order.", func(ces j.CloudEvents) (j.CloudEvents, error) {
+		log.Printf("OK : Received %d orders\n", len(ces))
+		return nil, nil
+	})
+	mux.HandleSource("/sensors/*", func(ces j.CloudEvents) (j.CloudEvents, error) {
+		log.Printf("OK : Received %d readings\n", len(ces))
+		return nil, nil
+	}, "/sensors/*")
+	return mux
+}
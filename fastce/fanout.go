@@ -0,0 +1,175 @@
+package fastce
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	j "github.com/creativecactus/fast-cloudevents-go/jsonce"
+)
+
+/*
+ ███████╗ █████╗ ███╗   ██╗       ██████╗ ██╗   ██╗████████╗
+ ██╔════╝██╔══██╗████╗  ██║      ██╔═══██╗██║   ██║╚══██╔══╝
+ █████╗  ███████║██╔██╗ ██║█████╗██║   ██║██║   ██║   ██║
+ ██╔══╝  ██╔══██║██║╚██╗██║╚════╝██║   ██║██║   ██║   ██║
+ ██║     ██║  ██║██║ ╚████║      ╚██████╔╝╚██████╔╝   ██║
+ ╚═╝     ╚═╝  ╚═╝╚═╝  ╚═══╝       ╚═════╝  ╚═════╝    ╚═╝
+*/
+
+// FanOutPolicy decides how many targets of a FanOut must succeed
+type FanOutPolicy int
+
+const (
+	// FanOutAll requires every target to succeed
+	FanOutAll FanOutPolicy = iota
+	// FanOutAny requires at least one target to succeed
+	FanOutAny
+	// FanOutQuorum requires FanOut.Quorum targets to succeed
+	FanOutQuorum
+)
+
+// String implements fmt.Stringer
+func (p FanOutPolicy) String() string {
+	switch p {
+	case FanOutAll:
+		return "all"
+	case FanOutAny:
+		return "any"
+	case FanOutQuorum:
+		return "quorum"
+	}
+	return fmt.Sprintf("FanOutPolicy(%d)", int(p))
+}
+
+// FanOutTarget is one destination of a FanOut
+type FanOutTarget struct {
+	Name   string  // Optional, identifies the target in results, defaults to the URL of Client
+	Client *Client // The connections, options and retries used for this target
+	Mode   j.Mode  // The mode the events are encoded in for this target, binary and structured send only one event
+}
+
+// name returns the name of a target
+func (t FanOutTarget) name() string {
+	if t.Name != "" {
+		return t.Name
+	}
+	return t.Client.URL
+}
+
+// TargetResult is the outcome of sending to one target of a FanOut
+type TargetResult struct {
+	Target   string
+	Replies  j.CloudEvents // Any events sent in reply
+	Err      error         // As returned by Client.Send, nil if the target succeeded
+	Duration time.Duration
+}
+
+// FanOutError is returned by FanOut.Send when fewer targets succeed than the policy requires
+type FanOutError struct {
+	Policy    FanOutPolicy
+	Required  int
+	Succeeded int
+	Results   []TargetResult // One per target, in the order of FanOut.Targets
+}
+
+// Error implements error
+func (e FanOutError) Error() string {
+	reasons := []string{}
+	for _, r := range e.Failed() {
+		reasons = append(reasons, fmt.Sprintf("%s: %s", r.Target, r.Err.Error()))
+	}
+	return fmt.Sprintf("Fan-out (%s) needed %d of %d targets, %d succeeded: %s",
+		e.Policy, e.Required, len(e.Results), e.Succeeded, strings.Join(reasons, "; "))
+}
+
+// Unwrap returns the error of the first failed target, so errors.Is and errors.As can inspect it
+func (e FanOutError) Unwrap() error {
+	if failed := e.Failed(); len(failed) > 0 {
+		return failed[0].Err
+	}
+	return nil
+}
+
+// Failed returns the results of the targets which failed
+func (e FanOutError) Failed() (failed []TargetResult) {
+	for _, r := range e.Results {
+		if r.Err != nil {
+			failed = append(failed, r)
+		}
+	}
+	return
+}
+
+// FanOut sends the same events to several targets concurrently
+// Each target encodes the events with SendEvents in its own mode, using its own Client.
+type FanOut struct {
+	Targets []FanOutTarget
+	Policy  FanOutPolicy // Optional, defaults to FanOutAll
+	Quorum  int          // Optional, the targets FanOutQuorum requires, defaults to a majority
+}
+
+// required returns the number of targets which must succeed
+func (f *FanOut) required() int {
+	switch f.Policy {
+	case FanOutAny:
+		return 1
+	case FanOutQuorum:
+		if f.Quorum > 0 && f.Quorum <= len(f.Targets) {
+			return f.Quorum
+		}
+		return len(f.Targets)/2 + 1
+	}
+	return len(f.Targets)
+}
+
+// Send sends events to every target and waits for each to finish
+// The results are in the order of Targets. If fewer targets succeed than the policy requires,
+// the error is a FanOutError holding the same results.
+func (f *FanOut) Send(ctx context.Context, ces j.CloudEvents) (results []TargetResult, err error) {
+	if len(f.Targets) == 0 {
+		return nil, errors.New("Fan-out: no targets")
+	}
+	for i, t := range f.Targets {
+		if t.Client == nil {
+			return nil, fmt.Errorf("Fan-out: target %d has no Client", i)
+		}
+	}
+
+	results = make([]TargetResult, len(f.Targets))
+	wg := sync.WaitGroup{}
+	for i, t := range f.Targets {
+		wg.Add(1)
+		go func(i int, t FanOutTarget) {
+			defer wg.Done()
+			start := time.Now()
+			replies, err := t.Client.Send(ctx, ces, t.Mode)
+			results[i] = TargetResult{
+				Target:   t.name(),
+				Replies:  replies,
+				Err:      err,
+				Duration: time.Since(start),
+			}
+		}(i, t)
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, r := range results {
+		if r.Err == nil {
+			succeeded++
+		}
+	}
+	if required := f.required(); succeeded < required {
+		return results, FanOutError{
+			Policy:    f.Policy,
+			Required:  required,
+			Succeeded: succeeded,
+			Results:   results,
+		}
+	}
+	return results, nil
+}
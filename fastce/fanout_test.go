@@ -0,0 +1,93 @@
+package fastce
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	jsonce "github.com/creativecactus/fast-cloudevents-go/jsonce"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestFanOut(t *testing.T) {
+	modes := make(chan jsonce.Mode, 10)
+	targets := []FanOutTarget{}
+	for i, mode := range []jsonce.Mode{jsonce.ModeBatch, jsonce.ModeStructure, jsonce.ModeBinary} {
+		refuse := i == 2
+		srv := &CEServer{}
+		err := srv.StartHandler("127.0.0.1:0", jsonce.DefaultCEToMap, jsonce.DefaultMapToCE, HandlerFunc(func(ctx context.Context, ces jsonce.CloudEvents) (jsonce.CloudEvents, error) {
+			meta, _ := MetadataFrom(ctx)
+			modes <- meta.Mode
+			if refuse {
+				return nil, Permanent(errors.New("Refused"))
+			}
+			return nil, nil
+		}))
+		if err != nil {
+			t.Fatalf("TestFanOut: %s", err.Error())
+		}
+		defer srv.Shutdown(context.Background())
+		client, err := NewClient("POST", srv.Addr(), ClientOptions{})
+		if err != nil {
+			t.Fatalf("TestFanOut: %s", err.Error())
+		}
+		targets = append(targets, FanOutTarget{Client: client, Mode: mode})
+	}
+	targets[0].Name = "audit"
+
+	ces := jsonce.GenerateValidEvents(1)
+	f := &FanOut{Targets: targets}
+	results, err := f.Send(context.Background(), ces)
+	var fe FanOutError
+	if !errors.As(err, &fe) || fe.Required != 3 || fe.Succeeded != 2 || len(fe.Failed()) != 1 {
+		t.Fatalf("TestFanOut: want a FanOutError with 2 of 3, have %v", err)
+	}
+	var se StatusError
+	if !errors.As(err, &se) || se.Status != fasthttp.StatusBadRequest {
+		t.Fatalf("TestFanOut: want the 400 of the failed target, have %v", err)
+	}
+	if results[0].Target != "audit" || results[0].Err != nil || results[2].Target != targets[2].Client.URL || results[2].Err == nil {
+		t.Fatalf("TestFanOut: unexpected results %+v", results)
+	}
+	seen := map[jsonce.Mode]bool{}
+	for i := 0; i < 3; i++ {
+		seen[<-modes] = true
+	}
+	if len(seen) != 3 {
+		t.Fatalf("TestFanOut: want each target sent its own mode, have %v", seen)
+	}
+
+	for _, c := range []struct {
+		policy FanOutPolicy
+		quorum int
+		ok     bool
+	}{
+		{FanOutAny, 0, true},
+		{FanOutQuorum, 0, true},
+		{FanOutQuorum, 3, false},
+	} {
+		f = &FanOut{Targets: targets, Policy: c.policy, Quorum: c.quorum}
+		if _, err = f.Send(context.Background(), ces); (err == nil) != c.ok {
+			t.Fatalf("TestFanOut: %s %d: want ok %v, have %v", c.policy, c.quorum, c.ok, err)
+		}
+		for i := 0; i < 3; i++ {
+			<-modes
+		}
+	}
+
+	// Targets in single event modes fail to send several events, rather than dropping all but one
+	f = &FanOut{Targets: targets, Policy: FanOutAny}
+	results, err = f.Send(context.Background(), jsonce.GenerateValidEvents(2))
+	if err != nil || results[0].Err != nil || results[1].Err == nil || results[2].Err == nil {
+		t.Fatalf("TestFanOut: want only the batch target to succeed, have %+v %v", results, err)
+	}
+	if mode := <-modes; mode != jsonce.ModeBatch {
+		t.Fatalf("TestFanOut: want a batch request, have %s", modeName(mode))
+	}
+	select {
+	case mode := <-modes:
+		t.Fatalf("TestFanOut: want no %s request", modeName(mode))
+	default:
+	}
+}
@@ -1,11 +1,13 @@
 package fastce
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
-	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -28,55 +30,176 @@ type CEServer struct {
 	// Ctx *fasthttp.RequestCtx
 	Listener net.Listener // Optional, if an external listener is used by the server
 	Server   *fasthttp.Server
-	Address  string // For reading back the bound address, in case it was changed (eg. port=0)
+	Address  string // For reading back the bound address, in case it was changed (eg. port=0), see Addr
+
+	ErrorResponder ErrorResponder // Optional, writes errors to responses, defaults to DefaultErrorResponder
+	NoReplyStatus  int            // Optional, the status used when a handler returns no events, defaults to 204
+	Limits         Limits         // Optional, bounds the events accepted in requests
+	Compression    Compression    // Optional, compresses responses if requested, defaults to DefaultCompression
+	TLS            *ServerTLS     // Optional, serves HTTPS instead of HTTP
+	Middleware     []Middleware   // Optional, wraps every handler of the server, see Use
+	HandlerTimeout time.Duration  // Optional, the deadline of the context passed to handlers
+	Async          *Async         // Optional, replies 202 and handles events in the background
+	Metrics        Metrics        // Optional, records measurements of requests, eg. a Registry
+	MetricsPath    string         // Optional, serves Metrics on this path if they implement MetricsServer, eg. /metrics
+	Logger         Logger         // Optional, receives a record for each accepted or rejected event
+	PanicEvent     *PanicEvent    // Optional, replies with CloudEvents describing a panic instead of plain text
+	RateLimits     []*RateLimit   // Optional, refuses events over any of these limits with 429
+	Authenticator  Authenticator  // Optional, refuses requests without valid credentials with 401
+	Modes          []j.Mode       // Optional, the content modes accepted, others are refused with 415, defaults to every mode
+
+	state *serverState // Set by Start
 }
 
 // ListenAndServe simply sets up the underlying server and net.Listener
 // You can also call srv.Server.ListenAndServe() directly if using your own server
-// This will overwrite the Server and Listener
-func (srv CEServer) ListenAndServeHTTP(addr string, handler func(*fasthttp.RequestCtx)) (err error) {
-	srv.Server = &fasthttp.Server{
-		Handler: handler,
-	}
-	if srv.Listener, err = net.Listen("tcp", addr); err != nil {
-		err = fmt.Errorf("Listener failed: %s", err.Error())
+// This will overwrite the Server, and the Listener unless one was provided
+// It blocks until the server stops, see Start to serve in the background
+func (srv *CEServer) ListenAndServeHTTP(addr string, handler func(*fasthttp.RequestCtx)) (err error) {
+	if err = srv.Start(addr, handler); err != nil {
 		return
 	}
-	srv.Address = fmt.Sprintf("http://%s", srv.Listener.Addr().String())
-	return srv.Server.Serve(srv.Listener)
+	return srv.Wait()
 }
 
 // ListenAndServeCE simply sets up the underlying server and net.Listener with a default HTTP handler
 // You can also call srv.Server.ListenAndServe() directly if using your own server
-// This will overwrite the Server and Listener
-func (srv CEServer) ListenAndServeCE(addr string, CEToMap j.CEToMap, MapToCE j.MapToCE, handler func(j.CloudEvents) (j.CloudEvents, error)) (err error) {
-	return srv.ListenAndServeHTTP(addr, func(ctx *fasthttp.RequestCtx) {
-		ces, mode, err := GetEventsCtx(MapToCE, ctx)
-		if err != nil {
-			err = fmt.Errorf("Get Events: %s", err.Error())
-			ctx.Error(err.Error(), fasthttp.StatusInternalServerError) // Overwrites any body/headers
+// This will overwrite the Server and Listener. The Middleware of the server wraps handler
+func (srv *CEServer) ListenAndServeCE(addr string, CEToMap j.CEToMap, MapToCE j.MapToCE, handler func(j.CloudEvents) (j.CloudEvents, error)) (err error) {
+	return srv.ListenAndServeHTTP(addr, srv.ceHandler(CEToMap, MapToCE, handler))
+}
+
+// ListenAndServeHandler is ListenAndServeCE for a Handler, which receives a context carrying the Metadata of each request
+func (srv *CEServer) ListenAndServeHandler(addr string, CEToMap j.CEToMap, MapToCE j.MapToCE, handler Handler) (err error) {
+	return srv.ListenAndServeHTTP(addr, srv.RequestHandler(CEToMap, MapToCE, handler))
+}
+
+// ceHandler wraps an EventHandler as a fasthttp request handler using the settings of the server
+func (srv *CEServer) ceHandler(CEToMap j.CEToMap, MapToCE j.MapToCE, handler EventHandler) func(*fasthttp.RequestCtx) {
+	return srv.RequestHandler(CEToMap, MapToCE, handler)
+}
+
+// serveEvents reads the events of a request, passes them to handler and writes any result
+// to the response in the same mode as the request
+// Errors are written with the ErrorResponder of the server.
+func (srv *CEServer) serveEvents(ctx *fasthttp.RequestCtx, CEToMap j.CEToMap, MapToCE j.MapToCE, handler Handler) {
+	if ctx.IsOptions() {
+		srv.serveOptions(ctx)
+		return
+	}
+	respond := srv.ErrorResponder
+	if respond == nil {
+		respond = DefaultErrorResponder
+	}
+	metrics := metricsOrNop(srv.Metrics)
+
+	// The outcome is recorded once the response status is known
+	start := time.Now()
+	var received j.CloudEvents
+	var mode j.Mode
+	var failure error
+	var results []EventResult
+	fail := func(err error) {
+		failure = err
+		respond(ctx, err)
+	}
+	defer func() {
+		status := ctx.Response.StatusCode()
+		metrics.Add(MetricResponses, 1, "side", "server", "code", strconv.Itoa(status))
+		if srv.Logger != nil {
+			logOutcome(srv.Logger, received, mode, results, status, time.Since(start), failure)
+		}
+	}()
+	metrics.Observe(MetricBodySize, float64(len(ctx.Request.Body())), "side", "server")
+
+	if err := srv.authenticate(ctx); err != nil {
+		fail(fmt.Errorf("Authenticate: %w", err))
+		return
+	}
+
+	if m, err := ReqResFromReq(&ctx.Request).GetMode(); err == nil && !srv.acceptsMode(m) {
+		fail(fmt.Errorf("Get Events: %w: %s mode", ErrUnsupportedMediaType, modeName(m)))
+		return
+	}
+
+	ces, mode, err := GetEventsWithLimits(MapToCE, &ctx.Request, srv.Limits)
+	if err != nil {
+		metrics.Add(MetricDecodeFailures, 1, "side", "server", "reason", failureReason(err))
+		fail(fmt.Errorf("Get Events: %w", err))
+		return
+	}
+	received = ces
+	countEvents(metrics, MetricEventsReceived, "server", ces, mode)
+	metrics.Observe(MetricBatchSize, float64(len(ces)), "side", "server")
+
+	if err = srv.allowRates(ctx, mode, ces); err != nil {
+		fail(fmt.Errorf("Rate Limit: %w", err))
+		return
+	}
+
+	if state := srv.state; state != nil && state.queue != nil {
+		if err = srv.serveAsync(ctx, state.queue, mode, ces, handler); err != nil {
+			fail(fmt.Errorf("Enqueue Events: %w", err))
 			return
 		}
+		ctx.SetStatusCode(fasthttp.StatusAccepted)
+		return
+	}
 
-		ces, err = handler(ces)
+	hctx, cancel := srv.handlerContext(ctx, mode)
+	ces, err = callHandler(hctx, handler, ces)
+	cancel()
+	var be BatchError
+	var pe PanicError
+	if errors.As(err, &pe) {
+		logPanic(srv.Logger, pe, "method", string(ctx.Method()), "path", string(ctx.Path()))
+		if srv.PanicEvent == nil {
+			fail(fmt.Errorf("Handle Events: %w", err))
+			return
+		}
+		// Callers expecting CloudEvents still receive some, describing the failure
+		failure = err
+		if ces, err = srv.PanicEvent.Events(received, pe); err == nil {
+			err = SetEvents(CEToMap, &ctx.Response, ces, mode)
+		}
 		if err != nil {
-			err = fmt.Errorf("Handle Events: %s", err.Error())
-			ctx.Error(err.Error(), fasthttp.StatusInternalServerError) // Overwrites any body/headers
+			fail(fmt.Errorf("Set Panic Events: %w", err))
 			return
 		}
-
-		if len(ces) > 0 {
-			err = SetEventsCtx(CEToMap, ctx, ces, mode)
-			if err != nil {
-				err = fmt.Errorf("Set Events: %s", err.Error())
-				ctx.Error(err.Error(), fasthttp.StatusInternalServerError) // Overwrites any body/headers
-				return
-			}
-		} else {
-			ctx.SuccessString(mode.ContentTypePlus("json"), "Success")
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		return
+	} else if errors.As(err, &be) {
+		// Some events failed, so the outcome of each is sent instead of any replies
+		results = be.Results
+		if err = SetBatchStatus(&ctx.Response, be); err != nil {
+			fail(fmt.Errorf("Set Batch Status: %w", err))
+			return
 		}
+	} else if err != nil {
+		fail(fmt.Errorf("Handle Events: %w", err))
 		return
-	})
+	} else if len(ces) < 1 {
+		status := srv.NoReplyStatus
+		if status == 0 {
+			status = fasthttp.StatusNoContent
+		}
+		ctx.SetStatusCode(status)
+		return
+	} else if err = SetEvents(CEToMap, &ctx.Response, ces, mode); err != nil {
+		fail(fmt.Errorf("Set Events: %w", err))
+		return
+	} else {
+		countEvents(metrics, MetricEventsSent, "server", ces, mode)
+	}
+
+	compression := srv.Compression
+	if compression == (Compression{}) {
+		compression = DefaultCompression
+	}
+	if err = CompressResponse(&ctx.Request, &ctx.Response, compression); err != nil {
+		fail(fmt.Errorf("Compress Events: %w", err))
+		return
+	}
 }
 
 // CEClient is a convenience wrapper around SendEvents and RecvEvents
@@ -85,16 +208,64 @@ type CEClient struct {
 	Response *fasthttp.Response
 	Released bool
 	Client   *fasthttp.HostClient
+	Limits   Limits // Optional, bounds the events accepted in responses
+
+	Compression Compression    // Optional, compresses requests and accepts compressed responses
+	Metrics     Metrics        // Optional, records measurements of requests, eg. a Registry
+	Logger      Logger         // Optional, receives a record for each request and rejected response
+	Signer      Signer         // Optional, adds credentials to each request as it is sent
+	Retry       *RetryPolicy   // Optional, resends requests which fail in a retryable way
+	Timeout     time.Duration  // Optional, the limit on each attempt of a request, defaults to 30 seconds
+	Breaker     *Breaker       // Optional, fails requests fast while their target is failing
+	DeadLetter  DeadLetterSink // Optional, receives the events Deliver gives up on, with extensions describing why
+
+	RequestHooks  []RequestHook  // Optional, customise each request once SendEvents has written it, see SetHeader
+	ResponseHooks []ResponseHook // Optional, observe the status and events of each response
+
+	// MaxRetryAfter is the longest Retry-After which Deliver waits for before resending, defaults to 1 minute
+	// Longer waits are returned as a StatusError with RetryAfter set.
+	MaxRetryAfter time.Duration
+
+	shared   bool     // Client is shared with other CEClients, so it is configured by its owner
+	attempts int      // Made by the last send
+	hooked   []string // Headers added by RequestHooks, removed by the next SendEvents
 }
 
-// NewCEClient creates a CEClient for a given URI and method
+// NewCEClient creates a CEClient for a given URI and method, with the default ClientOptions
 func NewCEClient(method, URLString string) (cec CEClient, err error) {
-	URL, err := url.Parse(URLString)
+	return NewCEClientWithOptions(method, URLString, ClientOptions{})
+}
+
+// NewCEClientWithOptions creates a CEClient for a given URI and method
+// A CEClient holds a single request and response, so it must not be used concurrently, see Client.
+func NewCEClientWithOptions(method, URLString string, opts ClientOptions) (cec CEClient, err error) {
+	URL, err := parseClientURL(URLString)
+	if err != nil {
+		return
+	}
+	c, err := opts.hostClient(URL)
 	if err != nil {
-		err = fmt.Errorf("Could not parse %s as URL (scheme://host:port/path): %s", URLString, err.Error())
 		return
 	}
 
+	cec = CEClient{
+		Request:  fasthttp.AcquireRequest(),
+		Response: fasthttp.AcquireResponse(),
+		Released: false,
+		Client:   c,
+		Timeout:  opts.Timeout,
+	}
+	opts.prepare(cec.Request, method, URL.String())
+	return
+}
+
+// parseClientURL parses the URL of a client, replacing unspecified hosts with localhost
+func parseClientURL(URLString string) (*url.URL, error) {
+	URL, err := url.Parse(URLString)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse %s as URL (scheme://host:port/path): %s", URLString, err.Error())
+	}
+
 	if host := URL.Hostname(); j.InSlice(host, []string{
 		"",
 		"::",
@@ -107,33 +278,119 @@ func NewCEClient(method, URLString string) (cec CEClient, err error) {
 			URL.Host = "localhost"
 		}
 	}
+	return URL, nil
+}
 
-	c := &fasthttp.HostClient{
-		Addr: URL.Host,
-	}
-	c.DialDualStack = true // Allow IPv6
-	c.MaxConns = 10
-
-	cec = CEClient{
-		Request:  fasthttp.AcquireRequest(),
-		Response: fasthttp.AcquireResponse(),
-		Released: false,
-		Client:   c,
+// SetTLS configures the certificates used for HTTPS, and enables HTTPS for any URL scheme
+func (cec *CEClient) SetTLS(ct ClientTLS) (err error) {
+	cfg, err := ct.TLSConfig()
+	if err != nil {
+		return fmt.Errorf("TLS failed: %s", err.Error())
 	}
-
-	cec.Request.SetRequestURI(URL.String())
-	cec.Request.Header.SetMethod(method)
-	return
+	cec.Client.IsTLS = true
+	cec.Client.TLSConfig = cfg
+	return nil
 }
 
 // Send performs the underlying request of the CEClient
 // It should be called after calling .SendCE
+// With a RetryPolicy, the request is resent while it fails in a retryable way. Send only returns an
+// error if no response was received, so the status of the last response should be checked.
 func (cec *CEClient) Send() (err error) {
-	http.DefaultTransport.(*http.Transport).MaxIdleConnsPerHost = 100
+	return cec.send(context.Background())
+}
 
-	err = cec.Client.DoTimeout(cec.Request, cec.Response, 30*time.Second)
-	if err != nil {
-		err = fmt.Errorf("HTTP Error: %s", err.Error())
+// send performs the request until it succeeds, is not retryable, or ctx is done
+// ctx limits the time spent on each attempt and waiting between them.
+func (cec *CEClient) send(ctx context.Context) (err error) {
+	if !cec.shared {
+		cec.Client.MaxResponseBodySize = cec.Limits.MaxBodySize // Rejected before the body is read
+	}
+
+	start := time.Now()
+	for n := 1; ; n++ {
+		cec.attempts = n
+		if err = cec.attempt(ctx); err != nil && (errors.Is(err, errSign) || errors.Is(err, ctx.Err())) {
+			return err
+		}
+		if cec.Retry == nil {
+			break
+		}
+		a := Attempt{Number: n, Err: err, Elapsed: time.Since(start)}
+		retryAfter := time.Duration(0)
+		if err == nil {
+			a.Status = cec.Response.StatusCode()
+			retryAfter = ResponseRetryAfter(cec.Response)
+		}
+		delay, ok := cec.Retry.next(a, retryAfter)
+		if !ok {
+			break
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("HTTP Error: %w", ctx.Err())
+		case <-timer.C:
+		}
+	}
+
+	if err == fasthttp.ErrBodyTooLarge {
+		err = fmt.Errorf("HTTP Error: %w", LimitError{Limit: "MaxBodySize", Max: cec.Limits.MaxBodySize})
+	} else if err != nil {
+		err = fmt.Errorf("HTTP Error: %w", err)
+	}
+	return err
+}
+
+// errSign is wrapped by errors from the Signer, which are not retried
+var errSign = errors.New("Sign")
+
+// attempt signs and sends the request once, recording the outcome
+// The attempt ends by the deadline of ctx, but fasthttp cannot abandon it sooner if ctx is cancelled.
+func (cec *CEClient) attempt(ctx context.Context) (err error) {
+	if err = ctx.Err(); err != nil {
+		return fmt.Errorf("HTTP Error: %w", err)
+	}
+	if cec.Signer != nil {
+		if err = cec.Signer.Sign(cec.Request); err != nil {
+			return fmt.Errorf("%w: %s", errSign, err.Error())
+		}
+	}
+
+	timeout := cec.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < timeout {
+		timeout = time.Until(deadline)
+	}
+
+	var done func(status int, err error)
+	if cec.Breaker != nil {
+		if done, err = cec.Breaker.Allow(breakerTarget(cec.Request)); err != nil {
+			loggerOrNop(cec.Logger).Log(LevelWarn, "Request refused", "uri", cec.Request.URI(), "error", err)
+			return err
+		}
+	}
+
+	start := time.Now()
+	err = cec.Client.DoTimeout(cec.Request, cec.Response, timeout)
+	if done != nil {
+		if err != nil {
+			done(0, err)
+		} else {
+			done(cec.Response.StatusCode(), nil)
+		}
+	}
+	logger := loggerOrNop(cec.Logger)
+	if err == nil {
+		metrics := metricsOrNop(cec.Metrics)
+		metrics.Add(MetricResponses, 1, "side", "client", "code", strconv.Itoa(cec.Response.StatusCode()))
+		metrics.Observe(MetricBodySize, float64(len(cec.Response.Body())), "side", "client")
+		logger.Log(LevelDebug, "Request sent", "uri", cec.Request.URI(), "status", cec.Response.StatusCode(), "duration", time.Since(start))
+	} else {
+		logger.Log(LevelWarn, "Request failed", "uri", cec.Request.URI(), "duration", time.Since(start), "error", err)
 	}
 	return err
 }
@@ -141,20 +398,57 @@ func (cec *CEClient) Send() (err error) {
 // Release must be called to GC the request and response
 func (cec *CEClient) Release() {
 	if !cec.Released {
-		cec.Released = false
+		cec.Released = true
 		fasthttp.ReleaseRequest(cec.Request)
 		fasthttp.ReleaseResponse(cec.Response)
 	}
 }
 
 // SendEvents allows sending CloudEvents to the server
+// The body and event headers of a previous call are replaced, so the CEClient may be reused
 func (cec *CEClient) SendEvents(mapper j.CEToMap, ces []j.CloudEvent, mode j.Mode) error {
-	return SendEvents(mapper, cec.Request, ces, mode)
+	cec.Request.ResetBody()
+	stale := append([]string{"Content-Encoding"}, cec.hooked...)
+	cec.hooked = nil
+	cec.Request.Header.VisitAll(func(k, v []byte) {
+		if strings.HasPrefix(strings.ToLower(string(k)), "ce-") {
+			stale = append(stale, string(k))
+		}
+	})
+	for _, k := range stale {
+		cec.Request.Header.Del(k)
+	}
+	if err := SendEvents(mapper, cec.Request, ces, mode); err != nil {
+		return err
+	}
+	countEvents(metricsOrNop(cec.Metrics), MetricEventsSent, "client", ces, mode)
+	if err := CompressRequest(cec.Request, cec.Compression); err != nil {
+		return err
+	}
+	return cec.requested(ces)
 }
 
 // RecvEvents allows receiving CloudEvents in the server response
+// The events are also passed to any ResponseHooks.
 func (cec *CEClient) RecvEvents(mapper j.MapToCE) (ces []j.CloudEvent, mode j.Mode, err error) {
-	return RecvEvents(mapper, cec.Response)
+	ces, mode, err = cec.recvEvents(mapper)
+	cec.responded(cec.Response.StatusCode(), ces, err)
+	return
+}
+
+// recvEvents implements RecvEvents without calling ResponseHooks
+func (cec *CEClient) recvEvents(mapper j.MapToCE) (ces []j.CloudEvent, mode j.Mode, err error) {
+	metrics := metricsOrNop(cec.Metrics)
+	ces, mode, err = RecvEventsWithLimits(mapper, cec.Response, cec.Limits)
+	var be BatchError
+	if err != nil && !errors.As(err, &be) {
+		metrics.Add(MetricDecodeFailures, 1, "side", "client", "reason", failureReason(err))
+		loggerOrNop(cec.Logger).Log(LevelWarn, "Response rejected", "status", cec.Response.StatusCode(), "error", err)
+		return
+	}
+	countEvents(metrics, MetricEventsReceived, "client", ces, mode)
+	metrics.Observe(MetricBatchSize, float64(len(ces)), "side", "client")
+	return
 }
 
 /*
@@ -174,19 +468,33 @@ func GetEventsCtx(mapper j.MapToCE, ctx *fasthttp.RequestCtx) (ces []j.CloudEven
 
 // GetEvents receives cloudevents from a Request in any mode
 func GetEvents(mapper j.MapToCE, req *fasthttp.Request) (ces []j.CloudEvent, mode j.Mode, err error) {
+	return GetEventsWithLimits(mapper, req, Limits{})
+}
+
+// GetEventsWithLimits receives cloudevents from a Request in any mode
+// An error wrapping a LimitError is returned as soon as any of the limits is exceeded
+// Bodies with a Content-Encoding are decompressed, see ReqRes.Decompress
+func GetEventsWithLimits(mapper j.MapToCE, req *fasthttp.Request, limits Limits) (ces []j.CloudEvent, mode j.Mode, err error) {
 	// https://github.com/cloudevents/spec/blob/v1.0/http-protocol-binding.md#13-content-modes
 	rr := ReqResFromReq(req)
+	rr.Limits = limits
 
 	if mode, err = rr.GetMode(); err != nil {
 		err = fmt.Errorf("Could not get mode: %s", err.Error())
 		return
 	}
 
+	// Content-Encoding is undone before reading events in any mode
+	if rr, err = rr.Decompress(); err != nil {
+		err = fmt.Errorf("Could not decompress: %w", err)
+		return
+	}
+
 	switch mode {
 	case j.ModeBinary:
 		ce, err := rr.BinaryToCE(mapper)
 		if err != nil {
-			return ces, mode, fmt.Errorf("Could not get binary event: %s", err.Error())
+			return ces, mode, fmt.Errorf("Could not get binary event: %w", invalidEvent(err))
 		}
 		ces = append(ces, ce)
 		return ces, mode, nil
@@ -196,12 +504,12 @@ func GetEvents(mapper j.MapToCE, req *fasthttp.Request) (ces []j.CloudEvent, mod
 		// https://github.com/cloudevents/spec/blob/v1.0/http-protocol-binding.md#3-http-message-mapping
 		ct := string(req.Header.Peek("Content-Type"))
 		if !strings.HasPrefix(ct, mode.ContentTypePlus("json")) {
-			return ces, mode, fmt.Errorf("Unknown event content media type: %s", ct)
+			return ces, mode, fmt.Errorf("%w: %s", ErrUnsupportedMediaType, ct)
 		}
 
 		ce, err := rr.StructureJSONToCE(mapper)
 		if err != nil {
-			return ces, mode, fmt.Errorf("Could not get structure event: %s", err.Error())
+			return ces, mode, fmt.Errorf("Could not get structure event: %w", invalidEvent(err))
 		}
 		ces = append(ces, ce)
 		return ces, mode, nil
@@ -211,12 +519,12 @@ func GetEvents(mapper j.MapToCE, req *fasthttp.Request) (ces []j.CloudEvent, mod
 		// https://github.com/cloudevents/spec/blob/v1.0/http-protocol-binding.md#3-http-message-mapping
 		ct := string(req.Header.Peek("Content-Type"))
 		if !strings.HasPrefix(ct, mode.ContentTypePlus("json")) {
-			return ces, mode, fmt.Errorf("Unknown event content media type: %s", ct)
+			return ces, mode, fmt.Errorf("%w: %s", ErrUnsupportedMediaType, ct)
 		}
 
 		ces, err = rr.BatchJSONToCE(mapper)
 		if err != nil {
-			err = fmt.Errorf("Could not get batch events: %s", err.Error())
+			err = fmt.Errorf("Could not get batch events: %w", invalidEvent(err))
 		}
 		return ces, mode, err
 	default:
@@ -235,9 +543,13 @@ func GetEvents(mapper j.MapToCE, req *fasthttp.Request) (ces []j.CloudEvent, mod
 */
 
 // SetEventsCtx accepts the mode and content of a response and puts any event(s) into it
+// The response is compressed with DefaultCompression if the request accepts it
 // Note that ces[1...] are dropped unless mode is batch
 func SetEventsCtx(mapper j.CEToMap, ctx *fasthttp.RequestCtx, ces []j.CloudEvent, mode j.Mode) (err error) {
-	return SetEvents(mapper, &ctx.Response, ces, mode)
+	if err = SetEvents(mapper, &ctx.Response, ces, mode); err != nil {
+		return
+	}
+	return CompressResponse(&ctx.Request, &ctx.Response, DefaultCompression)
 }
 
 // SetEvents accepts the mode and content of a response and puts any event(s) into it
@@ -330,18 +642,41 @@ func SendEvents(mapper j.CEToMap, req *fasthttp.Request, ces []j.CloudEvent, mod
 // RecvEvents receives cloudevents from a Response in any mode
 // It is used for clients reading events.
 func RecvEvents(mapper j.MapToCE, res *fasthttp.Response) (ces []j.CloudEvent, mode j.Mode, err error) {
+	return RecvEventsWithLimits(mapper, res, Limits{})
+}
+
+// RecvEventsWithLimits receives cloudevents from a Response in any mode
+// An error wrapping a LimitError is returned as soon as any of the limits is exceeded
+// Bodies with a Content-Encoding are decompressed, see ReqRes.Decompress
+func RecvEventsWithLimits(mapper j.MapToCE, res *fasthttp.Response, limits Limits) (ces []j.CloudEvent, mode j.Mode, err error) {
 	rr := ReqResFromRes(res)
+	rr.Limits = limits
 
 	if mode, err = rr.GetMode(); err != nil {
 		err = fmt.Errorf("Could not get mode: %s", err.Error())
 		return
 	}
 
+	// Content-Encoding is undone before reading events in any mode
+	if rr, err = rr.Decompress(); err != nil {
+		err = fmt.Errorf("Could not decompress: %w", err)
+		return
+	}
+
+	// A server reporting partial failure replies with the outcome of each event instead
+	if isBatchStatus(string(res.Header.ContentType())) {
+		be, err := rr.BatchStatus()
+		if err != nil {
+			return ces, mode, fmt.Errorf("Could not receive batch status: %w", err)
+		}
+		return ces, mode, be
+	}
+
 	switch mode {
 	case j.ModeBinary:
 		ce, err := rr.BinaryToCE(mapper)
 		if err != nil {
-			return ces, mode, fmt.Errorf("Could not receive binary event: %s", err.Error())
+			return ces, mode, fmt.Errorf("Could not receive binary event: %w", invalidEvent(err))
 		}
 		ces = append(ces, ce)
 		return ces, mode, nil
@@ -351,12 +686,12 @@ func RecvEvents(mapper j.MapToCE, res *fasthttp.Response) (ces []j.CloudEvent, m
 		// https://github.com/cloudevents/spec/blob/v1.0/http-protocol-binding.md#3-http-message-mapping
 		ct := string(res.Header.Peek("Content-Type"))
 		if !strings.HasPrefix(ct, mode.ContentTypePlus("json")) {
-			return ces, mode, fmt.Errorf("Unknown event content media type: %s", ct)
+			return ces, mode, fmt.Errorf("%w: %s", ErrUnsupportedMediaType, ct)
 		}
 
 		ce, err := rr.StructureJSONToCE(mapper)
 		if err != nil {
-			return ces, mode, fmt.Errorf("Could not receive structure event: %s", err.Error())
+			return ces, mode, fmt.Errorf("Could not receive structure event: %w", invalidEvent(err))
 		}
 		ces = append(ces, ce)
 		return ces, mode, nil
@@ -366,12 +701,12 @@ func RecvEvents(mapper j.MapToCE, res *fasthttp.Response) (ces []j.CloudEvent, m
 		// https://github.com/cloudevents/spec/blob/v1.0/http-protocol-binding.md#3-http-message-mapping
 		ct := string(res.Header.Peek("Content-Type"))
 		if !strings.HasPrefix(ct, mode.ContentTypePlus("json")) {
-			return ces, mode, fmt.Errorf("Unknown event content media type: %s", ct)
+			return ces, mode, fmt.Errorf("%w: %s", ErrUnsupportedMediaType, ct)
 		}
 
 		ces, err := rr.BatchJSONToCE(mapper)
 		if err != nil {
-			return ces, mode, fmt.Errorf("Could not receive batch events: %s", err.Error())
+			return ces, mode, fmt.Errorf("Could not receive batch events: %w", invalidEvent(err))
 		}
 		return ces, mode, nil
 	default:
@@ -408,6 +743,10 @@ type res *fasthttp.Response
 // Interfaces cannot be used conveniently because of property dependence
 type ReqRes struct {
 	r interface{} // Underlying request or response
+
+	Limits Limits // Checked while reading events, zero is unlimited
+
+	body []byte // Overrides the body of r once decompressed
 	// AppendBody([]byte)
 	// Body() []byte
 	// IsReqRes()
@@ -429,6 +768,9 @@ func ReqResFromRes(res *fasthttp.Response) ReqRes {
 
 // Body returns the body from a ReqRes
 func (rr ReqRes) Body() (p []byte, err error) {
+	if rr.body != nil {
+		return rr.body, nil
+	}
 	switch v := rr.r.(type) {
 	case *fasthttp.Request:
 		p = v.Body()
@@ -547,6 +889,11 @@ func (rr ReqRes) CEToBinary(mapper j.CEToMap, ce j.CloudEvent) (err error) {
 	}
 
 	for k, v := range ex {
+		// Strings are sent as is, so they read back unchanged
+		if s, ok := v.(string); ok {
+			head.Set(fmt.Sprintf("ce-%s", k), s)
+			continue
+		}
 		bytes, err := json.Marshal(v)
 		if err != nil {
 			bytes = []byte(fmt.Sprintf("%s", v))
@@ -622,7 +969,11 @@ func (rr ReqRes) BinaryToCE(mapper j.MapToCE) (ce j.CloudEvent, err error) {
 
 	// Required + Optional
 	// Note that headers ce-data_base64 and ce-data will be dropped to prevent conflicts
+	extensions := 0
 	head.VisitAll(func(K, v []byte) {
+		if err != nil {
+			return
+		}
 		k := strings.ToLower(string(K))
 		if !strings.HasPrefix(k, "ce-") {
 			return
@@ -630,11 +981,18 @@ func (rr ReqRes) BinaryToCE(mapper j.MapToCE) (ce j.CloudEvent, err error) {
 		key := strings.TrimPrefix(k, "ce-")
 		if key == "data" || key == "data_base64" {
 			err = fmt.Errorf("Binary header forbidden: %s", key)
+			return
+		}
+		if !j.InSlice(key, j.ContextProperties) {
+			extensions++
+			if err = rr.Limits.checkExtensions(extensions); err != nil {
+				return
+			}
 		}
 		cm[key] = string(v)
 	})
 	if err != nil {
-		return ce, fmt.Errorf("Could not read binary headers: %s", err.Error())
+		return ce, fmt.Errorf("Could not read binary headers: %w", err)
 	}
 
 	cm["datacontenttype"] = string(head.Peek("Content-Type"))
@@ -644,7 +1002,13 @@ func (rr ReqRes) BinaryToCE(mapper j.MapToCE) (ce j.CloudEvent, err error) {
 	if err != nil {
 		return ce, fmt.Errorf("Could not read body: %s", err.Error())
 	}
-	j.SetData(cm, body)
+	if err = rr.Limits.checkBody(body); err != nil {
+		return
+	}
+	if err = rr.Limits.checkData(len(body)); err != nil {
+		return
+	}
+	cm["data_base64"] = append([]byte{}, body...) // The body is the data as is, see CEToBinary, where jsonce.SetData would encode it
 
 	ce, err = cm.ToCE(mapper)
 	if err != nil {
@@ -660,12 +1024,19 @@ func (rr ReqRes) StructureJSONToCE(mapper j.MapToCE) (ce j.CloudEvent, err error
 		return ce, fmt.Errorf("Could not read body: %s", err.Error())
 	}
 
+	if err = rr.Limits.checkBody(body); err != nil {
+		return
+	}
+
 	cm := j.CEMap{}
 	err = json.Unmarshal(body, &cm)
 	if err != nil {
 		err = fmt.Errorf("Could not unmarshal to map: %s", err.Error())
 		return
 	}
+	if err = rr.Limits.checkMap(cm); err != nil {
+		return
+	}
 
 	ce, err = cm.ToCE(mapper)
 	if err != nil {
@@ -681,10 +1052,14 @@ func (rr ReqRes) BatchJSONToCE(mapper j.MapToCE) (ces j.CloudEvents, err error)
 		return ces, fmt.Errorf("Could not read body: %s", err.Error())
 	}
 
-	cms := j.CEMaps{}
-	err = json.Unmarshal(body, &cms)
+	if err = rr.Limits.checkBody(body); err != nil {
+		return
+	}
+
+	// Decode element by element so that limits apply before the batch is fully read
+	cms, err := rr.Limits.decodeBatch(body)
 	if err != nil {
-		err = fmt.Errorf("Could not unmarshal to map: %s", err.Error())
+		err = fmt.Errorf("Could not unmarshal to map: %w", err)
 		return
 	}
 
@@ -1,6 +1,7 @@
 package fastce
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
@@ -8,26 +9,28 @@ import (
 	"time"
 
 	jsonce "github.com/creativecactus/fast-cloudevents-go/jsonce"
+
+	"github.com/valyala/fasthttp"
 )
 
 var target string
 
 func TestMain(m *testing.M) {
 	// Init
-	server, shutdownErr, addr, err := ExampleServer("0.0.0.0:0", ExampleHandler)
+	server, err := ExampleServer("0.0.0.0:0", ExampleHandler)
 	if err != nil {
 		log.Fatalf("Server Init Error: %s", err)
 	}
 
-	target = addr
+	target = server.Addr()
 
 	// Run Tests
 	result := m.Run()
 
 	// Shutdown
-	server.Shutdown()
-	err = waitForErr(shutdownErr, 5*time.Second)
-	if err != nil {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err = server.Shutdown(ctx); err != nil {
 		log.Fatalf("Server Error: %s", err)
 	}
 	os.Exit(result)
@@ -87,6 +90,37 @@ func TestBinary(t *testing.T) {
 		t.Logf("CE.Time matchs:\n\tRequest: %s\n\tResponse: %s", ce.Time.Format(time.RFC3339Nano), re.Time.Format(time.RFC3339Nano))
 	}
 }
+func TestBinaryRoundTrip(t *testing.T) {
+	ce := jsonce.GenerateValidEvents(1)[0]
+	ce.DataContentType = "application/octet-stream"
+	ce.Data = []byte("not \"valid\" json\x00")
+	ce.Extensions = map[string]interface{}{"tenant": "acme", "count": 3}
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	if err := SendEvents(jsonce.DefaultCEToMap, req, jsonce.CloudEvents{ce}, jsonce.ModeBinary); err != nil {
+		t.Fatalf("TestBinaryRoundTrip: %s", err.Error())
+	}
+	if have := string(req.Header.Peek("ce-tenant")); have != "acme" {
+		t.Fatalf("TestBinaryRoundTrip: want ce-tenant sent unquoted, have %s", have)
+	}
+	if have := string(req.Body()); have != string(ce.Data) {
+		t.Fatalf("TestBinaryRoundTrip: want data sent as the body, have %q", have)
+	}
+
+	ces, _, err := GetEvents(jsonce.DefaultMapToCE, req)
+	if err != nil {
+		t.Fatalf("TestBinaryRoundTrip: %s", err.Error())
+	}
+	re := ces[0]
+	if string(re.Data) != string(ce.Data) {
+		t.Fatalf("TestBinaryRoundTrip: data differs:\n\tSent: %q\n\tRead: %q", ce.Data, re.Data)
+	}
+	// Other extensions are sent as JSON, and read back as strings
+	if re.Extensions["tenant"] != "acme" || re.Extensions["count"] != "3" {
+		t.Fatalf("TestBinaryRoundTrip: unexpected extensions %v", re.Extensions)
+	}
+}
 func TestStructure(t *testing.T) {
 	count := uint(5)
 	mode := jsonce.ModeStructure
@@ -167,21 +201,3 @@ func TestCEClientCEServer(t *testing.T) {
 
 	t.Logf("Received: %d/%d valid events, the first has Source:%s\n", len(ces), count, ces[0].Source)
 }
-
-/*
- ██╗   ██╗████████╗██╗██╗     ███████╗
- ██║   ██║╚══██╔══╝██║██║     ██╔════╝
- ██║   ██║   ██║   ██║██║     ███████╗
- ██║   ██║   ██║   ██║██║     ╚════██║
- ╚██████╔╝   ██║   ██║███████╗███████║
-  ╚═════╝    ╚═╝   ╚═╝╚══════╝╚══════╝
-*/
-
-func waitForErr(c <-chan error, t time.Duration) error {
-	select {
-	case err := <-c:
-		return err
-	case <-time.After(t):
-		return nil
-	}
-}
@@ -0,0 +1,80 @@
+package fastce
+
+import (
+	"fmt"
+
+	j "github.com/creativecactus/fast-cloudevents-go/jsonce"
+
+	"github.com/valyala/fasthttp"
+)
+
+/*
+ ██╗  ██╗ ██████╗  ██████╗ ██╗  ██╗███████╗
+ ██║  ██║██╔═══██╗██╔═══██╗██║ ██╔╝██╔════╝
+ ███████║██║   ██║██║   ██║█████╔╝ ███████╗
+ ██╔══██║██║   ██║██║   ██║██╔═██╗ ╚════██║
+ ██║  ██║╚██████╔╝╚██████╔╝██║  ██╗███████║
+ ╚═╝  ╚═╝ ╚═════╝  ╚═════╝ ╚═╝  ╚═╝╚══════╝
+*/
+
+// RequestHook customises an outgoing request, eg. adding headers derived from its events
+// It is called by SendEvents once the body is written, before the request is signed and sent.
+// An error stops the request and is returned by SendEvents.
+type RequestHook func(ces j.CloudEvents, req *fasthttp.Request) error
+
+// ResponseHook observes the outcome of a request, eg. for auditing
+// It is called with the status and events of each response read by the client, along with any error
+// reading it. A request which got no response is reported with status 0 and its error.
+type ResponseHook func(status int, res j.CloudEvents, err error)
+
+// SetHeader returns a RequestHook setting a header on every request
+func SetHeader(name, value string) RequestHook {
+	return func(ces j.CloudEvents, req *fasthttp.Request) error {
+		req.Header.Set(name, value)
+		return nil
+	}
+}
+
+// HeaderFromExtension returns a RequestHook setting a header to an extension of the events, eg. X-Tenant
+// The value is that of the first event which has the extension. Requests without it are sent without the header.
+func HeaderFromExtension(name, extension string) RequestHook {
+	return func(ces j.CloudEvents, req *fasthttp.Request) error {
+		for _, ce := range ces {
+			if v, ok := ce.Extensions[extension]; ok {
+				req.Header.Set(name, fmt.Sprint(v))
+				return nil
+			}
+		}
+		return nil
+	}
+}
+
+// requested runs the RequestHooks of the client, remembering the headers they add
+// Those headers are removed by the next SendEvents, so hooks need not clean up after a reused CEClient.
+func (cec *CEClient) requested(ces j.CloudEvents) error {
+	if len(cec.RequestHooks) == 0 {
+		return nil
+	}
+	before := map[string]bool{}
+	cec.Request.Header.VisitAll(func(k, v []byte) {
+		before[string(k)] = true
+	})
+	for _, hook := range cec.RequestHooks {
+		if err := hook(ces, cec.Request); err != nil {
+			return fmt.Errorf("Request hook: %w", err)
+		}
+	}
+	cec.Request.Header.VisitAll(func(k, v []byte) {
+		if !before[string(k)] {
+			cec.hooked = append(cec.hooked, string(k))
+		}
+	})
+	return nil
+}
+
+// responded runs the ResponseHooks of the client
+func (cec *CEClient) responded(status int, res j.CloudEvents, err error) {
+	for _, hook := range cec.ResponseHooks {
+		hook(status, res, err)
+	}
+}
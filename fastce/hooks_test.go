@@ -0,0 +1,129 @@
+package fastce
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+
+	jsonce "github.com/creativecactus/fast-cloudevents-go/jsonce"
+
+	"github.com/valyala/fasthttp"
+)
+
+// hookServer starts a server echoing events, and records the given header of each request
+func hookServer(t *testing.T, header string) (srv *CEServer, values func() []string) {
+	lock := sync.Mutex{}
+	received := []string{}
+	srv = &CEServer{}
+	handle := srv.RequestHandler(jsonce.DefaultCEToMap, jsonce.DefaultMapToCE, HandlerFunc(func(ctx context.Context, ces jsonce.CloudEvents) (jsonce.CloudEvents, error) {
+		return ces, nil
+	}))
+	err := srv.Start("127.0.0.1:0", func(ctx *fasthttp.RequestCtx) {
+		lock.Lock()
+		received = append(received, string(ctx.Request.Header.Peek(header)))
+		lock.Unlock()
+		handle(ctx)
+	})
+	if err != nil {
+		t.Fatalf("hookServer: %s", err.Error())
+	}
+	return srv, func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		values := received
+		received = []string{}
+		return values
+	}
+}
+
+func TestClientHooks(t *testing.T) {
+	srv, tenants := hookServer(t, "X-Tenant")
+	defer srv.Shutdown(context.Background())
+
+	statuses := []int{}
+	replies := 0
+	cec, err := NewCEClient("POST", srv.Addr())
+	if err != nil {
+		t.Fatalf("TestClientHooks: %s", err.Error())
+	}
+	defer cec.Release()
+	cec.RequestHooks = []RequestHook{SetHeader("Authorization", "Bearer token"), HeaderFromExtension("X-Tenant", "tenant")}
+	cec.ResponseHooks = []ResponseHook{func(status int, res jsonce.CloudEvents, err error) {
+		statuses = append(statuses, status)
+		replies += len(res)
+	}}
+
+	ces := jsonce.GenerateValidEvents(2)
+	ces[1].Extensions = map[string]interface{}{"tenant": "acme"}
+	if _, err = cec.Deliver(jsonce.DefaultCEToMap, jsonce.DefaultMapToCE, ces, jsonce.ModeBatch, 1); err != nil {
+		t.Fatalf("TestClientHooks: %s", err.Error())
+	}
+	if string(cec.Request.Header.Peek("Authorization")) != "Bearer token" {
+		t.Fatalf("TestClientHooks: want Authorization set")
+	}
+
+	// Headers added for earlier events are not sent with later ones
+	if _, err = cec.Deliver(jsonce.DefaultCEToMap, jsonce.DefaultMapToCE, ces[:1], jsonce.ModeBatch, 1); err != nil {
+		t.Fatalf("TestClientHooks: %s", err.Error())
+	}
+	if have := tenants(); len(have) != 2 || have[0] != "acme" || have[1] != "" {
+		t.Fatalf("TestClientHooks: unexpected X-Tenant headers %q", have)
+	}
+	if len(statuses) != 2 || statuses[0] != 200 || replies != 3 {
+		t.Fatalf("TestClientHooks: unexpected responses %v with %d events", statuses, replies)
+	}
+
+	// An error stops the request
+	cec.RequestHooks = append(cec.RequestHooks, func(ces jsonce.CloudEvents, req *fasthttp.Request) error {
+		return Permanent(errors.New("no tenant"))
+	})
+	err = cec.SendEvents(jsonce.DefaultCEToMap, ces, jsonce.ModeBatch)
+	if !errors.Is(err, ErrPermanent) {
+		t.Fatalf("TestClientHooks: want permanent error, have %v", err)
+	}
+}
+
+func TestClientResponseHooks(t *testing.T) {
+	srv, _ := hookServer(t, "X-Tenant")
+	defer srv.Shutdown(context.Background())
+
+	lock := sync.Mutex{}
+	statuses := []int{}
+	hook := func(status int, res jsonce.CloudEvents, err error) {
+		lock.Lock()
+		defer lock.Unlock()
+		statuses = append(statuses, status)
+	}
+	client, err := NewClient("POST", srv.Addr(), ClientOptions{})
+	if err != nil {
+		t.Fatalf("TestClientResponseHooks: %s", err.Error())
+	}
+	client.ResponseHooks = []ResponseHook{hook}
+	if _, err = client.Send(context.Background(), jsonce.GenerateValidEvents(1), jsonce.ModeBinary); err != nil {
+		t.Fatalf("TestClientResponseHooks: %s", err.Error())
+	}
+
+	// A request without a response is reported with status 0
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("TestClientResponseHooks: %s", err.Error())
+	}
+	addr := "http://" + ln.Addr().String()
+	ln.Close()
+	down, err := NewClient("POST", addr, ClientOptions{})
+	if err != nil {
+		t.Fatalf("TestClientResponseHooks: %s", err.Error())
+	}
+	down.ResponseHooks = []ResponseHook{hook}
+	if _, err = down.Send(context.Background(), jsonce.GenerateValidEvents(1), jsonce.ModeBinary); err == nil {
+		t.Fatalf("TestClientResponseHooks: want error")
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(statuses) != 2 || statuses[0] != 200 || statuses[1] != 0 {
+		t.Fatalf("TestClientResponseHooks: unexpected statuses %v", statuses)
+	}
+}
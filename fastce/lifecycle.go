@@ -0,0 +1,303 @@
+package fastce
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	j "github.com/creativecactus/fast-cloudevents-go/jsonce"
+
+	"github.com/valyala/fasthttp"
+)
+
+/*
+ ██╗     ██╗███████╗███████╗ ██████╗██╗   ██╗ ██████╗██╗     ███████╗
+ ██║     ██║██╔════╝██╔════╝██╔════╝╚██╗ ██╔╝██╔════╝██║     ██╔════╝
+ ██║     ██║█████╗  █████╗  ██║      ╚████╔╝ ██║     ██║     █████╗
+ ██║     ██║██╔══╝  ██╔══╝  ██║       ╚██╔╝  ██║     ██║     ██╔══╝
+ ███████╗██║██║     ███████╗╚██████╗   ██║   ╚██████╗███████╗███████╗
+ ╚══════╝╚═╝╚═╝     ╚══════╝ ╚═════╝   ╚═╝    ╚═════╝╚══════╝╚══════╝
+*/
+
+// ShutdownError is returned by Shutdown when its context expires before all work is finished
+type ShutdownError struct {
+	Unfinished int   // The number of requests still being handled, plus any Async events not yet handled
+	Err        error // The error of the context
+}
+
+// Error implements error
+func (e ShutdownError) Error() string {
+	return fmt.Sprintf("Shutdown incomplete, %d unfinished: %s", e.Unfinished, e.Err.Error())
+}
+
+// Unwrap allows errors.Is(err, context.DeadlineExceeded)
+func (e ShutdownError) Unwrap() error {
+	return e.Err
+}
+
+// serverState tracks the requests and connections of a started CEServer
+type serverState struct {
+	inflight int64 // Requests currently in a handler, accessed atomically
+
+	lock  sync.Mutex
+	conns map[net.Conn]fasthttp.ConnState
+
+	ownListener bool // The Listener was created by Start, so Shutdown clears it
+
+	started chan struct{} // Closed once Serve accepts from the listener, so Shutdown can stop it
+	done    chan struct{} // Closed when Serve returns
+	err     error         // Returned by Serve
+
+	// ctx is the parent of handler contexts, cancelled when Shutdown finishes or gives up, or Serve fails
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	queue *asyncQueue // Set if the server is Async
+}
+
+// startedListener closes started when Serve first accepts from it
+// fasthttp ignores a Shutdown before Serve has taken the listener, after which Serve would run forever.
+type startedListener struct {
+	net.Listener
+	once    sync.Once
+	started chan struct{}
+}
+
+// Accept implements net.Listener
+func (l *startedListener) Accept() (net.Conn, error) {
+	l.once.Do(func() {
+		close(l.started)
+	})
+	return l.Listener.Accept()
+}
+
+// track wraps a request handler to count the requests in flight
+func (state *serverState) track(handler func(*fasthttp.RequestCtx)) func(*fasthttp.RequestCtx) {
+	return func(ctx *fasthttp.RequestCtx) {
+		atomic.AddInt64(&state.inflight, 1)
+		defer atomic.AddInt64(&state.inflight, -1)
+		handler(ctx)
+	}
+}
+
+// connState is used as the fasthttp.Server ConnState hook, so that idle connections can be closed
+func (state *serverState) connState(c net.Conn, cs fasthttp.ConnState) {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	if cs == fasthttp.StateClosed || cs == fasthttp.StateHijacked {
+		delete(state.conns, c)
+		return
+	}
+	state.conns[c] = cs
+}
+
+// closeIdle closes connections which are not serving a request
+func (state *serverState) closeIdle() {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	for c, cs := range state.conns {
+		if cs == fasthttp.StateIdle || cs == fasthttp.StateNew {
+			c.Close()
+			delete(state.conns, c)
+		}
+	}
+}
+
+// unfinished returns the number of requests in flight and events queued
+func (state *serverState) unfinished() int {
+	n := int(atomic.LoadInt64(&state.inflight))
+	if state.queue != nil {
+		n += state.queue.Depth()
+	}
+	return n
+}
+
+// drain waits for the queue of an Async server to be handled
+func (state *serverState) drain() {
+	if state.queue != nil {
+		state.queue.drain()
+	}
+}
+
+// stop releases the resources of a server which failed to start
+func (state *serverState) stop() {
+	state.cancel()
+	state.drain()
+}
+
+// Start sets up the underlying server and net.Listener, and serves in the background
+// It returns once the listener is bound, so Addr may be used immediately.
+// Use Wait to block until the server stops, and Shutdown to stop it.
+// This will overwrite the Server, and the Listener unless one was provided, which Shutdown clears again
+// If TLS is set, the listener is wrapped to serve HTTPS
+func (srv *CEServer) Start(addr string, handler func(*fasthttp.RequestCtx)) (err error) {
+	respond := srv.ErrorResponder
+	if respond == nil {
+		respond = DefaultErrorResponder
+	}
+	limits := srv.Limits
+	if limits.MaxBodySize == 0 {
+		limits.MaxBodySize = fasthttp.DefaultMaxRequestBodySize
+	}
+
+	state := &serverState{
+		conns:   map[net.Conn]fasthttp.ConnState{},
+		started: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	state.ctx, state.cancel = context.WithCancel(context.Background())
+	if srv.Async != nil {
+		state.queue = newAsyncQueue(*srv.Async, srv.Logger)
+	}
+	srv.Server = &fasthttp.Server{
+		Handler:            state.track(handler),
+		ConnState:          state.connState,
+		MaxRequestBodySize: limits.MaxBodySize, // Rejected before the body is read
+		ErrorHandler:       serverErrorHandler(respond, limits),
+	}
+	if srv.Listener == nil {
+		if srv.Listener, err = net.Listen("tcp", addr); err != nil {
+			state.stop()
+			err = fmt.Errorf("Listener failed: %s", err.Error())
+			return
+		}
+		state.ownListener = true
+	}
+	scheme, listener := "http", srv.Listener
+	if srv.TLS != nil {
+		cfg, err := srv.TLS.TLSConfig()
+		if err != nil {
+			if state.ownListener {
+				// A provided listener is left open, so the caller can retry with another config
+				srv.Listener.Close()
+				srv.Listener = nil
+			}
+			state.stop()
+			return fmt.Errorf("TLS failed: %s", err.Error())
+		}
+		listener = tls.NewListener(listener, cfg)
+		scheme = "https"
+	}
+	srv.Address = fmt.Sprintf("%s://%s", scheme, listener.Addr().String())
+	srv.state = state
+
+	server := srv.Server
+	listener = &startedListener{Listener: listener, started: state.started}
+	go func() {
+		// Serve also returns as soon as Shutdown closes the listener, while requests may
+		// still be in flight, so handlers are only cancelled here if it failed
+		if state.err = server.Serve(listener); state.err != nil {
+			state.cancel()
+		}
+		close(state.done)
+	}()
+	return nil
+}
+
+// StartCE is Start with the default CloudEvents HTTP handler, as used by ListenAndServeCE
+func (srv *CEServer) StartCE(addr string, CEToMap j.CEToMap, MapToCE j.MapToCE, handler func(j.CloudEvents) (j.CloudEvents, error)) (err error) {
+	return srv.Start(addr, srv.ceHandler(CEToMap, MapToCE, handler))
+}
+
+// StartHandler is StartCE for a Handler, as used by ListenAndServeHandler
+func (srv *CEServer) StartHandler(addr string, CEToMap j.CEToMap, MapToCE j.MapToCE, handler Handler) (err error) {
+	return srv.Start(addr, srv.RequestHandler(CEToMap, MapToCE, handler))
+}
+
+// Addr returns the address the server is bound to, eg. http://127.0.0.1:34567
+// It is empty until the server is started
+func (srv *CEServer) Addr() string {
+	return srv.Address
+}
+
+// Wait blocks until a started server stops, returning the error it stopped with
+// The error is nil if the server was stopped by Shutdown
+func (srv *CEServer) Wait() error {
+	if srv.state == nil {
+		return fmt.Errorf("Server not started")
+	}
+	<-srv.state.done
+	return srv.state.err
+}
+
+// Shutdown stops accepting connections, then waits for requests in flight to finish
+// Idle keep-alive connections are closed, and the queue of an Async server is drained.
+// If ctx expires first, the contexts of the handlers still running are cancelled, and a
+// ShutdownError reports how much work had not finished.
+func (srv *CEServer) Shutdown(ctx context.Context) error {
+	state := srv.state
+	if state == nil {
+		return nil
+	}
+
+	stopped := make(chan error, 1)
+	go func() {
+		select {
+		case <-state.started:
+		case <-state.done:
+		}
+		err := srv.Server.Shutdown()
+		if err == nil {
+			// No more requests can be enqueued once the server has stopped
+			state.drain()
+		}
+		stopped <- err
+	}()
+
+	tick := time.NewTicker(10 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		state.closeIdle()
+		select {
+		case err := <-stopped:
+			if err != nil {
+				return fmt.Errorf("Shutdown failed: %s", err.Error())
+			}
+			<-state.done
+			if state.ownListener {
+				srv.Listener = nil // Closed by the server, so a restart needs a new one
+			}
+			state.cancel()
+			return nil
+		case <-ctx.Done():
+			state.cancel()
+			return ShutdownError{
+				Unfinished: state.unfinished(),
+				Err:        ctx.Err(),
+			}
+		case <-tick.C:
+		}
+	}
+}
+
+// ShutdownOnSignal blocks until one of the given signals is received, then calls Shutdown
+// with the given timeout. SIGINT and SIGTERM are used if no signals are given.
+// It also returns if the server stops by itself, with the error it stopped with.
+func (srv *CEServer) ShutdownOnSignal(timeout time.Duration, signals ...os.Signal) error {
+	if srv.state == nil {
+		return fmt.Errorf("Server not started")
+	}
+	if len(signals) == 0 {
+		signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, signals...)
+	defer signal.Stop(sig)
+
+	select {
+	case <-sig:
+	case <-srv.state.done:
+		return srv.state.err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return srv.Shutdown(ctx)
+}
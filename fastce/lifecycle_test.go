@@ -0,0 +1,115 @@
+package fastce
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	jsonce "github.com/creativecactus/fast-cloudevents-go/jsonce"
+)
+
+func TestLifecycle(t *testing.T) {
+	entered := make(chan struct{}, 1)
+	release := make(chan struct{})
+	srv := &CEServer{}
+	err := srv.StartCE("127.0.0.1:0", jsonce.DefaultCEToMap, jsonce.DefaultMapToCE, func(ces jsonce.CloudEvents) (jsonce.CloudEvents, error) {
+		entered <- struct{}{}
+		<-release
+		return ces, nil
+	})
+	if err != nil {
+		t.Fatalf("TestLifecycle: %s", err.Error())
+	}
+	if addr := srv.Addr(); !strings.HasPrefix(addr, "http://127.0.0.1:") || strings.HasSuffix(addr, ":0") {
+		t.Fatalf("TestLifecycle: unexpected bound address %q", addr)
+	}
+
+	cec, err := NewCEClient("PUT", srv.Addr())
+	if err != nil {
+		t.Fatalf("TestLifecycle: %s", err.Error())
+	}
+	defer cec.Release()
+	sent := make(chan error, 1)
+	go func() {
+		_, err := ClientTester(cec, jsonce.GenerateValidEvents(1), jsonce.ModeStructure, 1)
+		sent <- err
+	}()
+	<-entered
+
+	// The handler is still running, so a short deadline reports it as unfinished
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = srv.Shutdown(ctx)
+	var se ShutdownError
+	if !errors.As(err, &se) || se.Unfinished != 1 || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("TestLifecycle: want ShutdownError with 1 unfinished, have %v", err)
+	}
+
+	// Once released, the request completes and the server stops cleanly
+	close(release)
+	if err = <-sent; err != nil {
+		t.Fatalf("TestLifecycle: in flight request failed: %s", err.Error())
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err = srv.Shutdown(ctx); err != nil {
+		t.Fatalf("TestLifecycle: %s", err.Error())
+	}
+	if err = srv.Wait(); err != nil {
+		t.Fatalf("TestLifecycle: %s", err.Error())
+	}
+}
+
+func TestLifecycleImmediateShutdown(t *testing.T) {
+	// Shutdown right after Start must not miss the server, which would then serve forever
+	for i := 0; i < 20; i++ {
+		srv := &CEServer{}
+		err := srv.StartCE("127.0.0.1:0", jsonce.DefaultCEToMap, jsonce.DefaultMapToCE, func(ces jsonce.CloudEvents) (jsonce.CloudEvents, error) {
+			return nil, nil
+		})
+		if err != nil {
+			t.Fatalf("TestLifecycleImmediateShutdown: %s", err.Error())
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err = srv.Shutdown(ctx)
+		cancel()
+		if err != nil {
+			t.Fatalf("TestLifecycleImmediateShutdown: %s", err.Error())
+		}
+		if err = srv.Wait(); err != nil {
+			t.Fatalf("TestLifecycleImmediateShutdown: %s", err.Error())
+		}
+	}
+}
+
+func TestLifecycleRestart(t *testing.T) {
+	srv := &CEServer{}
+	for i := 0; i < 2; i++ {
+		err := srv.StartCE("127.0.0.1:0", jsonce.DefaultCEToMap, jsonce.DefaultMapToCE, func(ces jsonce.CloudEvents) (jsonce.CloudEvents, error) {
+			return ces, nil
+		})
+		if err != nil {
+			t.Fatalf("TestLifecycleRestart: %s", err.Error())
+		}
+		cec, err := NewCEClient("PUT", srv.Addr())
+		if err != nil {
+			t.Fatalf("TestLifecycleRestart: %s", err.Error())
+		}
+		_, err = ClientTester(cec, jsonce.GenerateValidEvents(1), jsonce.ModeStructure, 1)
+		cec.Release()
+		if err != nil {
+			t.Fatalf("TestLifecycleRestart: start %d: %s", i+1, err.Error())
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err = srv.Shutdown(ctx)
+		cancel()
+		if err != nil {
+			t.Fatalf("TestLifecycleRestart: %s", err.Error())
+		}
+		if srv.Listener != nil {
+			t.Fatalf("TestLifecycleRestart: want the listener of Start cleared")
+		}
+	}
+}
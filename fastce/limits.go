@@ -0,0 +1,156 @@
+package fastce
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	j "github.com/creativecactus/fast-cloudevents-go/jsonce"
+
+	"github.com/valyala/fasthttp"
+)
+
+/*
+ ██╗     ██╗███╗   ███╗██╗████████╗███████╗
+ ██║     ██║████╗ ████║██║╚══██╔══╝██╔════╝
+ ██║     ██║██╔████╔██║██║   ██║   ███████╗
+ ██║     ██║██║╚██╔╝██║██║   ██║   ╚════██║
+ ███████╗██║██║ ╚═╝ ██║██║   ██║   ███████║
+ ╚══════╝╚═╝╚═╝     ╚═╝╚═╝   ╚═╝   ╚══════╝
+*/
+
+// Limits bounds the events read by GetEvents and RecvEvents
+// Limits are checked while parsing, so an oversized batch is rejected before it is fully mapped.
+// A zero field means no limit is applied.
+type Limits struct {
+	MaxBodySize      int // Bytes in the body of a request or response
+	MaxBatchEvents   int // Events in a single batch
+	MaxEventDataSize int // Bytes in the data of a single event
+	MaxExtensions    int // Extensions on a single event
+}
+
+// LimitError is returned when a request or response exceeds one of its Limits
+// It wraps ErrTooLarge, so servers respond with 413 Request Entity Too Large.
+type LimitError struct {
+	Limit string // The name of the exceeded field of Limits
+	Max   int    // The configured limit
+}
+
+// Error implements error
+func (e LimitError) Error() string {
+	return fmt.Sprintf("%s: exceeds %s of %d", ErrTooLarge.Error(), e.Limit, e.Max)
+}
+
+// Unwrap allows errors.Is(err, ErrTooLarge)
+func (e LimitError) Unwrap() error {
+	return ErrTooLarge
+}
+
+// checkBody enforces MaxBodySize
+func (l Limits) checkBody(body []byte) error {
+	if l.MaxBodySize > 0 && len(body) > l.MaxBodySize {
+		return LimitError{Limit: "MaxBodySize", Max: l.MaxBodySize}
+	}
+	return nil
+}
+
+// checkBatch enforces MaxBatchEvents given the number of events read so far
+func (l Limits) checkBatch(count int) error {
+	if l.MaxBatchEvents > 0 && count > l.MaxBatchEvents {
+		return LimitError{Limit: "MaxBatchEvents", Max: l.MaxBatchEvents}
+	}
+	return nil
+}
+
+// checkData enforces MaxEventDataSize
+func (l Limits) checkData(size int) error {
+	if l.MaxEventDataSize > 0 && size > l.MaxEventDataSize {
+		return LimitError{Limit: "MaxEventDataSize", Max: l.MaxEventDataSize}
+	}
+	return nil
+}
+
+// checkExtensions enforces MaxExtensions
+func (l Limits) checkExtensions(count int) error {
+	if l.MaxExtensions > 0 && count > l.MaxExtensions {
+		return LimitError{Limit: "MaxExtensions", Max: l.MaxExtensions}
+	}
+	return nil
+}
+
+// checkMap enforces the per event limits on an intermediate map representation
+func (l Limits) checkMap(cm j.CEMap) error {
+	if l.MaxEventDataSize > 0 {
+		size := 0
+		if data, ok := cm["data"].(json.RawMessage); ok {
+			size = len(data)
+		} else if b64, ok := cm["data_base64"].([]byte); ok {
+			size = len(b64)
+		}
+		if err := l.checkData(size); err != nil {
+			return err
+		}
+	}
+	if l.MaxExtensions > 0 {
+		count := 0
+		for k := range cm {
+			if !j.InSlice(k, j.ContextProperties) {
+				count++
+			}
+		}
+		if err := l.checkExtensions(count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeBatch reads a JSON array of events one element at a time, so that
+// MaxBatchEvents is enforced without unmarshalling the whole array
+func (l Limits) decodeBatch(body []byte) (cms j.CEMaps, err error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	tok, err := dec.Token()
+	if err != nil {
+		return cms, fmt.Errorf("Could not read batch: %s", err.Error())
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return cms, fmt.Errorf("Could not read batch: expected array, got %v", tok)
+	}
+
+	cms = j.CEMaps{}
+	for dec.More() {
+		if err = l.checkBatch(len(cms) + 1); err != nil {
+			return
+		}
+		cm := j.CEMap{}
+		if err = dec.Decode(&cm); err != nil {
+			return cms, fmt.Errorf("Could not read event %d: %s", len(cms), err.Error())
+		}
+		if err = l.checkMap(cm); err != nil {
+			return cms, fmt.Errorf("Event %d: %w", len(cms), err)
+		}
+		cms = append(cms, cm)
+	}
+
+	if _, err = dec.Token(); err != nil {
+		return cms, fmt.Errorf("Could not read end of batch: %s", err.Error())
+	}
+	// As with json.Unmarshal, nothing but whitespace may follow the batch
+	if _, err = dec.Token(); err != io.EOF {
+		return cms, fmt.Errorf("Could not read batch: unexpected data after end of batch")
+	}
+	return cms, nil
+}
+
+// serverErrorHandler is used as the fasthttp.Server ErrorHandler, so that errors
+// from reading a request are reported consistently with errors from reading events
+func serverErrorHandler(respond ErrorResponder, limits Limits) func(ctx *fasthttp.RequestCtx, err error) {
+	return func(ctx *fasthttp.RequestCtx, err error) {
+		if err == fasthttp.ErrBodyTooLarge {
+			respond(ctx, LimitError{Limit: "MaxBodySize", Max: limits.MaxBodySize})
+			return
+		}
+		ctx.Error("Error when parsing request", fasthttp.StatusBadRequest)
+	}
+}
@@ -0,0 +1,101 @@
+package fastce
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	jsonce "github.com/creativecactus/fast-cloudevents-go/jsonce"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestLimits(t *testing.T) {
+	request := func(count uint, mode jsonce.Mode) *fasthttp.Request {
+		req := &fasthttp.Request{}
+		if err := SendEvents(jsonce.DefaultCEToMap, req, jsonce.GenerateValidEvents(count), mode); err != nil {
+			t.Fatalf("TestLimits: %s", err.Error())
+		}
+		return req
+	}
+	expect := func(name string, req *fasthttp.Request, limits Limits, limit string) {
+		_, _, err := GetEventsWithLimits(jsonce.DefaultMapToCE, req, limits)
+		if len(limit) == 0 {
+			if err != nil {
+				t.Errorf("TestLimits: %s: want no error, have %s", name, err.Error())
+			}
+			return
+		}
+		var le LimitError
+		if !errors.As(err, &le) || le.Limit != limit {
+			t.Errorf("TestLimits: %s: want %s LimitError, have %v", name, limit, err)
+		}
+		if StatusFromError(err) != fasthttp.StatusRequestEntityTooLarge {
+			t.Errorf("TestLimits: %s: want 413, have %d", name, StatusFromError(err))
+		}
+	}
+
+	expect("Unlimited", request(10, jsonce.ModeBatch), Limits{}, "")
+	expect("Batch within", request(10, jsonce.ModeBatch), Limits{MaxBatchEvents: 10}, "")
+	expect("Batch exceeded", request(11, jsonce.ModeBatch), Limits{MaxBatchEvents: 10}, "MaxBatchEvents")
+	expect("Body exceeded", request(1, jsonce.ModeStructure), Limits{MaxBodySize: 10}, "MaxBodySize")
+	expect("Data exceeded", request(1, jsonce.ModeStructure), Limits{MaxEventDataSize: 4}, "MaxEventDataSize")
+	expect("Binary data exceeded", request(1, jsonce.ModeBinary), Limits{MaxEventDataSize: 4}, "MaxEventDataSize")
+
+	extended := request(1, jsonce.ModeBinary)
+	for i := 0; i < 3; i++ {
+		extended.Header.Set(fmt.Sprintf("ce-ext%d", i), "value")
+	}
+	expect("Binary extensions within", extended, Limits{MaxExtensions: 4}, "")
+	expect("Binary extensions exceeded", extended, Limits{MaxExtensions: 3}, "MaxExtensions")
+	// Generated events carry a single extension
+	expect("Batch extensions within", request(2, jsonce.ModeBatch), Limits{MaxExtensions: 1}, "")
+	ces := jsonce.GenerateValidEvents(2)
+	ces[1].Extensions["extension-2"] = "value"
+	batch := &fasthttp.Request{}
+	if err := SendEvents(jsonce.DefaultCEToMap, batch, ces, jsonce.ModeBatch); err != nil {
+		t.Fatalf("TestLimits: %s", err.Error())
+	}
+	expect("Batch extensions exceeded", batch, Limits{MaxExtensions: 1}, "MaxExtensions")
+
+	// A second batch appended to the first is malformed, not silently ignored
+	trailing := request(1, jsonce.ModeBatch)
+	trailing.AppendBody(trailing.Body())
+	if _, _, err := GetEventsWithLimits(jsonce.DefaultMapToCE, trailing, Limits{}); !errors.Is(err, ErrInvalidEvent) {
+		t.Errorf("TestLimits: trailing data: want ErrInvalidEvent, have %v", err)
+	}
+}
+
+func TestLimitsServer(t *testing.T) {
+	ctx := &fasthttp.RequestCtx{}
+	err := SendEvents(jsonce.DefaultCEToMap, &ctx.Request, jsonce.GenerateValidEvents(5), jsonce.ModeBatch)
+	if err != nil {
+		t.Fatalf("TestLimitsServer: %s", err.Error())
+	}
+	srv := CEServer{Limits: Limits{MaxBatchEvents: 4}}
+	srv.serveEvents(ctx, jsonce.DefaultCEToMap, jsonce.DefaultMapToCE, EventHandler(func(ces jsonce.CloudEvents) (jsonce.CloudEvents, error) {
+		t.Fatalf("TestLimitsServer: handler called with %d events", len(ces))
+		return nil, nil
+	}))
+	if code := ctx.Response.StatusCode(); code != fasthttp.StatusRequestEntityTooLarge {
+		t.Fatalf("TestLimitsServer: want 413, have %d", code)
+	}
+}
+
+func TestLimitsClient(t *testing.T) {
+	cec, err := NewCEClient("PUT", target)
+	if err != nil {
+		t.Fatalf("TestLimitsClient: %s", err.Error())
+	}
+	defer cec.Release()
+	cec.Limits = Limits{MaxBodySize: 64}
+
+	if err = cec.SendEvents(jsonce.DefaultCEToMap, jsonce.GenerateValidEvents(5), jsonce.ModeBatch); err != nil {
+		t.Fatalf("TestLimitsClient: %s", err.Error())
+	}
+	err = cec.Send()
+	var le LimitError
+	if !errors.As(err, &le) || le.Limit != "MaxBodySize" {
+		t.Fatalf("TestLimitsClient: want MaxBodySize LimitError, have %v", err)
+	}
+}
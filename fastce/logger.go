@@ -0,0 +1,224 @@
+package fastce
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	j "github.com/creativecactus/fast-cloudevents-go/jsonce"
+)
+
+/*
+ ██╗      ██████╗  ██████╗  ██████╗ ███████╗██████╗
+ ██║     ██╔═══██╗██╔════╝ ██╔════╝ ██╔════╝██╔══██╗
+ ██║     ██║   ██║██║  ███╗██║  ███╗█████╗  ██████╔╝
+ ██║     ██║   ██║██║   ██║██║   ██║██╔══╝  ██╔══██╗
+ ███████╗╚██████╔╝╚██████╔╝╚██████╔╝███████╗██║  ██║
+ ╚══════╝ ╚═════╝  ╚═════╝  ╚═════╝ ╚══════╝╚═╝  ╚═╝
+*/
+
+// Level is the severity of a log record
+type Level int
+
+const (
+	// LevelDebug is used for records about every request or event
+	LevelDebug Level = iota
+	// LevelInfo is used for records about accepted events
+	LevelInfo
+	// LevelWarn is used for records about rejected events
+	LevelWarn
+	// LevelError is used for records about failures of fastce itself
+	LevelError
+)
+
+// String returns the name of a level, eg. "info"
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	}
+	return fmt.Sprintf("level(%d)", int(l))
+}
+
+// Logger receives structured log records from CEServer, CEClient and Mux
+// fields are pairs of a string key and any value, eg. "id", ce.Id, "status", 200.
+type Logger interface {
+	Log(level Level, msg string, fields ...interface{})
+}
+
+// nopLogger is used when no Logger is configured
+type nopLogger struct{}
+
+func (nopLogger) Log(Level, string, ...interface{}) {}
+
+// loggerOrNop returns l, or a Logger which discards everything if l is nil
+func loggerOrNop(l Logger) Logger {
+	if l == nil {
+		return nopLogger{}
+	}
+	return l
+}
+
+// stdLogger writes records to a log.Logger
+type stdLogger struct {
+	logger *log.Logger
+	min    Level
+}
+
+// NewStdLogger creates a Logger which writes records at or above min to logger as
+// `level msg key=value ...`. If logger is nil, the standard logger is used.
+func NewStdLogger(logger *log.Logger, min Level) Logger {
+	return stdLogger{logger: logger, min: min}
+}
+
+// Log implements Logger
+func (l stdLogger) Log(level Level, msg string, fields ...interface{}) {
+	if level < l.min {
+		return
+	}
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "%-5s %s", strings.ToUpper(level.String()), msg)
+	for i := 0; i+1 < len(fields); i += 2 {
+		v := fmt.Sprint(fields[i+1])
+		if strings.ContainsAny(v, " \"=") || len(v) == 0 {
+			v = fmt.Sprintf("%q", v)
+		}
+		fmt.Fprintf(b, " %s=%s", fields[i], v)
+	}
+	if l.logger == nil {
+		log.Print(b.String())
+		return
+	}
+	l.logger.Print(b.String())
+}
+
+// jsonLogger writes records to an io.Writer as JSON lines
+type jsonLogger struct {
+	lock sync.Mutex
+	w    io.Writer
+	min  Level
+}
+
+// NewJSONLogger creates a Logger which writes records at or above min to w, one JSON object per line
+// Each object has "time", "level" and "msg" keys, followed by the fields of the record.
+func NewJSONLogger(w io.Writer, min Level) Logger {
+	return &jsonLogger{w: w, min: min}
+}
+
+// Log implements Logger
+func (l *jsonLogger) Log(level Level, msg string, fields ...interface{}) {
+	if level < l.min {
+		return
+	}
+	b := &strings.Builder{}
+	fmt.Fprintf(b, `{"time":%q,"level":%q,"msg":%s`, time.Now().UTC().Format(time.RFC3339Nano), level.String(), jsonValue(msg))
+	for i := 0; i+1 < len(fields); i += 2 {
+		fmt.Fprintf(b, ",%s:%s", jsonValue(fmt.Sprint(fields[i])), jsonValue(fields[i+1]))
+	}
+	b.WriteString("}\n")
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	io.WriteString(l.w, b.String())
+}
+
+// jsonValue marshals a field value, falling back to its string form
+func jsonValue(v interface{}) string {
+	switch t := v.(type) {
+	case error:
+		v = t.Error()
+	case time.Duration:
+		v = t.String()
+	case fmt.Stringer:
+		v = t.String()
+	}
+	p, err := json.Marshal(v)
+	if err != nil {
+		p, _ = json.Marshal(fmt.Sprint(v))
+	}
+	return string(p)
+}
+
+// sampledLogger passes on every Nth record below LevelWarn per message
+type sampledLogger struct {
+	next  Logger
+	every int
+
+	lock   sync.Mutex
+	counts map[string]int
+}
+
+// Sample wraps a Logger so that only every Nth record of each message below LevelWarn is logged
+// Warnings and errors are always logged. Sampled records carry a "sampled" field of every.
+func Sample(next Logger, every int) Logger {
+	if every <= 1 {
+		return next
+	}
+	return &sampledLogger{next: next, every: every, counts: map[string]int{}}
+}
+
+// Log implements Logger
+func (l *sampledLogger) Log(level Level, msg string, fields ...interface{}) {
+	if level >= LevelWarn {
+		l.next.Log(level, msg, fields...)
+		return
+	}
+	l.lock.Lock()
+	n := l.counts[msg]
+	l.counts[msg] = (n + 1) % l.every
+	l.lock.Unlock()
+	if n == 0 {
+		l.next.Log(level, msg, append(fields[:len(fields):len(fields)], "sampled", l.every)...)
+	}
+}
+
+// logEvents logs a record for each event, with its id, type and source
+// Only the first event is logged in binary and structured modes, as only it is sent.
+func logEvents(l Logger, level Level, msg string, ces j.CloudEvents, mode j.Mode, fields ...interface{}) {
+	if mode != j.ModeBatch && len(ces) > 1 {
+		ces = ces[:1]
+	}
+	for _, ce := range ces {
+		l.Log(level, msg, append([]interface{}{"id", ce.Id, "type", ce.Type, "source", ce.Source, "mode", modeName(mode)}, fields...)...)
+	}
+}
+
+// logOutcome logs the outcome of each event of a request handled by a CEServer
+// Accepted events are logged at LevelInfo and rejected events at LevelWarn. If the events
+// could not be read, a single record is logged without any event attributes.
+func logOutcome(l Logger, ces j.CloudEvents, mode j.Mode, results []EventResult, status int, d time.Duration, err error) {
+	if len(ces) == 0 {
+		if err != nil {
+			l.Log(LevelWarn, "Events rejected", "status", status, "duration", d, "error", err)
+		}
+		return
+	}
+	if err != nil {
+		logEvents(l, LevelWarn, "Event rejected", ces, mode, "status", status, "duration", d, "error", err)
+		return
+	}
+
+	failed := map[[2]string]EventResult{}
+	for _, r := range results {
+		if r.Outcome != OutcomeOK {
+			failed[[2]string{r.Source, r.Id}] = r
+		}
+	}
+	for _, ce := range ces {
+		if r, ok := failed[[2]string{ce.Source, ce.Id}]; ok {
+			logEvents(l, LevelWarn, "Event rejected", j.CloudEvents{ce}, mode, "status", status, "duration", d, "outcome", r.Outcome, "error", r.Reason)
+		} else {
+			logEvents(l, LevelInfo, "Event accepted", j.CloudEvents{ce}, mode, "status", status, "duration", d)
+		}
+	}
+}
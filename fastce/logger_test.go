@@ -0,0 +1,133 @@
+package fastce
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	jsonce "github.com/creativecactus/fast-cloudevents-go/jsonce"
+)
+
+// recordLogger keeps every record logged, for inspection by tests
+type recordLogger struct {
+	lock    sync.Mutex
+	records []map[string]interface{}
+}
+
+func (l *recordLogger) Log(level Level, msg string, fields ...interface{}) {
+	r := map[string]interface{}{"level": level, "msg": msg}
+	for i := 0; i+1 < len(fields); i += 2 {
+		r[fields[i].(string)] = fields[i+1]
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.records = append(l.records, r)
+}
+
+func (l *recordLogger) find(msg string) []map[string]interface{} {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	found := []map[string]interface{}{}
+	for _, r := range l.records {
+		if r["msg"] == msg {
+			found = append(found, r)
+		}
+	}
+	return found
+}
+
+func TestJSONLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := NewJSONLogger(buf, LevelInfo)
+	logger.Log(LevelDebug, "Hidden")
+	logger.Log(LevelWarn, "Event rejected", "id", "1", "status", 400, "error", errors.New("bad \"event\""))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("TestJSONLogger: want 1 line, have %q", buf.String())
+	}
+	r := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(lines[0]), &r); err != nil {
+		t.Fatalf("TestJSONLogger: %s: %s", err.Error(), lines[0])
+	}
+	if r["level"] != "warn" || r["msg"] != "Event rejected" || r["id"] != "1" || r["status"] != float64(400) || r["error"] != `bad "event"` || r["time"] == nil {
+		t.Fatalf("TestJSONLogger: unexpected record %v", r)
+	}
+}
+
+func TestSample(t *testing.T) {
+	rec := &recordLogger{}
+	logger := Sample(rec, 3)
+	for i := 0; i < 7; i++ {
+		logger.Log(LevelInfo, "Event accepted")
+		logger.Log(LevelWarn, "Event rejected")
+	}
+	if n := len(rec.find("Event accepted")); n != 3 {
+		t.Fatalf("TestSample: want 3 sampled records, have %d", n)
+	}
+	if n := len(rec.find("Event rejected")); n != 7 {
+		t.Fatalf("TestSample: want 7 warnings, have %d", n)
+	}
+	if s := rec.find("Event accepted")[0]["sampled"]; s != 3 {
+		t.Fatalf("TestSample: want sampled field 3, have %v", s)
+	}
+}
+
+func TestServerLogger(t *testing.T) {
+	rec := &recordLogger{}
+	srv := &CEServer{Logger: rec}
+	err := srv.StartCE("127.0.0.1:0", jsonce.DefaultCEToMap, jsonce.DefaultMapToCE, func(ces jsonce.CloudEvents) (jsonce.CloudEvents, error) {
+		results := []EventResult{}
+		for i, ce := range ces {
+			var err error
+			if i == 1 {
+				err = errors.New("Rejected")
+			}
+			results = append(results, ResultOf(ce, err))
+		}
+		return nil, NewBatchError(results)
+	})
+	if err != nil {
+		t.Fatalf("TestServerLogger: %s", err.Error())
+	}
+	defer srv.Shutdown(context.Background())
+
+	cec, err := NewCEClient("POST", srv.Addr())
+	if err != nil {
+		t.Fatalf("TestServerLogger: %s", err.Error())
+	}
+	defer cec.Release()
+	crec := &recordLogger{}
+	cec.Logger = crec
+	if err = cec.SendEvents(jsonce.DefaultCEToMap, jsonce.GenerateValidEvents(3), jsonce.ModeBatch); err != nil {
+		t.Fatalf("TestServerLogger: %s", err.Error())
+	}
+	if err = cec.Send(); err != nil {
+		t.Fatalf("TestServerLogger: %s", err.Error())
+	}
+
+	accepted, rejected := rec.find("Event accepted"), rec.find("Event rejected")
+	if len(accepted) != 2 || len(rejected) != 1 {
+		t.Fatalf("TestServerLogger: want 2 accepted and 1 rejected, have %v", rec.records)
+	}
+	r := rejected[0]
+	if r["level"] != LevelWarn || r["mode"] != "batch" || r["status"] != 207 || r["error"] != "Rejected" || r["type"] != "test" || r["duration"] == nil {
+		t.Fatalf("TestServerLogger: unexpected record %v", r)
+	}
+	if sent := crec.find("Request sent"); len(sent) != 1 || sent[0]["status"] != 207 {
+		t.Fatalf("TestServerLogger: unexpected client records %v", crec.records)
+	}
+
+	// Requests whose events cannot be read are logged once, without event attributes
+	cec.Request.SetBody([]byte("not json"))
+	if err = cec.Send(); err != nil {
+		t.Fatalf("TestServerLogger: %s", err.Error())
+	}
+	if failed := rec.find("Events rejected"); len(failed) != 1 || failed[0]["status"] != 400 {
+		t.Fatalf("TestServerLogger: unexpected records %v", rec.records)
+	}
+}
@@ -0,0 +1,359 @@
+package fastce
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	j "github.com/creativecactus/fast-cloudevents-go/jsonce"
+
+	"github.com/valyala/fasthttp"
+)
+
+/*
+ ███╗   ███╗███████╗████████╗██████╗ ██╗ ██████╗███████╗
+ ████╗ ████║██╔════╝╚══██╔══╝██╔══██╗██║██╔════╝██╔════╝
+ ██╔████╔██║█████╗     ██║   ██████╔╝██║██║     ███████╗
+ ██║╚██╔╝██║██╔══╝     ██║   ██╔══██╗██║██║     ╚════██║
+ ██║ ╚═╝ ██║███████╗   ██║   ██║  ██║██║╚██████╗███████║
+ ╚═╝     ╚═╝╚══════╝   ╚═╝   ╚═╝  ╚═╝╚═╝ ╚═════╝╚══════╝
+*/
+
+// The metrics recorded by CEServer and CEClient
+// Every metric but those of a Breaker has a "side" label of "server" or "client".
+const (
+	MetricEventsReceived  = "fastce_events_received"          // Counter by type, source and mode
+	MetricEventsSent      = "fastce_events_sent"              // Counter by type, source and mode
+	MetricDecodeFailures  = "fastce_decode_failures"          // Counter by reason
+	MetricHandlerDuration = "fastce_handler_duration_seconds" // Histogram
+	MetricBodySize        = "fastce_body_size_bytes"          // Histogram of bodies received
+	MetricBatchSize       = "fastce_batch_size_events"        // Histogram of events per request or response received
+	MetricClientRetries   = "fastce_client_retries"           // Counter of events resent
+	MetricResponses       = "fastce_responses"                // Counter by HTTP status code
+	MetricDeadLetters     = "fastce_dead_letters"             // Counter by result of events passed to a DeadLetterSink
+
+	MetricBreakerTransitions = "fastce_breaker_transitions" // Counter by target and new state, see Breaker
+	MetricBreakerRejections  = "fastce_breaker_rejections"  // Counter by target of requests refused by an open circuit
+)
+
+// Metrics receives measurements from a CEServer or CEClient
+// Implement it to wire fastce into another metrics backend, or use a Registry.
+// Labels are given as pairs of name and value. Type and source labels are as sent by clients,
+// so only the first MaxEventLabelValues of each are kept, and later ones are counted as "other".
+type Metrics interface {
+	Add(name string, value float64, labels ...string)     // Increments a counter
+	Observe(name string, value float64, labels ...string) // Records a value in a histogram
+}
+
+// nopMetrics is used when no Metrics are configured
+type nopMetrics struct{}
+
+func (nopMetrics) Add(string, float64, ...string)     {}
+func (nopMetrics) Observe(string, float64, ...string) {}
+
+// metricsOrNop returns m, or Metrics which discard everything if m is nil
+func metricsOrNop(m Metrics) Metrics {
+	if m == nil {
+		return nopMetrics{}
+	}
+	return m
+}
+
+// DefaultBuckets are the upper bounds of histograms without configured Buckets
+var DefaultBuckets = map[string][]float64{
+	MetricHandlerDuration: {.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	MetricBodySize:        {256, 1024, 4096, 16384, 65536, 262144, 1048576, 4194304},
+	MetricBatchSize:       {1, 2, 5, 10, 25, 50, 100, 250, 1000},
+}
+
+// metricHelp describes the metrics recorded by fastce
+var metricHelp = map[string]string{
+	MetricEventsReceived:  "CloudEvents received.",
+	MetricEventsSent:      "CloudEvents sent.",
+	MetricDecodeFailures:  "Requests or responses whose events could not be read.",
+	MetricHandlerDuration: "Time spent in event handlers.",
+	MetricBodySize:        "Size of bodies received.",
+	MetricBatchSize:       "Events per request or response received.",
+	MetricClientRetries:   "Events resent by a client.",
+	MetricResponses:       "HTTP responses by status code.",
+	MetricDeadLetters:     "Events passed to a dead letter sink.",
+
+	MetricBreakerTransitions: "Circuit breaker state changes.",
+	MetricBreakerRejections:  "Requests refused by an open circuit breaker.",
+}
+
+// Registry is a dependency-free Metrics implementation which can be exposed in the
+// Prometheus or OpenMetrics text formats
+type Registry struct {
+	// Buckets are the histogram upper bounds per metric name, DefaultBuckets are used otherwise
+	// Metrics without either use the buckets of MetricHandlerDuration.
+	Buckets map[string][]float64
+
+	lock     sync.Mutex
+	families map[string]*metricFamily
+}
+
+// metricFamily holds every series of a metric name
+type metricFamily struct {
+	histogram bool
+	buckets   []float64
+	series    map[string]*metricSeries // By formatted labels
+}
+
+// metricSeries is the value of a counter, or the state of a histogram
+type metricSeries struct {
+	labels string
+	value  float64  // Counter value, or histogram sum
+	count  uint64   // Histogram observations
+	counts []uint64 // Histogram observations per bucket, not cumulative
+}
+
+// NewRegistry creates an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{families: map[string]*metricFamily{}}
+}
+
+// Add implements Metrics
+func (r *Registry) Add(name string, value float64, labels ...string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if s := r.series(name, false, labels); s != nil {
+		s.value += value
+	}
+}
+
+// Observe implements Metrics
+func (r *Registry) Observe(name string, value float64, labels ...string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	f := r.family(name, true)
+	s := r.series(name, true, labels)
+	if s == nil {
+		return
+	}
+	s.value += value
+	s.count++
+	for i, le := range f.buckets {
+		if value <= le {
+			s.counts[i]++
+			break
+		}
+	}
+}
+
+// family returns the family of a metric, creating it if needed
+// A metric used as both a counter and histogram keeps the kind it was first used as.
+func (r *Registry) family(name string, histogram bool) *metricFamily {
+	if r.families == nil {
+		r.families = map[string]*metricFamily{}
+	}
+	f, ok := r.families[name]
+	if !ok {
+		f = &metricFamily{histogram: histogram, series: map[string]*metricSeries{}}
+		if histogram {
+			f.buckets = r.Buckets[name]
+			if f.buckets == nil {
+				f.buckets = DefaultBuckets[name]
+			}
+			if f.buckets == nil {
+				f.buckets = DefaultBuckets[MetricHandlerDuration]
+			}
+		}
+		r.families[name] = f
+	}
+	return f
+}
+
+// series returns the series of a metric with the given labels, or nil if the kind does not match
+func (r *Registry) series(name string, histogram bool, labels []string) *metricSeries {
+	f := r.family(name, histogram)
+	if f.histogram != histogram {
+		return nil
+	}
+	key := formatLabels(labels)
+	s, ok := f.series[key]
+	if !ok {
+		s = &metricSeries{labels: key}
+		if histogram {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+// labelEscaper escapes label values as required by the text formats
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels formats label pairs as {name="value",...}, sorted by name
+func formatLabels(labels []string) string {
+	if len(labels) < 2 {
+		return ""
+	}
+	pairs := []string{}
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", labels[i], labelEscaper.Replace(labels[i+1])))
+	}
+	sort.Strings(pairs)
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// withLabel adds a label to formatted labels, as used for histogram buckets
+func withLabel(labels, name, value string) string {
+	pair := fmt.Sprintf("%s=\"%s\"", name, labelEscaper.Replace(value))
+	if len(labels) == 0 {
+		return "{" + pair + "}"
+	}
+	return labels[:len(labels)-1] + "," + pair + "}"
+}
+
+// formatFloat formats a sample value as expected by Prometheus
+func formatFloat(v float64) string {
+	if math.IsInf(v, +1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Write writes every metric in the Prometheus text format, or the OpenMetrics text format if openMetrics is set
+func (r *Registry) Write(w io.Writer, openMetrics bool) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	names := []string{}
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	bw := bufio.NewWriter(w)
+	for _, name := range names {
+		f := r.families[name]
+		kind, sample := "counter", name+"_total"
+		if f.histogram {
+			kind, sample = "histogram", name
+		}
+		family := sample
+		if openMetrics {
+			family = name // OpenMetrics names the family without the _total suffix
+		}
+		fmt.Fprintf(bw, "# TYPE %s %s\n", family, kind)
+		if help, ok := metricHelp[name]; ok {
+			fmt.Fprintf(bw, "# HELP %s %s\n", family, help)
+		}
+
+		keys := []string{}
+		for key := range f.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			s := f.series[key]
+			if !f.histogram {
+				fmt.Fprintf(bw, "%s%s %s\n", sample, s.labels, formatFloat(s.value))
+				continue
+			}
+			cumulative := uint64(0)
+			for i, le := range f.buckets {
+				cumulative += s.counts[i]
+				fmt.Fprintf(bw, "%s_bucket%s %d\n", name, withLabel(s.labels, "le", formatFloat(le)), cumulative)
+			}
+			fmt.Fprintf(bw, "%s_bucket%s %d\n", name, withLabel(s.labels, "le", "+Inf"), s.count)
+			fmt.Fprintf(bw, "%s_sum%s %s\n", name, s.labels, formatFloat(s.value))
+			fmt.Fprintf(bw, "%s_count%s %d\n", name, s.labels, s.count)
+		}
+	}
+	if openMetrics {
+		bw.WriteString("# EOF\n")
+	}
+	return bw.Flush()
+}
+
+// ServeMetrics writes every metric to a response, in the OpenMetrics format if the request accepts it
+func (r *Registry) ServeMetrics(ctx *fasthttp.RequestCtx) {
+	openMetrics := strings.Contains(string(ctx.Request.Header.Peek("Accept")), "application/openmetrics-text")
+	if openMetrics {
+		ctx.SetContentType("application/openmetrics-text; version=1.0.0; charset=utf-8")
+	} else {
+		ctx.SetContentType("text/plain; version=0.0.4; charset=utf-8")
+	}
+	if err := r.Write(ctx, openMetrics); err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+	}
+}
+
+// MetricsServer is implemented by Metrics which can be exposed over HTTP, such as Registry
+type MetricsServer interface {
+	ServeMetrics(ctx *fasthttp.RequestCtx)
+}
+
+// modeName returns the label value of a mode
+func modeName(mode j.Mode) string {
+	switch mode {
+	case j.ModeBinary:
+		return "binary"
+	case j.ModeStructure:
+		return "structured"
+	case j.ModeBatch:
+		return "batch"
+	}
+	return "unknown"
+}
+
+// MaxEventLabelValues bounds the distinct values of the type and source labels of event counters
+// Events with a value beyond it are counted as "other", so clients cannot create unbounded series.
+var MaxEventLabelValues = 100
+
+// eventLabels remembers the values of each event label counted so far
+var eventLabels = struct {
+	sync.Mutex
+	seen map[string]map[string]bool
+}{seen: map[string]map[string]bool{}}
+
+// eventLabel returns value, or "other" once MaxEventLabelValues others have been seen for label
+func eventLabel(label, value string) string {
+	eventLabels.Lock()
+	defer eventLabels.Unlock()
+	seen := eventLabels.seen[label]
+	if seen == nil {
+		seen = map[string]bool{}
+		eventLabels.seen[label] = seen
+	}
+	if !seen[value] {
+		if len(seen) >= MaxEventLabelValues {
+			return "other"
+		}
+		seen[value] = true
+	}
+	return value
+}
+
+// countEvents increments a counter for each event, by type, source and mode
+// Only the first event is counted in binary and structured modes, as only it is sent.
+func countEvents(m Metrics, name, side string, ces j.CloudEvents, mode j.Mode) {
+	if mode != j.ModeBatch && len(ces) > 1 {
+		ces = ces[:1]
+	}
+	for _, ce := range ces {
+		m.Add(name, 1, "side", side, "type", eventLabel("type", ce.Type), "source", eventLabel("source", ce.Source), "mode", modeName(mode))
+	}
+}
+
+// failureReason returns the label value of a decode failure
+func failureReason(err error) string {
+	switch {
+	case errors.Is(err, ErrTooLarge):
+		return "too_large"
+	case errors.Is(err, ErrUnsupportedMediaType):
+		return "unsupported_media_type"
+	case errors.Is(err, ErrInvalidEvent):
+		return "invalid_event"
+	}
+	return "other"
+}
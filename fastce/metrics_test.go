@@ -0,0 +1,151 @@
+package fastce
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	jsonce "github.com/creativecactus/fast-cloudevents-go/jsonce"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestRegistryWrite(t *testing.T) {
+	reg := NewRegistry()
+	reg.Buckets = map[string][]float64{"test_seconds": {0.1, 1}}
+	reg.Add("test_requests", 2, "path", `/a"b`)
+	reg.Add("test_requests", 1, "path", `/a"b`)
+	reg.Observe("test_seconds", 0.05)
+	reg.Observe("test_seconds", 0.5)
+	reg.Observe("test_seconds", 5)
+
+	buf := &bytes.Buffer{}
+	if err := reg.Write(buf, false); err != nil {
+		t.Fatalf("TestRegistryWrite: %s", err.Error())
+	}
+	want := `# TYPE test_requests_total counter
+test_requests_total{path="/a\"b"} 3
+# TYPE test_seconds histogram
+test_seconds_bucket{le="0.1"} 1
+test_seconds_bucket{le="1"} 2
+test_seconds_bucket{le="+Inf"} 3
+test_seconds_sum 5.55
+test_seconds_count 3
+`
+	if have := buf.String(); have != want {
+		t.Fatalf("TestRegistryWrite: want\n%s\nhave\n%s", want, have)
+	}
+
+	buf.Reset()
+	if err := reg.Write(buf, true); err != nil {
+		t.Fatalf("TestRegistryWrite: %s", err.Error())
+	}
+	if have := buf.String(); !strings.HasPrefix(have, "# TYPE test_requests counter\n") || !strings.HasSuffix(have, "# EOF\n") {
+		t.Fatalf("TestRegistryWrite: unexpected OpenMetrics exposition\n%s", have)
+	}
+}
+
+func TestServerMetrics(t *testing.T) {
+	reg := NewRegistry()
+	srv := &CEServer{Metrics: reg, MetricsPath: "/metrics"}
+	err := srv.StartCE("127.0.0.1:0", jsonce.DefaultCEToMap, jsonce.DefaultMapToCE, func(ces jsonce.CloudEvents) (jsonce.CloudEvents, error) {
+		return ces, nil
+	})
+	if err != nil {
+		t.Fatalf("TestServerMetrics: %s", err.Error())
+	}
+	defer srv.Shutdown(context.Background())
+
+	cec, err := NewCEClient("POST", srv.Addr())
+	if err != nil {
+		t.Fatalf("TestServerMetrics: %s", err.Error())
+	}
+	defer cec.Release()
+	creg := NewRegistry()
+	cec.Metrics = creg
+	if _, err = ClientTester(cec, jsonce.GenerateValidEvents(3), jsonce.ModeBatch, 3); err != nil {
+		t.Fatalf("TestServerMetrics: %s", err.Error())
+	}
+
+	status, body, err := fasthttp.Get(nil, srv.Addr()+"/metrics")
+	if err != nil || status != fasthttp.StatusOK {
+		t.Fatalf("TestServerMetrics: GET /metrics: %d %v", status, err)
+	}
+	for _, line := range []string{
+		`fastce_events_received_total{mode="batch",side="server",source="Example",type="test"} 3`,
+		`fastce_events_sent_total{mode="batch",side="server",source="Example",type="test"} 3`,
+		`fastce_responses_total{code="200",side="server"} 1`,
+		`fastce_batch_size_events_count{side="server"} 1`,
+		`fastce_handler_duration_seconds_count{side="server"} 1`,
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("TestServerMetrics: server metrics missing %s\n%s", line, body)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	creg.Write(buf, false)
+	for _, line := range []string{
+		`fastce_events_sent_total{mode="batch",side="client",source="Example",type="test"} 3`,
+		`fastce_events_received_total{mode="batch",side="client",source="Example",type="test"} 3`,
+		`fastce_responses_total{code="200",side="client"} 1`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("TestServerMetrics: client metrics missing %s\n%s", line, buf.String())
+		}
+	}
+}
+
+func TestServerMetricsAuth(t *testing.T) {
+	srv := &CEServer{Metrics: NewRegistry(), MetricsPath: "/metrics", Authenticator: BearerAuth{Tokens: map[string]string{"secret": "ops"}}}
+	err := srv.StartCE("127.0.0.1:0", jsonce.DefaultCEToMap, jsonce.DefaultMapToCE, func(ces jsonce.CloudEvents) (jsonce.CloudEvents, error) {
+		return ces, nil
+	})
+	if err != nil {
+		t.Fatalf("TestServerMetricsAuth: %s", err.Error())
+	}
+	defer srv.Shutdown(context.Background())
+
+	get := func(token string) int {
+		req := fasthttp.AcquireRequest()
+		defer fasthttp.ReleaseRequest(req)
+		res := fasthttp.AcquireResponse()
+		defer fasthttp.ReleaseResponse(res)
+		req.SetRequestURI(srv.Addr() + "/metrics")
+		if len(token) > 0 {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		if err := fasthttp.Do(req, res); err != nil {
+			t.Fatalf("TestServerMetricsAuth: %s", err.Error())
+		}
+		return res.StatusCode()
+	}
+	if status := get(""); status != fasthttp.StatusUnauthorized {
+		t.Fatalf("TestServerMetricsAuth: want 401 without credentials, have %d", status)
+	}
+	if status := get("secret"); status != fasthttp.StatusOK {
+		t.Fatalf("TestServerMetricsAuth: want 200 with credentials, have %d", status)
+	}
+}
+
+func TestEventLabelLimit(t *testing.T) {
+	reg := NewRegistry()
+	ces := jsonce.GenerateValidEvents(uint(2 * MaxEventLabelValues))
+	for i := range ces {
+		ces[i].Type = fmt.Sprintf("limit.%d", i)
+	}
+	countEvents(reg, MetricEventsReceived, "server", ces, jsonce.ModeBatch)
+
+	// Types beyond the limit share one series
+	buf := &bytes.Buffer{}
+	reg.Write(buf, false)
+	series := strings.Count(buf.String(), "fastce_events_received_total{")
+	if series > MaxEventLabelValues+1 {
+		t.Fatalf("TestEventLabelLimit: want at most %d series, have %d", MaxEventLabelValues+1, series)
+	}
+	if !strings.Contains(buf.String(), `type="other"`) {
+		t.Fatalf("TestEventLabelLimit: want events counted as other\n%s", buf.String())
+	}
+}
@@ -0,0 +1,190 @@
+package fastce
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	j "github.com/creativecactus/fast-cloudevents-go/jsonce"
+
+	"github.com/valyala/fasthttp"
+)
+
+/*
+ ███╗   ███╗██╗██████╗ ██████╗ ██╗     ███████╗██╗    ██╗ █████╗ ██████╗ ███████╗
+ ████╗ ████║██║██╔══██╗██╔══██╗██║     ██╔════╝██║    ██║██╔══██╗██╔══██╗██╔════╝
+ ██╔████╔██║██║██║  ██║██║  ██║██║     █████╗  ██║ █╗ ██║███████║██████╔╝█████╗
+ ██║╚██╔╝██║██║██║  ██║██║  ██║██║     ██╔══╝  ██║███╗██║██╔══██║██╔══██╗██╔══╝
+ ██║ ╚═╝ ██║██║██████╔╝██████╔╝███████╗███████╗╚███╔███╔╝██║  ██║██║  ██║███████╗
+ ╚═╝     ╚═╝╚═╝╚═════╝ ╚═════╝ ╚══════╝╚══════╝ ╚══╝╚══╝ ╚═╝  ╚═╝╚═╝  ╚═╝╚══════╝
+*/
+
+// Handler handles the events of a request received by a CEServer
+// ctx carries the Metadata of the request, see MetadataFrom, and is cancelled if the
+// request is abandoned. Any events returned are written to the response in the mode of the request.
+type Handler interface {
+	ServeCE(ctx context.Context, ces j.CloudEvents) (j.CloudEvents, error)
+}
+
+// HandlerFunc allows a function to be used as a Handler
+type HandlerFunc func(ctx context.Context, ces j.CloudEvents) (j.CloudEvents, error)
+
+// ServeCE implements Handler
+func (f HandlerFunc) ServeCE(ctx context.Context, ces j.CloudEvents) (j.CloudEvents, error) {
+	return f(ctx, ces)
+}
+
+// ServeCE implements Handler, so that handlers passed to ListenAndServeCE can be wrapped by middleware
+func (h EventHandler) ServeCE(ctx context.Context, ces j.CloudEvents) (j.CloudEvents, error) {
+	return h(ces)
+}
+
+// Middleware wraps a Handler with additional behaviour
+type Middleware func(Handler) Handler
+
+// Chain wraps a Handler with middlewares, the first of which is the outermost
+// That is, Chain(h, A, B) handles a request with A, then B, then h.
+func Chain(h Handler, middlewares ...Middleware) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// Use appends middlewares to those applied to every handler of the server
+// It must be called before the server is started.
+func (srv *CEServer) Use(middlewares ...Middleware) {
+	srv.Middleware = append(srv.Middleware, middlewares...)
+}
+
+// RequestHandler wraps a Handler, and the middleware of the server, as a fasthttp request handler
+// Use it with ListenAndServeHTTP or Start to combine CloudEvents with other HTTP handling.
+// If MetricsPath is set, requests to it are answered with the Metrics of the server, once authenticated.
+func (srv *CEServer) RequestHandler(CEToMap j.CEToMap, MapToCE j.MapToCE, handler Handler) func(*fasthttp.RequestCtx) {
+	handler = timed(srv.Metrics, Chain(handler, srv.Middleware...))
+	exposer, _ := srv.Metrics.(MetricsServer)
+	return func(ctx *fasthttp.RequestCtx) {
+		if exposer != nil && len(srv.MetricsPath) > 0 && string(ctx.Path()) == srv.MetricsPath {
+			if err := srv.authenticate(ctx); err != nil {
+				respond := srv.ErrorResponder
+				if respond == nil {
+					respond = DefaultErrorResponder
+				}
+				respond(ctx, fmt.Errorf("Authenticate: %w", err))
+				return
+			}
+			exposer.ServeMetrics(ctx)
+			return
+		}
+		srv.serveEvents(ctx, CEToMap, MapToCE, handler)
+	}
+}
+
+// timed records the duration of each call to a Handler, including its middleware
+func timed(m Metrics, next Handler) Handler {
+	if m == nil {
+		return next
+	}
+	return HandlerFunc(func(ctx context.Context, ces j.CloudEvents) (j.CloudEvents, error) {
+		start := time.Now()
+		defer func() {
+			m.Observe(MetricHandlerDuration, time.Since(start).Seconds(), "side", "server")
+		}()
+		return next.ServeCE(ctx, ces)
+	})
+}
+
+// Logging logs the path, number of events, duration and any error of each handler call
+// Calls are logged at LevelInfo, or LevelWarn if they fail. If logger is nil, the standard logger is used.
+func Logging(logger Logger) Middleware {
+	if logger == nil {
+		logger = NewStdLogger(nil, LevelInfo)
+	}
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, ces j.CloudEvents) (res j.CloudEvents, err error) {
+			start := time.Now()
+			meta, _ := MetadataFrom(ctx)
+			res, err = next.ServeCE(ctx, ces)
+			if err != nil {
+				logger.Log(LevelWarn, "Handler failed", "method", meta.Method, "path", meta.Path, "events", len(ces), "duration", time.Since(start), "error", err)
+			} else {
+				logger.Log(LevelInfo, "Handler done", "method", meta.Method, "path", meta.Path, "events", len(ces), "replies", len(res), "duration", time.Since(start))
+			}
+			return
+		})
+	}
+}
+
+// Recovery turns a panic in a handler into a PanicError, so the request fails with 500
+// CEServer always recovers panics, but Recovery lets outer middleware see them as errors.
+func Recovery() Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, ces j.CloudEvents) (j.CloudEvents, error) {
+			return callHandler(ctx, next, ces)
+		})
+	}
+}
+
+// Validate rejects requests containing any event for which .Valid() returns an error
+// If strict is true, events with warnings are rejected too. Rejected requests fail with 400.
+func Validate(strict bool) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, ces j.CloudEvents) (j.CloudEvents, error) {
+			for i, ce := range ces {
+				warns, err := ce.Valid()
+				if err == nil && strict && len(warns) > 0 {
+					err = warns[0]
+				}
+				if err != nil {
+					return nil, fmt.Errorf("%w: %d: %s", ErrInvalidEvent, i, err.Error())
+				}
+			}
+			return next.ServeCE(ctx, ces)
+		})
+	}
+}
+
+// Defaults fills empty attributes of each event from those of template
+// Extensions of template are added where missing. Time is set to the time of receipt
+// if neither the event nor template has one.
+func Defaults(template j.CloudEvent) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, ces j.CloudEvents) (j.CloudEvents, error) {
+			now := time.Now()
+			for i := range ces {
+				fillDefaults(&ces[i], template, now)
+			}
+			return next.ServeCE(ctx, ces)
+		})
+	}
+}
+
+// fillDefaults sets each empty attribute of ce from template
+func fillDefaults(ce *j.CloudEvent, template j.CloudEvent, now time.Time) {
+	fill := func(v *string, d string) {
+		if len(*v) == 0 {
+			*v = d
+		}
+	}
+	fill(&ce.Id, template.Id)
+	fill(&ce.Source, template.Source)
+	fill(&ce.SpecVersion, template.SpecVersion)
+	fill(&ce.Type, template.Type)
+	fill(&ce.DataContentType, template.DataContentType)
+	fill(&ce.DataSchema, template.DataSchema)
+	fill(&ce.Subject, template.Subject)
+	if ce.Time.IsZero() {
+		ce.Time = template.Time
+		if ce.Time.IsZero() {
+			ce.Time = now
+		}
+	}
+	for k, v := range template.Extensions {
+		if ce.Extensions == nil {
+			ce.Extensions = map[string]interface{}{}
+		}
+		if _, ok := ce.Extensions[k]; !ok {
+			ce.Extensions[k] = v
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package fastce
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	jsonce "github.com/creativecactus/fast-cloudevents-go/jsonce"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestChain(t *testing.T) {
+	order := []string{}
+	mark := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return HandlerFunc(func(ctx context.Context, ces jsonce.CloudEvents) (jsonce.CloudEvents, error) {
+				order = append(order, name)
+				return next.ServeCE(ctx, ces)
+			})
+		}
+	}
+	h := Chain(EventHandler(func(ces jsonce.CloudEvents) (jsonce.CloudEvents, error) {
+		order = append(order, "handler")
+		return ces, nil
+	}), mark("A"), mark("B"))
+
+	if _, err := h.ServeCE(context.Background(), jsonce.GenerateValidEvents(1)); err != nil {
+		t.Fatalf("TestChain: %s", err.Error())
+	}
+	if have := strings.Join(order, ","); have != "A,B,handler" {
+		t.Fatalf("TestChain: want A,B,handler, have %s", have)
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	srv := &CEServer{}
+	srv.Use(Recovery())
+	handler := srv.RequestHandler(jsonce.DefaultCEToMap, jsonce.DefaultMapToCE, EventHandler(func(ces jsonce.CloudEvents) (jsonce.CloudEvents, error) {
+		panic("boom")
+	}))
+
+	ctx := &fasthttp.RequestCtx{}
+	if err := SendEvents(jsonce.DefaultCEToMap, &ctx.Request, jsonce.GenerateValidEvents(1), jsonce.ModeStructure); err != nil {
+		t.Fatalf("TestRecovery: %s", err.Error())
+	}
+	handler(ctx)
+	if code := ctx.Response.StatusCode(); code != fasthttp.StatusInternalServerError {
+		t.Fatalf("TestRecovery: want 500, have %d", code)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	echo := EventHandler(func(ces jsonce.CloudEvents) (jsonce.CloudEvents, error) {
+		return ces, nil
+	})
+	noTime := jsonce.GenerateValidEvents(1)
+	noTime[0].Time = time.Time{}
+
+	if _, err := Validate(false)(echo).ServeCE(context.Background(), noTime); err != nil {
+		t.Fatalf("TestValidate: warnings should be allowed: %s", err.Error())
+	}
+	_, err := Validate(true)(echo).ServeCE(context.Background(), noTime)
+	if !errors.Is(err, ErrInvalidEvent) {
+		t.Fatalf("TestValidate: strict: want ErrInvalidEvent, have %v", err)
+	}
+
+	noId := jsonce.GenerateValidEvents(2)
+	noId[1].Id = ""
+	_, err = Validate(false)(echo).ServeCE(context.Background(), noId)
+	if StatusFromError(err) != fasthttp.StatusBadRequest {
+		t.Fatalf("TestValidate: want 400, have %v", err)
+	}
+}
+
+func TestDefaults(t *testing.T) {
+	template := jsonce.CloudEvent{
+		Source:     "/defaults",
+		Subject:    "default",
+		Extensions: map[string]interface{}{"tenant": "a"},
+	}
+	h := Chain(EventHandler(func(ces jsonce.CloudEvents) (jsonce.CloudEvents, error) {
+		return ces, nil
+	}), Defaults(template))
+
+	ces := jsonce.CloudEvents{{Id: "1", Type: "test", Subject: "kept"}}
+	res, err := h.ServeCE(context.Background(), ces)
+	if err != nil {
+		t.Fatalf("TestDefaults: %s", err.Error())
+	}
+	ce := res[0]
+	if ce.Source != "/defaults" || ce.Subject != "kept" || ce.Extensions["tenant"] != "a" || ce.Time.IsZero() {
+		t.Fatalf("TestDefaults: unexpected event %+v", ce)
+	}
+}
+
+func TestMiddlewareServer(t *testing.T) {
+	buf := &bytes.Buffer{}
+	srv := &CEServer{}
+	srv.Use(Logging(NewStdLogger(log.New(buf, "", 0), LevelInfo)))
+	err := srv.StartCE("127.0.0.1:0", jsonce.DefaultCEToMap, jsonce.DefaultMapToCE, func(ces jsonce.CloudEvents) (jsonce.CloudEvents, error) {
+		return ces, nil
+	})
+	if err != nil {
+		t.Fatalf("TestMiddlewareServer: %s", err.Error())
+	}
+	defer srv.Shutdown(context.Background())
+
+	cec, err := NewCEClient("PUT", srv.Addr()+"/logged")
+	if err != nil {
+		t.Fatalf("TestMiddlewareServer: %s", err.Error())
+	}
+	defer cec.Release()
+	if _, err = ClientTester(cec, jsonce.GenerateValidEvents(2), jsonce.ModeBatch, 2); err != nil {
+		t.Fatalf("TestMiddlewareServer: %s", err.Error())
+	}
+	if line := buf.String(); !strings.Contains(line, "method=PUT path=/logged events=2 replies=2") {
+		t.Fatalf("TestMiddlewareServer: unexpected log %q", line)
+	}
+}
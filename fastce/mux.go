@@ -0,0 +1,214 @@
+package fastce
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	j "github.com/creativecactus/fast-cloudevents-go/jsonce"
+
+	"github.com/valyala/fasthttp"
+)
+
+/*
+ ███╗   ███╗██╗   ██╗██╗  ██╗
+ ████╗ ████║██║   ██║╚██╗██╔╝
+ ██╔████╔██║██║   ██║ ╚███╔╝
+ ██║╚██╔╝██║██║   ██║ ██╔██╗
+ ██║ ╚═╝ ██║╚██████╔╝██╔╝ ██╗
+ ╚═╝     ╚═╝ ╚═════╝ ╚═╝  ╚═╝
+*/
+
+// EventHandler is the signature of a handler passed to ListenAndServeCE
+// It receives a batch of events and may return events to be sent in reply
+type EventHandler func(j.CloudEvents) (j.CloudEvents, error)
+
+// EventMatcher reports whether a CloudEvent should be dispatched to a route
+type EventMatcher func(ce j.CloudEvent) bool
+
+// Unmatched determines what a Mux does with events which match no route
+type Unmatched int
+
+const (
+	// UnmatchedNotFound rejects the whole request with 404 Not Found
+	UnmatchedNotFound Unmatched = iota
+	// UnmatchedBadRequest rejects the whole request with 400 Bad Request
+	UnmatchedBadRequest
+	// UnmatchedDrop silently discards unmatched events
+	UnmatchedDrop
+	// UnmatchedDefault passes unmatched events to Mux.Default
+	UnmatchedDefault
+)
+
+// ErrNoRoute is wrapped by the RouteError returned when an event matches no route
+var ErrNoRoute = errors.New("No route for event")
+
+// RouteError is returned by Mux.Dispatch when an event matches no route and
+// the Mux is configured to reject the request
+type RouteError struct {
+	Status int    // The HTTP status the request should be rejected with
+	Path   string // The path of the request, if any
+	Id     string // The id of the first unmatched event
+	Type   string // The type of the first unmatched event
+}
+
+// Error implements error
+func (e RouteError) Error() string {
+	return fmt.Sprintf("%s: id=%s type=%s path=%s", ErrNoRoute.Error(), e.Id, e.Type, e.Path)
+}
+
+// Unwrap allows errors.Is(err, ErrNoRoute)
+func (e RouteError) Unwrap() error {
+	return ErrNoRoute
+}
+
+// StatusCode returns the HTTP status the request should be rejected with
+func (e RouteError) StatusCode() int {
+	if e.Status == 0 {
+		return fasthttp.StatusNotFound
+	}
+	return e.Status
+}
+
+// muxRoute is a single registration on a Mux
+type muxRoute struct {
+	paths   []string // path.Match patterns, empty matches any path
+	match   EventMatcher
+	handler EventHandler
+}
+
+// matchPath reports whether the route applies to the given request path
+func (r muxRoute) matchPath(p string) bool {
+	if len(r.paths) == 0 {
+		return true
+	}
+	for _, pattern := range r.paths {
+		if ok, err := path.Match(pattern, p); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Mux dispatches each event of a request to the first registered route which matches it
+// Routes are tried in the order they were registered. The zero value is ready to use,
+// and rejects unmatched events with 404 Not Found.
+type Mux struct {
+	Unmatched Unmatched    // What to do with events matching no route
+	Default   EventHandler // Receives unmatched events if Unmatched is UnmatchedDefault
+	Logger    Logger       // Optional, receives a record for each dropped event, and each request of RequestHandler
+
+	routes []muxRoute
+}
+
+// Handle registers a handler for all events satisfying match
+// If any paths are given, the route only applies to requests whose path matches one of them
+// Paths are patterns as accepted by path.Match, eg. "/orders/*"
+func (mux *Mux) Handle(match EventMatcher, handler EventHandler, paths ...string) {
+	mux.routes = append(mux.routes, muxRoute{
+		paths:   paths,
+		match:   match,
+		handler: handler,
+	})
+}
+
+// HandleType registers a handler for events whose type is exactly t
+func (mux *Mux) HandleType(t string, handler EventHandler, paths ...string) {
+	mux.Handle(func(ce j.CloudEvent) bool {
+		return ce.Type == t
+	}, handler, paths...)
+}
+
+// HandleTypePrefix registers a handler for events whose type begins with prefix
+// For example, "com.example.order." matches "com.example.order.created"
+func (mux *Mux) HandleTypePrefix(prefix string, handler EventHandler, paths ...string) {
+	mux.Handle(func(ce j.CloudEvent) bool {
+		return strings.HasPrefix(ce.Type, prefix)
+	}, handler, paths...)
+}
+
+// HandleSource registers a handler for events whose source matches pattern
+// The pattern is as accepted by path.Match, eg. "/sensors/*"
+func (mux *Mux) HandleSource(pattern string, handler EventHandler, paths ...string) {
+	mux.Handle(func(ce j.CloudEvent) bool {
+		ok, err := path.Match(pattern, ce.Source)
+		return err == nil && ok
+	}, handler, paths...)
+}
+
+// Dispatch passes each event to the handler of its route and collects the results
+// Events which share a route are passed to its handler together, in their original order.
+// Handlers are called in the order in which their first event appeared in the batch.
+// The first handler error stops dispatching and is returned.
+func (mux *Mux) Dispatch(p string, ces j.CloudEvents) (res j.CloudEvents, err error) {
+	order := []int{}                   // Route indexes, in order of first appearance
+	batches := map[int]j.CloudEvents{} // Events per route index, -1 is Default
+
+	for _, ce := range ces {
+		i := mux.route(p, ce)
+		if i < 0 {
+			logger := loggerOrNop(mux.Logger)
+			switch mux.Unmatched {
+			case UnmatchedDrop:
+				logger.Log(LevelDebug, "Event dropped", "id", ce.Id, "type", ce.Type, "source", ce.Source, "path", p)
+				continue
+			case UnmatchedDefault:
+				if mux.Default == nil {
+					logger.Log(LevelDebug, "Event dropped", "id", ce.Id, "type", ce.Type, "source", ce.Source, "path", p)
+					continue
+				}
+			case UnmatchedBadRequest:
+				// Rejected events are logged with the error by the server, or the Logging middleware
+				err = RouteError{Status: fasthttp.StatusBadRequest, Path: p, Id: ce.Id, Type: ce.Type}
+				return
+			default:
+				err = RouteError{Status: fasthttp.StatusNotFound, Path: p, Id: ce.Id, Type: ce.Type}
+				return
+			}
+		}
+		if _, ok := batches[i]; !ok {
+			order = append(order, i)
+		}
+		batches[i] = append(batches[i], ce)
+	}
+
+	for _, i := range order {
+		handler := mux.Default
+		if i >= 0 {
+			handler = mux.routes[i].handler
+		}
+		var out j.CloudEvents
+		if out, err = handler(batches[i]); err != nil {
+			return
+		}
+		res = append(res, out...)
+	}
+	return
+}
+
+// route returns the index of the first route matching the event, or -1
+func (mux *Mux) route(p string, ce j.CloudEvent) int {
+	for i, r := range mux.routes {
+		if r.matchPath(p) && r.match(ce) {
+			return i
+		}
+	}
+	return -1
+}
+
+// ServeCE implements Handler, dispatching events by the path of the request
+func (mux *Mux) ServeCE(ctx context.Context, ces j.CloudEvents) (j.CloudEvents, error) {
+	meta, _ := MetadataFrom(ctx)
+	return mux.Dispatch(meta.Path, ces)
+}
+
+// RequestHandler returns a fasthttp request handler which reads events from a request,
+// dispatches them by the path of the request, and replies in the same mode
+// Use it with CEServer.ListenAndServeHTTP, CEServer.Start or any fasthttp.Server
+// To apply middleware, use CEServer.RequestHandler with the Mux as the Handler instead
+// Each request is logged to the Logger of the Mux, as by CEServer.Logger.
+func (mux *Mux) RequestHandler(CEToMap j.CEToMap, MapToCE j.MapToCE) func(*fasthttp.RequestCtx) {
+	return (&CEServer{Logger: mux.Logger}).RequestHandler(CEToMap, MapToCE, mux)
+}
@@ -0,0 +1,138 @@
+package fastce
+
+import (
+	"errors"
+	"testing"
+
+	jsonce "github.com/creativecactus/fast-cloudevents-go/jsonce"
+
+	"github.com/valyala/fasthttp"
+)
+
+func typedEvents(types ...string) jsonce.CloudEvents {
+	ces := jsonce.GenerateValidEvents(uint(len(types)))
+	for i, t := range types {
+		ces[i].Type = t
+		ces[i].Id = t
+	}
+	return ces
+}
+
+func TestMuxDispatch(t *testing.T) {
+	got := map[string][]string{}
+	record := func(name string) EventHandler {
+		return func(ces jsonce.CloudEvents) (jsonce.CloudEvents, error) {
+			for _, ce := range ces {
+				got[name] = append(got[name], ce.Id)
+			}
+			return ces[:1], nil
+		}
+	}
+
+	mux := &Mux{}
+	mux.HandleType("exact", record("exact"))
+	mux.HandleTypePrefix("order.", record("prefix"))
+	mux.HandleTypePrefix("scoped", record("scoped"), "/scoped/*")
+
+	res, err := mux.Dispatch("/", typedEvents("order.1", "exact", "order.2"))
+	if err != nil {
+		t.Fatalf("TestMuxDispatch: %s", err.Error())
+	}
+	if len(got["prefix"]) != 2 || got["prefix"][0] != "order.1" || got["prefix"][1] != "order.2" {
+		t.Fatalf("TestMuxDispatch: prefix route received %v", got["prefix"])
+	}
+	if len(got["exact"]) != 1 {
+		t.Fatalf("TestMuxDispatch: exact route received %v", got["exact"])
+	}
+	if len(res) != 2 || res[0].Id != "order.1" || res[1].Id != "exact" {
+		t.Fatalf("TestMuxDispatch: unexpected replies %v", res)
+	}
+
+	// Path scoped routes only apply on their paths
+	_, err = mux.Dispatch("/", typedEvents("scoped"))
+	var re RouteError
+	if !errors.As(err, &re) || re.StatusCode() != fasthttp.StatusNotFound {
+		t.Fatalf("TestMuxDispatch: want 404 RouteError, have %v", err)
+	}
+	if _, err = mux.Dispatch("/scoped/a", typedEvents("scoped")); err != nil {
+		t.Fatalf("TestMuxDispatch: %s", err.Error())
+	}
+}
+
+func TestMuxUnmatched(t *testing.T) {
+	mux := &Mux{Unmatched: UnmatchedBadRequest}
+	mux.HandleSource("/known/*", func(ces jsonce.CloudEvents) (jsonce.CloudEvents, error) {
+		return nil, nil
+	})
+
+	_, err := mux.Dispatch("/", typedEvents("a"))
+	if !errors.Is(err, ErrNoRoute) {
+		t.Fatalf("TestMuxUnmatched: want ErrNoRoute, have %v", err)
+	}
+	var re RouteError
+	if !errors.As(err, &re) || re.StatusCode() != fasthttp.StatusBadRequest {
+		t.Fatalf("TestMuxUnmatched: want 400 RouteError, have %v", err)
+	}
+
+	mux.Unmatched = UnmatchedDrop
+	res, err := mux.Dispatch("/", typedEvents("a", "b"))
+	if err != nil || len(res) != 0 {
+		t.Fatalf("TestMuxUnmatched: want dropped events, have %v %v", res, err)
+	}
+
+	mux.Unmatched = UnmatchedDefault
+	mux.Default = func(ces jsonce.CloudEvents) (jsonce.CloudEvents, error) {
+		return ces, nil
+	}
+	res, err = mux.Dispatch("/", typedEvents("a", "b"))
+	if err != nil || len(res) != 2 {
+		t.Fatalf("TestMuxUnmatched: want default echo, have %v %v", res, err)
+	}
+}
+
+func TestMuxRequestHandler(t *testing.T) {
+	mux := &Mux{}
+	mux.HandleType("test", func(ces jsonce.CloudEvents) (jsonce.CloudEvents, error) {
+		return ces, nil
+	}, "/events")
+	handler := mux.RequestHandler(jsonce.DefaultCEToMap, jsonce.DefaultMapToCE)
+
+	for path, status := range map[string]int{
+		"/events": fasthttp.StatusOK,
+		"/other":  fasthttp.StatusNotFound,
+	} {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI(path)
+		err := SendEvents(jsonce.DefaultCEToMap, &ctx.Request, jsonce.GenerateValidEvents(3), jsonce.ModeBatch)
+		if err != nil {
+			t.Fatalf("TestMuxRequestHandler: %s", err.Error())
+		}
+		handler(ctx)
+		if code := ctx.Response.StatusCode(); code != status {
+			t.Fatalf("TestMuxRequestHandler: %s: want status %d, have %d", path, status, code)
+		}
+	}
+}
+
+func TestMuxLogger(t *testing.T) {
+	rec := &recordLogger{}
+	mux := &Mux{Logger: rec}
+	handler := mux.RequestHandler(jsonce.DefaultCEToMap, jsonce.DefaultMapToCE)
+
+	// An unmatched event is logged once, with the error of the request
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/events")
+	if err := SendEvents(jsonce.DefaultCEToMap, &ctx.Request, typedEvents("a"), jsonce.ModeStructure); err != nil {
+		t.Fatalf("TestMuxLogger: %s", err.Error())
+	}
+	handler(ctx)
+	warnings := 0
+	for _, r := range rec.records {
+		if r["level"] == LevelWarn {
+			warnings++
+		}
+	}
+	if warnings != 1 || len(rec.find("Event rejected")) != 1 {
+		t.Fatalf("TestMuxLogger: want 1 warning, have %v", rec.records)
+	}
+}
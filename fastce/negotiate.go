@@ -0,0 +1,259 @@
+package fastce
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	j "github.com/creativecactus/fast-cloudevents-go/jsonce"
+
+	"github.com/valyala/fasthttp"
+)
+
+/*
+ ███╗   ██╗███████╗ ██████╗  ██████╗ ████████╗██╗ █████╗ ████████╗███████╗
+ ████╗  ██║██╔════╝██╔════╝ ██╔═══██╗╚══██╔══╝██║██╔══██╗╚══██╔══╝██╔════╝
+ ██╔██╗ ██║█████╗  ██║  ███╗██║   ██║   ██║   ██║███████║   ██║   █████╗
+ ██║╚██╗██║██╔══╝  ██║   ██║██║   ██║   ██║   ██║██╔══██║   ██║   ██╔══╝
+ ██║ ╚████║███████╗╚██████╔╝╚██████╔╝   ██║   ██║██║  ██║   ██║   ███████╗
+ ╚═╝  ╚═══╝╚══════╝ ╚═════╝  ╚═════╝    ╚═╝   ╚═╝╚═╝  ╚═╝   ╚═╝   ╚══════╝
+*/
+
+// HeaderAcceptPost lists the media types a server accepts, in reply to OPTIONS
+const HeaderAcceptPost = "Accept-Post"
+
+// DefaultModes are the modes tried by Client.SendNegotiated, most efficient first
+var DefaultModes = []j.Mode{j.ModeBatch, j.ModeStructure, j.ModeBinary}
+
+// ModeSupport is what a Client has learned about a mode of its URL
+type ModeSupport int
+
+const (
+	// ModeUnknown has not been tried, or only failed in ways which say nothing of the mode
+	ModeUnknown ModeSupport = iota
+	// ModeAccepted has been answered with a success or per event results
+	ModeAccepted
+	// ModeRejected has been refused with 415, or with 400 where a later mode was accepted
+	ModeRejected
+)
+
+// String implements fmt.Stringer
+func (s ModeSupport) String() string {
+	switch s {
+	case ModeUnknown:
+		return "unknown"
+	case ModeAccepted:
+		return "accepted"
+	case ModeRejected:
+		return "rejected"
+	}
+	return fmt.Sprintf("ModeSupport(%d)", int(s))
+}
+
+// modeCache records the ModeSupport of each mode of a Client
+type modeCache struct {
+	lock    sync.Mutex
+	support map[j.Mode]ModeSupport
+	probe   sync.Once
+}
+
+// get returns the support of a mode
+func (m *modeCache) get(mode j.Mode) ModeSupport {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.support[mode]
+}
+
+// set records the support of modes
+func (m *modeCache) set(support ModeSupport, modes ...j.Mode) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.support == nil {
+		m.support = map[j.Mode]ModeSupport{}
+	}
+	for _, mode := range modes {
+		m.support[mode] = support
+	}
+}
+
+// ModeSupport returns what the Client has learned about a mode of its URL from SendNegotiated
+func (c *Client) ModeSupport(mode j.Mode) ModeSupport {
+	return c.modes.get(mode)
+}
+
+// SendNegotiated sends events in the first of Modes the URL accepts, and returns the mode used
+// Modes known to be rejected are skipped. A mode refused with 415, or with 400 while not known
+// to be accepted, is followed by the next: batch mode sends every event in one request, while
+// structured and binary mode send each event in its own request, reporting failures of some of
+// several events as a BatchError. A mode is only learned to be accepted from a success or per event
+// results, and what is learned is kept for later calls. If ProbeModes is set, the first call asks
+// the URL which modes it accepts with OPTIONS.
+func (c *Client) SendNegotiated(ctx context.Context, ces j.CloudEvents) (res j.CloudEvents, mode j.Mode, err error) {
+	if c.ProbeModes {
+		c.modes.probe.Do(func() {
+			c.probeModes(ctx)
+		})
+	}
+
+	preferred := c.Modes
+	if len(preferred) == 0 {
+		preferred = DefaultModes
+	}
+	modes := []j.Mode{}
+	for _, m := range preferred {
+		if c.modes.get(m) != ModeRejected {
+			modes = append(modes, m)
+		}
+	}
+	if len(modes) == 0 {
+		// Every mode has been rejected, so the receiver may since have changed
+		modes = preferred
+	}
+
+	// A 400 may mean invalid events rather than an unsupported mode, so those modes
+	// are only known to be rejected once another mode is accepted
+	suspects := []j.Mode{}
+	var d DeadLetter
+	for _, mode = range modes {
+		var accepted bool
+		res, d, accepted, err = c.sendIn(ctx, ces, mode)
+		var se StatusError
+		switch {
+		case errors.As(err, &se) && se.Status == fasthttp.StatusUnsupportedMediaType:
+			c.modes.set(ModeRejected, mode)
+			continue
+		case errors.As(err, &se) && se.Status == fasthttp.StatusBadRequest && c.modes.get(mode) != ModeAccepted:
+			suspects = append(suspects, mode)
+			continue
+		}
+		if accepted {
+			c.modes.set(ModeRejected, suspects...)
+			c.modes.set(ModeAccepted, mode)
+		}
+		if err != nil {
+			c.deadLetter(failedEvents(ces, d, err))
+		}
+		return res, mode, err
+	}
+	c.deadLetter(failedEvents(ces, d, err))
+	return res, mode, fmt.Errorf("No mode accepted: %w", err)
+}
+
+// sendIn sends events in one mode, one request per event unless the mode is batch
+// It reports whether any request was accepted, with a success or per event results. The error
+// of a single event is returned as is, as is a rejection of the first, so the next mode can be tried.
+// A request without a response stops the rest, which fail with its error.
+func (c *Client) sendIn(ctx context.Context, ces j.CloudEvents, mode j.Mode) (res j.CloudEvents, d DeadLetter, accepted bool, err error) {
+	if mode == j.ModeBatch || len(ces) == 1 {
+		res, d, err = c.send(ctx, ces, mode)
+		var be BatchError
+		return res, d, err == nil || errors.As(err, &be), err
+	}
+	results := []EventResult{}
+	for i := range ces {
+		var replies j.CloudEvents
+		replies, d, err = c.send(ctx, ces[i:i+1], mode)
+		if err != nil && i == 0 {
+			var se StatusError
+			if errors.As(err, &se) && (se.Status == fasthttp.StatusUnsupportedMediaType || se.Status == fasthttp.StatusBadRequest) {
+				return nil, d, false, err
+			}
+		}
+		var be BatchError
+		var se StatusError
+		if err != nil && !errors.As(err, &be) && !errors.As(err, &se) {
+			// Without a response, eg. when the URL is down or ctx is done, the rest would fail alike
+			for _, ce := range ces[i:] {
+				results = append(results, ResultOf(ce, err))
+			}
+			break
+		}
+		if err == nil || errors.As(err, &be) {
+			accepted = true
+		}
+		if len(be.Results) > 0 {
+			results = append(results, be.Results[0])
+		} else {
+			results = append(results, ResultOf(ces[i], err))
+		}
+		res = append(res, replies...)
+	}
+	return res, d, accepted, NewBatchError(results)
+}
+
+// probeModes asks the URL which modes it accepts with OPTIONS, recording the answer if it has one
+func (c *Client) probeModes(ctx context.Context) {
+	cec, err := c.acquire(ctx)
+	if err != nil {
+		return
+	}
+	defer c.release(cec)
+	cec.Request.Header.SetMethod(fasthttp.MethodOptions)
+	if err = cec.requested(nil); err != nil {
+		return
+	}
+	if err = cec.send(ctx); err != nil || cec.Response.StatusCode() >= 400 {
+		return
+	}
+	accept := string(cec.Response.Header.Peek(HeaderAcceptPost))
+	if accept == "" {
+		return
+	}
+	accepted := acceptedModes(accept)
+	for _, mode := range DefaultModes {
+		if accepted[mode] {
+			c.modes.set(ModeAccepted, mode)
+		} else {
+			c.modes.set(ModeRejected, mode)
+		}
+	}
+}
+
+// acceptedModes returns the modes of the media types in an Accept-Post header
+// Types other than those of structured and batch mode, such as */*, accept binary mode.
+func acceptedModes(header string) map[j.Mode]bool {
+	accepted := map[j.Mode]bool{}
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(strings.SplitN(t, ";", 2)[0])
+		switch {
+		case t == "":
+		case strings.HasPrefix(t, j.ModeBatch.ContentType()):
+			accepted[j.ModeBatch] = true
+		case strings.HasPrefix(t, j.ModeStructure.ContentType()):
+			accepted[j.ModeStructure] = true
+		default:
+			accepted[j.ModeBinary] = true
+		}
+	}
+	return accepted
+}
+
+// acceptsMode reports whether the server accepts requests in a mode
+func (srv *CEServer) acceptsMode(mode j.Mode) bool {
+	if len(srv.Modes) == 0 {
+		return true
+	}
+	for _, m := range srv.Modes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// serveOptions answers OPTIONS with the media types of the accepted modes
+func (srv *CEServer) serveOptions(ctx *fasthttp.RequestCtx) {
+	types := []string{}
+	for _, mode := range []j.Mode{j.ModeBatch, j.ModeStructure} {
+		if srv.acceptsMode(mode) {
+			types = append(types, mode.ContentTypePlus("json"))
+		}
+	}
+	if srv.acceptsMode(j.ModeBinary) {
+		types = append(types, "*/*")
+	}
+	ctx.Response.Header.Set(HeaderAcceptPost, strings.Join(types, ", "))
+	ctx.SetStatusCode(fasthttp.StatusNoContent)
+}
@@ -0,0 +1,222 @@
+package fastce
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	jsonce "github.com/creativecactus/fast-cloudevents-go/jsonce"
+
+	"github.com/valyala/fasthttp"
+)
+
+// negotiateServer starts a server accepting modes, and records the method or mode of each request
+func negotiateServer(t *testing.T, modes []jsonce.Mode) (srv *CEServer, requests func() []string) {
+	lock := sync.Mutex{}
+	received := []string{}
+	srv = &CEServer{Modes: modes}
+	handle := srv.RequestHandler(jsonce.DefaultCEToMap, jsonce.DefaultMapToCE, HandlerFunc(func(ctx context.Context, ces jsonce.CloudEvents) (jsonce.CloudEvents, error) {
+		return nil, nil
+	}))
+	err := srv.Start("127.0.0.1:0", func(ctx *fasthttp.RequestCtx) {
+		request := string(ctx.Method())
+		if mode, err := ReqResFromReq(&ctx.Request).GetMode(); err == nil && !ctx.IsOptions() {
+			request = modeName(mode)
+		}
+		lock.Lock()
+		received = append(received, request)
+		lock.Unlock()
+		handle(ctx)
+	})
+	if err != nil {
+		t.Fatalf("negotiateServer: %s", err.Error())
+	}
+	return srv, func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		requests := received
+		received = []string{}
+		return requests
+	}
+}
+
+func TestSendNegotiated(t *testing.T) {
+	srv, requests := negotiateServer(t, []jsonce.Mode{jsonce.ModeStructure, jsonce.ModeBinary})
+	defer srv.Shutdown(context.Background())
+	client, err := NewClient("POST", srv.Addr(), ClientOptions{})
+	if err != nil {
+		t.Fatalf("TestSendNegotiated: %s", err.Error())
+	}
+
+	// Batch mode is refused with 415, so each event is sent in structured mode
+	_, mode, err := client.SendNegotiated(context.Background(), jsonce.GenerateValidEvents(2))
+	if err != nil || mode != jsonce.ModeStructure {
+		t.Fatalf("TestSendNegotiated: want structured mode, have %d %v", mode, err)
+	}
+	if have := fmt.Sprint(requests()); have != "[batch structured structured]" {
+		t.Fatalf("TestSendNegotiated: unexpected requests %s", have)
+	}
+	if client.ModeSupport(jsonce.ModeBatch) != ModeRejected || client.ModeSupport(jsonce.ModeStructure) != ModeAccepted {
+		t.Fatalf("TestSendNegotiated: want batch rejected and structured accepted")
+	}
+
+	// Which is remembered
+	if _, _, err = client.SendNegotiated(context.Background(), jsonce.GenerateValidEvents(2)); err != nil {
+		t.Fatalf("TestSendNegotiated: %s", err.Error())
+	}
+	if have := fmt.Sprint(requests()); have != "[structured structured]" {
+		t.Fatalf("TestSendNegotiated: unexpected requests %s", have)
+	}
+
+	// Or learned up front with OPTIONS
+	probing, err := NewClient("POST", srv.Addr(), ClientOptions{})
+	if err != nil {
+		t.Fatalf("TestSendNegotiated: %s", err.Error())
+	}
+	probing.ProbeModes = true
+	probing.Modes = []jsonce.Mode{jsonce.ModeBatch, jsonce.ModeBinary}
+	if _, mode, err = probing.SendNegotiated(context.Background(), jsonce.GenerateValidEvents(1)); err != nil || mode != jsonce.ModeBinary {
+		t.Fatalf("TestSendNegotiated: want binary mode, have %d %v", mode, err)
+	}
+	if have := fmt.Sprint(requests()); have != "[OPTIONS binary]" {
+		t.Fatalf("TestSendNegotiated: unexpected requests %s", have)
+	}
+}
+
+func TestSendNegotiatedBadRequest(t *testing.T) {
+	// refuse answers requests whose content type starts with any of its prefixes with 400
+	refuse := atomic.Value{}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("TestSendNegotiatedBadRequest: %s", err.Error())
+	}
+	defer ln.Close()
+	go (&fasthttp.Server{Handler: func(ctx *fasthttp.RequestCtx) {
+		for _, prefix := range refuse.Load().([]string) {
+			if bytes.HasPrefix(ctx.Request.Header.ContentType(), []byte(prefix)) {
+				ctx.SetStatusCode(fasthttp.StatusBadRequest)
+				return
+			}
+		}
+		ctx.SetStatusCode(fasthttp.StatusNoContent)
+	}}).Serve(ln)
+	URL := "http://" + ln.Addr().String()
+
+	// A 400 to a batch is only known to reject the mode once structured mode is accepted
+	refuse.Store([]string{jsonce.ModeBatch.ContentType()})
+	client, err := NewClient("POST", URL, ClientOptions{})
+	if err != nil {
+		t.Fatalf("TestSendNegotiatedBadRequest: %s", err.Error())
+	}
+	if _, mode, err := client.SendNegotiated(context.Background(), jsonce.GenerateValidEvents(2)); err != nil || mode != jsonce.ModeStructure {
+		t.Fatalf("TestSendNegotiatedBadRequest: want structured mode, have %d %v", mode, err)
+	}
+	if client.ModeSupport(jsonce.ModeBatch) != ModeRejected {
+		t.Fatalf("TestSendNegotiatedBadRequest: want batch rejected")
+	}
+
+	// When every mode is refused with 400 the events are likely invalid, so nothing is learned
+	refuse.Store([]string{""})
+	client, err = NewClient("POST", URL, ClientOptions{})
+	if err != nil {
+		t.Fatalf("TestSendNegotiatedBadRequest: %s", err.Error())
+	}
+	var se StatusError
+	if _, _, err = client.SendNegotiated(context.Background(), jsonce.GenerateValidEvents(2)); !errors.As(err, &se) || se.Status != fasthttp.StatusBadRequest {
+		t.Fatalf("TestSendNegotiatedBadRequest: want a 400 StatusError, have %v", err)
+	}
+	for _, mode := range DefaultModes {
+		if s := client.ModeSupport(mode); s != ModeUnknown {
+			t.Fatalf("TestSendNegotiatedBadRequest: want mode %d unknown, have %s", mode, s)
+		}
+	}
+}
+
+func TestSendNegotiatedUnavailable(t *testing.T) {
+	// Batch mode is refused with 400, and binary mode is down
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("TestSendNegotiatedUnavailable: %s", err.Error())
+	}
+	defer ln.Close()
+	go (&fasthttp.Server{Handler: func(ctx *fasthttp.RequestCtx) {
+		if bytes.HasPrefix(ctx.Request.Header.ContentType(), []byte(jsonce.ModeBatch.ContentType())) {
+			ctx.SetStatusCode(fasthttp.StatusBadRequest)
+			return
+		}
+		ctx.SetStatusCode(fasthttp.StatusServiceUnavailable)
+	}}).Serve(ln)
+	client, err := NewClient("POST", "http://"+ln.Addr().String(), ClientOptions{})
+	if err != nil {
+		t.Fatalf("TestSendNegotiatedUnavailable: %s", err.Error())
+	}
+	client.Modes = []jsonce.Mode{jsonce.ModeBatch, jsonce.ModeBinary}
+
+	// The failure of a single event keeps its status
+	var se StatusError
+	if _, _, err = client.SendNegotiated(context.Background(), jsonce.GenerateValidEvents(1)); !errors.As(err, &se) || se.Status != fasthttp.StatusServiceUnavailable {
+		t.Fatalf("TestSendNegotiatedUnavailable: want a 503 StatusError, have %v", err)
+	}
+	// Several events fail as a BatchError
+	var be BatchError
+	if _, _, err = client.SendNegotiated(context.Background(), jsonce.GenerateValidEvents(2)); !errors.As(err, &be) || len(be.Failed()) != 2 {
+		t.Fatalf("TestSendNegotiatedUnavailable: want a BatchError, have %v", err)
+	}
+	// Neither says anything of the modes
+	for _, mode := range client.Modes {
+		if s := client.ModeSupport(mode); s != ModeUnknown {
+			t.Fatalf("TestSendNegotiatedUnavailable: want %s mode unknown, have %s", modeName(mode), s)
+		}
+	}
+}
+
+func TestSendNegotiatedNoResponse(t *testing.T) {
+	// Every connection is closed without a response
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("TestSendNegotiatedNoResponse: %s", err.Error())
+	}
+	defer ln.Close()
+	conns := int32(0)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&conns, 1)
+			conn.Close()
+		}
+	}()
+	sink := &deadLetters{}
+	client, err := NewClient("POST", "http://"+ln.Addr().String(), ClientOptions{})
+	if err != nil {
+		t.Fatalf("TestSendNegotiatedNoResponse: %s", err.Error())
+	}
+	client.Modes = []jsonce.Mode{jsonce.ModeStructure}
+	client.DeadLetter = sink
+
+	// fasthttp retries closed connections, so count those of one event
+	if _, _, err = client.SendNegotiated(context.Background(), jsonce.GenerateValidEvents(1)); err == nil {
+		t.Fatalf("TestSendNegotiatedNoResponse: want error")
+	}
+	sink.take()
+	single := atomic.SwapInt32(&conns, 0)
+
+	// The events after the first are not sent once it fails without a response
+	var be BatchError
+	if _, _, err = client.SendNegotiated(context.Background(), jsonce.GenerateValidEvents(3)); !errors.As(err, &be) || len(be.Failed()) != 3 {
+		t.Fatalf("TestSendNegotiatedNoResponse: want 3 failed events, have %v", err)
+	}
+	if dead := sink.take(); len(dead) != 3 {
+		t.Fatalf("TestSendNegotiatedNoResponse: want 3 events dead lettered, have %d", len(dead))
+	}
+	if n := atomic.LoadInt32(&conns); n != single {
+		t.Fatalf("TestSendNegotiatedNoResponse: want %d connections, have %d", single, n)
+	}
+}
@@ -0,0 +1,269 @@
+package fastce
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	j "github.com/creativecactus/fast-cloudevents-go/jsonce"
+)
+
+/*
+ ██████╗ ██╗   ██╗██████╗ ██╗     ██╗███████╗██╗  ██╗███████╗██████╗
+ ██╔══██╗██║   ██║██╔══██╗██║     ██║██╔════╝██║  ██║██╔════╝██╔══██╗
+ ██████╔╝██║   ██║██████╔╝██║     ██║███████╗███████║█████╗  ██████╔╝
+ ██╔═══╝ ██║   ██║██╔══██╗██║     ██║╚════██║██╔══██║██╔══╝  ██╔══██╗
+ ██║     ╚██████╔╝██████╔╝███████╗██║███████║██║  ██║███████╗██║  ██║
+ ╚═╝      ╚═════╝ ╚═════╝ ╚══════╝╚═╝╚══════╝╚═╝  ╚═╝╚══════╝╚═╝  ╚═╝
+*/
+
+var (
+	// ErrPublisherFull is returned by Publish when the buffer is full and the Overflow policy is OverflowDrop
+	ErrPublisherFull = fmt.Errorf("%w: publisher buffer full", ErrTooManyRequests)
+	// ErrPublisherClosed is returned by Publish once Close has been called
+	ErrPublisherClosed = errors.New("Publisher closed")
+)
+
+// Overflow is what Publish does when the buffer of a Publisher is full
+type Overflow int
+
+const (
+	// OverflowBlock waits for space in the buffer, or for the context of Publish to be done
+	OverflowBlock Overflow = iota
+	// OverflowDrop refuses the event with ErrPublisherFull
+	OverflowDrop
+)
+
+// PublisherOptions configures when a Publisher flushes and how much it buffers
+// The zero value is ready to use.
+type PublisherOptions struct {
+	MaxEvents  int           // Optional, flushes once a batch has this many events, defaults to 100
+	MaxBytes   int           // Optional, flushes before a batch exceeds this estimated size, defaults to 1MiB
+	Linger     time.Duration // Optional, flushes once the oldest event has waited this long, defaults to 10ms
+	BufferSize int           // Optional, events accepted but not yet flushed, defaults to 10 times MaxEvents
+	Overflow   Overflow      // Optional, what to do when the buffer is full, defaults to OverflowBlock
+}
+
+// Delivery is the outcome of an event given to Publish, available once it has been flushed
+type Delivery struct {
+	done   chan struct{}
+	result EventResult
+	err    error
+}
+
+// Done is closed once the event has been flushed
+func (d *Delivery) Done() <-chan struct{} {
+	return d.done
+}
+
+// Wait waits for the event to be flushed and returns its delivery error, if any
+func (d *Delivery) Wait(ctx context.Context) error {
+	select {
+	case <-d.done:
+		return d.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Result returns the outcome of the event, which is only valid once Done is closed
+func (d *Delivery) Result() EventResult {
+	return d.result
+}
+
+// complete records the outcome of the event
+func (d *Delivery) complete(r EventResult, err error) {
+	d.result, d.err = r, err
+	close(d.done)
+}
+
+// publishItem is an event waiting to be flushed
+type publishItem struct {
+	ce       j.CloudEvent
+	size     int
+	delivery *Delivery
+	callback func(EventResult, error)
+}
+
+// Publisher buffers events and sends them with a Client in batches
+// A batch is sent once it reaches MaxEvents or MaxBytes, or its oldest event has waited for
+// Linger. A batch of a single event is sent in structured mode. Batches are sent one at a time,
+// in the order their events were published, and events sent in reply are discarded.
+type Publisher struct {
+	client *Client
+	opts   PublisherOptions
+
+	lock   sync.RWMutex // Held for reading while publishing, and for writing to close
+	closed bool
+	items  chan publishItem
+	done   chan struct{} // Closed once every item has been flushed after Close
+}
+
+// NewPublisher starts a Publisher which sends events with client
+// Call Close to flush any buffered events and stop it.
+func NewPublisher(client *Client, opts PublisherOptions) *Publisher {
+	if opts.MaxEvents < 1 {
+		opts.MaxEvents = 100
+	}
+	if opts.MaxBytes < 1 {
+		opts.MaxBytes = 1 << 20
+	}
+	if opts.Linger <= 0 {
+		opts.Linger = 10 * time.Millisecond
+	}
+	if opts.BufferSize < 1 {
+		opts.BufferSize = 10 * opts.MaxEvents
+	}
+	p := &Publisher{
+		client: client,
+		opts:   opts,
+		items:  make(chan publishItem, opts.BufferSize),
+		done:   make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// Publish buffers an event to be sent, returning a Delivery for its outcome
+// ctx only applies to waiting for space in the buffer, see Overflow.
+func (p *Publisher) Publish(ctx context.Context, ce j.CloudEvent) (*Delivery, error) {
+	d := &Delivery{done: make(chan struct{})}
+	return d, p.publish(ctx, publishItem{ce: ce, size: estimateSize(ce), delivery: d})
+}
+
+// PublishFunc buffers an event to be sent, calling callback with its outcome once it has been flushed
+// The callback is called from the goroutine of the Publisher, so it should not block.
+func (p *Publisher) PublishFunc(ctx context.Context, ce j.CloudEvent, callback func(EventResult, error)) error {
+	return p.publish(ctx, publishItem{ce: ce, size: estimateSize(ce), callback: callback})
+}
+
+// publish adds an item to the buffer
+func (p *Publisher) publish(ctx context.Context, item publishItem) error {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	if p.closed {
+		return ErrPublisherClosed
+	}
+	if p.opts.Overflow == OverflowDrop {
+		select {
+		case p.items <- item:
+			return nil
+		default:
+			return ErrPublisherFull
+		}
+	}
+	select {
+	case p.items <- item:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops accepting events, and waits until the buffered events are flushed or ctx is done
+func (p *Publisher) Close(ctx context.Context) error {
+	p.lock.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.items)
+	}
+	p.lock.Unlock()
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run collects items into batches and flushes them until the buffer is closed
+func (p *Publisher) run() {
+	defer close(p.done)
+	batch, size := []publishItem{}, 0
+	linger := time.NewTimer(p.opts.Linger)
+	linger.Stop()
+	flush := func() {
+		if !linger.Stop() {
+			select {
+			case <-linger.C: // Drop a tick which was not received
+			default:
+			}
+		}
+		if len(batch) > 0 {
+			p.flush(batch)
+		}
+		batch, size = []publishItem{}, 0
+	}
+
+	for {
+		select {
+		case item, ok := <-p.items:
+			if !ok {
+				flush()
+				return
+			}
+			if len(batch) > 0 && size+item.size > p.opts.MaxBytes {
+				flush()
+			}
+			if len(batch) == 0 {
+				linger.Reset(p.opts.Linger)
+			}
+			batch, size = append(batch, item), size+item.size
+			if len(batch) >= p.opts.MaxEvents || size >= p.opts.MaxBytes {
+				flush()
+			}
+		case <-linger.C:
+			flush()
+		}
+	}
+}
+
+// flush sends a batch and completes the delivery of each of its events
+func (p *Publisher) flush(batch []publishItem) {
+	ces := make(j.CloudEvents, 0, len(batch))
+	for _, item := range batch {
+		ces = append(ces, item.ce)
+	}
+	mode := j.ModeBatch
+	if len(ces) == 1 {
+		mode = j.ModeStructure
+	}
+	_, err := p.client.Send(context.Background(), ces, mode)
+
+	var be BatchError
+	results := map[[2]string]EventResult{}
+	if errors.As(err, &be) {
+		for _, r := range be.Results {
+			results[[2]string{r.Source, r.Id}] = r
+		}
+	}
+	for _, item := range batch {
+		r, ierr := ResultOf(item.ce, err), err
+		if be.Results != nil {
+			// Events without a failed result were delivered
+			r, ierr = ResultOf(item.ce, nil), nil
+			if br, ok := results[[2]string{item.ce.Source, item.ce.Id}]; ok && br.Outcome != OutcomeOK {
+				r, ierr = br, fmt.Errorf("Event %s: %s", br.Outcome, br.Reason)
+			}
+		}
+		if item.delivery != nil {
+			item.delivery.complete(r, ierr)
+		}
+		if item.callback != nil {
+			item.callback(r, ierr)
+		}
+	}
+}
+
+// estimateSize returns the approximate size of an event in a JSON batch
+// Data is counted as base64, which is the larger of its encodings.
+func estimateSize(ce j.CloudEvent) int {
+	size := 128 + len(ce.Id) + len(ce.Source) + len(ce.SpecVersion) + len(ce.Type) +
+		len(ce.DataContentType) + len(ce.DataSchema) + len(ce.Subject) + (len(ce.Data)+2)/3*4
+	for k, v := range ce.Extensions {
+		size += len(k) + len(fmt.Sprint(v)) + 6
+	}
+	return size
+}
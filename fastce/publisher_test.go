@@ -0,0 +1,155 @@
+package fastce
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	jsonce "github.com/creativecactus/fast-cloudevents-go/jsonce"
+)
+
+// publishServer records the size and mode of each batch received, and rejects events with the id "bad"
+func publishServer(t *testing.T, release chan struct{}) (srv *CEServer, batches func() []string) {
+	lock := sync.Mutex{}
+	received := []string{}
+	srv = &CEServer{}
+	err := srv.StartHandler("127.0.0.1:0", jsonce.DefaultCEToMap, jsonce.DefaultMapToCE, HandlerFunc(func(ctx context.Context, ces jsonce.CloudEvents) (jsonce.CloudEvents, error) {
+		if release != nil {
+			<-release
+		}
+		meta, _ := MetadataFrom(ctx)
+		lock.Lock()
+		received = append(received, fmt.Sprintf("%d:%d", meta.Mode, len(ces)))
+		lock.Unlock()
+		results := []EventResult{}
+		for _, ce := range ces {
+			var err error
+			if ce.Id == "bad" {
+				err = Permanent(errors.New("Bad event"))
+			}
+			results = append(results, ResultOf(ce, err))
+		}
+		return nil, NewBatchError(results)
+	}))
+	if err != nil {
+		t.Fatalf("publishServer: %s", err.Error())
+	}
+	return srv, func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]string{}, received...)
+	}
+}
+
+func TestPublisher(t *testing.T) {
+	srv, batches := publishServer(t, nil)
+	defer srv.Shutdown(context.Background())
+	client, err := NewClient("POST", srv.Addr(), ClientOptions{})
+	if err != nil {
+		t.Fatalf("TestPublisher: %s", err.Error())
+	}
+
+	pub := NewPublisher(client, PublisherOptions{MaxEvents: 100, Linger: time.Minute})
+	deliveries := []*Delivery{}
+	for i, ce := range jsonce.GenerateValidEvents(250) {
+		ce.Id = fmt.Sprintf("%d", i)
+		if i == 42 {
+			ce.Id = "bad"
+		}
+		d, err := pub.Publish(context.Background(), ce)
+		if err != nil {
+			t.Fatalf("TestPublisher: %s", err.Error())
+		}
+		deliveries = append(deliveries, d)
+	}
+	// The last 50 events are only flushed by Close, as Linger is long
+	if err = pub.Close(context.Background()); err != nil {
+		t.Fatalf("TestPublisher: %s", err.Error())
+	}
+	if have := fmt.Sprint(batches()); have != "[2:100 2:100 2:50]" {
+		t.Fatalf("TestPublisher: unexpected batches %s", have)
+	}
+	for i, d := range deliveries {
+		err := d.Wait(context.Background())
+		if (i == 42) != (err != nil) || (i == 42) != (d.Result().Outcome == OutcomePermanent) {
+			t.Fatalf("TestPublisher: unexpected outcome of %d: %+v %v", i, d.Result(), err)
+		}
+	}
+	if _, err = pub.Publish(context.Background(), jsonce.GenerateValidEvents(1)[0]); err != ErrPublisherClosed {
+		t.Fatalf("TestPublisher: want ErrPublisherClosed, have %v", err)
+	}
+}
+
+func TestPublisherLinger(t *testing.T) {
+	srv, batches := publishServer(t, nil)
+	defer srv.Shutdown(context.Background())
+	client, err := NewClient("POST", srv.Addr(), ClientOptions{})
+	if err != nil {
+		t.Fatalf("TestPublisherLinger: %s", err.Error())
+	}
+
+	pub := NewPublisher(client, PublisherOptions{Linger: 5 * time.Millisecond, MaxBytes: 3000})
+	defer pub.Close(context.Background())
+
+	// A single event is flushed after Linger, in structured mode
+	done := make(chan error, 1)
+	if err = pub.PublishFunc(context.Background(), jsonce.GenerateValidEvents(1)[0], func(r EventResult, err error) {
+		done <- err
+	}); err != nil {
+		t.Fatalf("TestPublisherLinger: %s", err.Error())
+	}
+	if err = <-done; err != nil {
+		t.Fatalf("TestPublisherLinger: %s", err.Error())
+	}
+
+	// Batches are flushed before exceeding MaxBytes
+	var last *Delivery
+	for _, ce := range jsonce.GenerateValidEvents(4) {
+		ce.Data = make([]byte, 900)
+		if last, err = pub.Publish(context.Background(), ce); err != nil {
+			t.Fatalf("TestPublisherLinger: %s", err.Error())
+		}
+	}
+	if err = last.Wait(context.Background()); err != nil {
+		t.Fatalf("TestPublisherLinger: %s", err.Error())
+	}
+	if have := fmt.Sprint(batches()); have != "[1:1 2:2 2:2]" {
+		t.Fatalf("TestPublisherLinger: unexpected batches %s", have)
+	}
+}
+
+func TestPublisherOverflow(t *testing.T) {
+	release := make(chan struct{})
+	srv, _ := publishServer(t, release)
+	defer srv.Shutdown(context.Background())
+	client, err := NewClient("POST", srv.Addr(), ClientOptions{})
+	if err != nil {
+		t.Fatalf("TestPublisherOverflow: %s", err.Error())
+	}
+
+	pub := NewPublisher(client, PublisherOptions{MaxEvents: 1, BufferSize: 1, Overflow: OverflowDrop})
+	ces := jsonce.GenerateValidEvents(3)
+	first, err := pub.Publish(context.Background(), ces[0])
+	if err != nil {
+		t.Fatalf("TestPublisherOverflow: %s", err.Error())
+	}
+	// Wait for the first event to be taken from the buffer and sent, so the next fills it
+	time.Sleep(50 * time.Millisecond)
+	if _, err = pub.Publish(context.Background(), ces[1]); err != nil {
+		t.Fatalf("TestPublisherOverflow: %s", err.Error())
+	}
+	if _, err = pub.Publish(context.Background(), ces[2]); !errors.Is(err, ErrPublisherFull) {
+		t.Fatalf("TestPublisherOverflow: want ErrPublisherFull, have %v", err)
+	}
+
+	close(release)
+	if err = first.Wait(context.Background()); err != nil {
+		t.Fatalf("TestPublisherOverflow: %s", err.Error())
+	}
+	if err = pub.Close(context.Background()); err != nil {
+		t.Fatalf("TestPublisherOverflow: %s", err.Error())
+	}
+}
@@ -0,0 +1,198 @@
+package fastce
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	j "github.com/creativecactus/fast-cloudevents-go/jsonce"
+
+	"github.com/valyala/fasthttp"
+)
+
+/*
+ ██████╗  █████╗ ████████╗███████╗  ██╗     ██╗███╗   ███╗██╗████████╗
+ ██╔══██╗██╔══██╗╚══██╔══╝██╔════╝  ██║     ██║████╗ ████║██║╚══██╔══╝
+ ██████╔╝███████║   ██║   █████╗    ██║     ██║██╔████╔██║██║   ██║
+ ██╔══██╗██╔══██║   ██║   ██╔══╝    ██║     ██║██║╚██╔╝██║██║   ██║
+ ██║  ██║██║  ██║   ██║   ███████╗  ███████╗██║██║ ╚═╝ ██║██║   ██║
+ ╚═╝  ╚═╝╚═╝  ╚═╝   ╚═╝   ╚══════╝  ╚══════╝╚═╝╚═╝     ╚═╝╚═╝   ╚═╝
+*/
+
+// RateKey returns the key of the bucket an event is charged to
+type RateKey func(meta Metadata, ce j.CloudEvent) string
+
+// KeyByIP charges events to the IP address of the client
+func KeyByIP(meta Metadata, ce j.CloudEvent) string {
+	return meta.RemoteIP.String()
+}
+
+// KeyBySource charges events to their source
+func KeyBySource(meta Metadata, ce j.CloudEvent) string {
+	return ce.Source
+}
+
+// KeyByType charges events to their type
+func KeyByType(meta Metadata, ce j.CloudEvent) string {
+	return ce.Type
+}
+
+// RateLimit is a token bucket per key, where each event received costs one token
+// A request is refused with 429 Too Many Requests and Retry-After if any of its keys has
+// too few tokens left, in which case no tokens are taken. Buckets are kept in memory,
+// and forgotten once idle for IdleTimeout. A RateLimit must not be copied once used.
+type RateLimit struct {
+	Rate        float64       // Tokens added per second, a Rate of 0 disables the limit
+	Burst       int           // Optional, the tokens a bucket holds when full, defaults to Rate rounded up
+	Key         RateKey       // Optional, defaults to KeyByIP
+	IdleTimeout time.Duration // Optional, how long an unused bucket is kept, defaults to 10 minutes
+
+	lock    sync.Mutex
+	buckets map[string]*tokenBucket
+	swept   time.Time
+	now     func() time.Time // Replaced in tests
+}
+
+// tokenBucket is the state of one key of a RateLimit
+type tokenBucket struct {
+	tokens float64
+	last   time.Time // When tokens was last refilled
+}
+
+// burst returns the capacity of each bucket
+func (rl *RateLimit) burst() float64 {
+	if rl.Burst > 0 {
+		return float64(rl.Burst)
+	}
+	return math.Ceil(rl.Rate)
+}
+
+// Allow takes a token for each event from the bucket of its key, or returns the error to respond with
+// Errors are StatusError with status 429 and RetryAfter set to when enough tokens will be available,
+// or wrap ErrTooLarge if more tokens are needed than a bucket holds.
+func (rl *RateLimit) Allow(meta Metadata, ces j.CloudEvents) error {
+	if rl.Rate <= 0 {
+		return nil
+	}
+	key := rl.Key
+	if key == nil {
+		key = KeyByIP
+	}
+	costs := map[string]float64{}
+	for _, ce := range ces {
+		costs[key(meta, ce)]++
+	}
+
+	now := time.Now()
+	if rl.now != nil {
+		now = rl.now()
+	}
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+	rl.sweep(now)
+
+	// Check every bucket before taking from any, so refused requests cost nothing
+	burst := rl.burst()
+	wait := time.Duration(0)
+	for k, cost := range costs {
+		if cost > burst {
+			return fmt.Errorf("%w: %d events exceeds the rate limit burst of %d for %q", ErrTooLarge, int(cost), int(burst), k)
+		}
+		b := rl.bucket(k, now)
+		if b.tokens < cost {
+			if w := time.Duration((cost - b.tokens) / rl.Rate * float64(time.Second)); w > wait {
+				wait = w
+			}
+		}
+	}
+	if wait > 0 {
+		return StatusError{
+			Err:        fmt.Errorf("%w: rate limit exceeded", ErrTooManyRequests),
+			Status:     fasthttp.StatusTooManyRequests,
+			RetryAfter: wait,
+		}
+	}
+	for k, cost := range costs {
+		rl.buckets[k].tokens -= cost
+	}
+	return nil
+}
+
+// bucket returns the bucket of a key, refilled up to now
+func (rl *RateLimit) bucket(key string, now time.Time) *tokenBucket {
+	if rl.buckets == nil {
+		rl.buckets = map[string]*tokenBucket{}
+	}
+	b, ok := rl.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: rl.burst(), last: now}
+		rl.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(rl.burst(), b.tokens+elapsed.Seconds()*rl.Rate)
+		b.last = now
+	}
+	return b
+}
+
+// refund returns tokens taken by Allow, when a later RateLimit refuses the same request
+func (rl *RateLimit) refund(meta Metadata, ces j.CloudEvents) {
+	if rl.Rate <= 0 {
+		return
+	}
+	key := rl.Key
+	if key == nil {
+		key = KeyByIP
+	}
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+	for _, ce := range ces {
+		if b, ok := rl.buckets[key(meta, ce)]; ok {
+			b.tokens = math.Min(rl.burst(), b.tokens+1)
+		}
+	}
+}
+
+// sweep forgets buckets which have been idle for IdleTimeout, at most once per IdleTimeout
+// An idle bucket has refilled, so forgetting it does not change the limit as long as
+// IdleTimeout is longer than Burst / Rate seconds.
+func (rl *RateLimit) sweep(now time.Time) {
+	idle := rl.IdleTimeout
+	if idle <= 0 {
+		idle = 10 * time.Minute
+	}
+	if now.Sub(rl.swept) < idle {
+		return
+	}
+	rl.swept = now
+	for k, b := range rl.buckets {
+		if now.Sub(b.last) >= idle {
+			delete(rl.buckets, k)
+		}
+	}
+}
+
+// Keys returns the number of buckets held in memory
+func (rl *RateLimit) Keys() int {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+	return len(rl.buckets)
+}
+
+// allowRates applies every RateLimit of the server to the events of a request
+func (srv *CEServer) allowRates(ctx *fasthttp.RequestCtx, mode j.Mode, ces j.CloudEvents) error {
+	if len(srv.RateLimits) == 0 {
+		return nil
+	}
+	meta := newMetadata(ctx, mode)
+	for i, rl := range srv.RateLimits {
+		if err := rl.Allow(meta, ces); err != nil {
+			for _, taken := range srv.RateLimits[:i] {
+				taken.refund(meta, ces)
+			}
+			return err
+		}
+	}
+	return nil
+}
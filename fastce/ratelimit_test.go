@@ -0,0 +1,94 @@
+package fastce
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	jsonce "github.com/creativecactus/fast-cloudevents-go/jsonce"
+
+	"github.com/valyala/fasthttp"
+)
+
+// sourced generates n events with the given source
+func sourced(source string, n int) jsonce.CloudEvents {
+	ces := jsonce.GenerateValidEvents(uint(n))
+	for i := range ces {
+		ces[i].Source = source
+	}
+	return ces
+}
+
+func TestRateLimit(t *testing.T) {
+	now := time.Unix(0, 0)
+	rl := &RateLimit{Rate: 10, Burst: 5, Key: KeyBySource, IdleTimeout: time.Second}
+	rl.now = func() time.Time { return now }
+
+	if err := rl.Allow(Metadata{}, sourced("a", 3)); err != nil {
+		t.Fatalf("TestRateLimit: %s", err.Error())
+	}
+	// Refused requests take no tokens, and report when enough will be available
+	var se StatusError
+	err := rl.Allow(Metadata{}, append(sourced("a", 3), sourced("b", 1)...))
+	if !errors.As(err, &se) || se.Status != fasthttp.StatusTooManyRequests || se.RetryAfter != 100*time.Millisecond {
+		t.Fatalf("TestRateLimit: want 429 after 100ms, have %#v", err)
+	}
+	if err = rl.Allow(Metadata{}, sourced("b", 5)); err != nil {
+		t.Fatalf("TestRateLimit: %s", err.Error())
+	}
+	now = now.Add(100 * time.Millisecond)
+	if err = rl.Allow(Metadata{}, sourced("a", 3)); err != nil {
+		t.Fatalf("TestRateLimit: %s", err.Error())
+	}
+	if err = rl.Allow(Metadata{}, sourced("c", 6)); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("TestRateLimit: want ErrTooLarge, have %v", err)
+	}
+
+	// Idle buckets are forgotten
+	now = now.Add(2 * time.Second)
+	if err = rl.Allow(Metadata{}, sourced("d", 1)); err != nil {
+		t.Fatalf("TestRateLimit: %s", err.Error())
+	}
+	if keys := rl.Keys(); keys != 1 {
+		t.Fatalf("TestRateLimit: want 1 key after eviction, have %d", keys)
+	}
+}
+
+func TestRateLimitServer(t *testing.T) {
+	srv := &CEServer{RateLimits: []*RateLimit{{Rate: 1, Burst: 2}}}
+	err := srv.StartCE("127.0.0.1:0", jsonce.DefaultCEToMap, jsonce.DefaultMapToCE, func(ces jsonce.CloudEvents) (jsonce.CloudEvents, error) {
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatalf("TestRateLimitServer: %s", err.Error())
+	}
+	defer srv.Shutdown(context.Background())
+
+	cec, err := NewCEClient("POST", srv.Addr())
+	if err != nil {
+		t.Fatalf("TestRateLimitServer: %s", err.Error())
+	}
+	defer cec.Release()
+	if _, err = cec.Deliver(jsonce.DefaultCEToMap, jsonce.DefaultMapToCE, sourced("a", 2), jsonce.ModeBatch, 1); err != nil {
+		t.Fatalf("TestRateLimitServer: %s", err.Error())
+	}
+
+	// Retry-After is returned when it is longer than the client will wait
+	cec.MaxRetryAfter = time.Millisecond
+	_, err = cec.Deliver(jsonce.DefaultCEToMap, jsonce.DefaultMapToCE, sourced("a", 1), jsonce.ModeBatch, 2)
+	var se StatusError
+	if !errors.As(err, &se) || se.Status != fasthttp.StatusTooManyRequests || se.RetryAfter != time.Second {
+		t.Fatalf("TestRateLimitServer: want 429 with Retry-After 1s, have %#v", err)
+	}
+
+	// Otherwise the client waits and resends
+	cec.MaxRetryAfter = 0
+	start := time.Now()
+	if _, err = cec.Deliver(jsonce.DefaultCEToMap, jsonce.DefaultMapToCE, sourced("a", 1), jsonce.ModeBatch, 2); err != nil {
+		t.Fatalf("TestRateLimitServer: %s", err.Error())
+	}
+	if waited := time.Since(start); waited < time.Second {
+		t.Fatalf("TestRateLimitServer: want a wait of Retry-After, have %s", waited)
+	}
+}
@@ -0,0 +1,118 @@
+package fastce
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	j "github.com/creativecactus/fast-cloudevents-go/jsonce"
+
+	"github.com/valyala/fasthttp"
+)
+
+/*
+ ██████╗ ███████╗ ██████╗ ██████╗ ██╗   ██╗███████╗██████╗ ██╗   ██╗
+ ██╔══██╗██╔════╝██╔════╝██╔═══██╗██║   ██║██╔════╝██╔══██╗╚██╗ ██╔╝
+ ██████╔╝█████╗  ██║     ██║   ██║██║   ██║█████╗  ██████╔╝ ╚████╔╝
+ ██╔══██╗██╔══╝  ██║     ██║   ██║╚██╗ ██╔╝██╔══╝  ██╔══██╗  ╚██╔╝
+ ██║  ██║███████╗╚██████╗╚██████╔╝ ╚████╔╝ ███████╗██║  ██║   ██║
+ ╚═╝  ╚═╝╚══════╝ ╚═════╝ ╚═════╝   ╚═══╝  ╚══════╝╚═╝  ╚═╝   ╚═╝
+*/
+
+// The defaults of PanicEvent
+const (
+	DefaultPanicEventType   = "fastce.handler.panic"
+	DefaultPanicEventSource = "/fastce"
+)
+
+// PanicError is the error of a handler which panicked
+// CEServer recovers panics in handlers and responds 500, see also Recovery.
+type PanicError struct {
+	Value interface{} // As passed to panic
+	Stack []byte      // Of the panicking goroutine, as from debug.Stack
+}
+
+// Error implements error
+func (e PanicError) Error() string {
+	return fmt.Sprintf("Handler panic: %v", e.Value)
+}
+
+// StatusCode returns the HTTP status of the error (500)
+func (e PanicError) StatusCode() int {
+	return fasthttp.StatusInternalServerError
+}
+
+// PanicEvent configures a CEServer to reply with CloudEvents when a handler panics
+// One event is sent per event of the request, in the same mode, with a status of 500.
+// The data of each is a JSON object with the "message" of the panic and the "eventid" of the original event.
+type PanicEvent struct {
+	Type   string // Optional, defaults to DefaultPanicEventType
+	Source string // Optional, defaults to DefaultPanicEventSource
+}
+
+// Events returns the events describing a panic while handling ces
+func (pe PanicEvent) Events(ces j.CloudEvents, p PanicError) (res j.CloudEvents, err error) {
+	if len(pe.Type) == 0 {
+		pe.Type = DefaultPanicEventType
+	}
+	if len(pe.Source) == 0 {
+		pe.Source = DefaultPanicEventSource
+	}
+	if len(ces) == 0 {
+		ces = j.CloudEvents{{}} // Still describe the panic, without an original event
+	}
+
+	now := time.Now()
+	for _, ce := range ces {
+		var data []byte
+		data, err = json.Marshal(map[string]string{
+			"message": p.Error(),
+			"eventid": ce.Id,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Marshal panic: %s", err.Error())
+		}
+		res = append(res, j.CloudEvent{
+			Id:              newEventId(),
+			Source:          pe.Source,
+			SpecVersion:     "1.0",
+			Type:            pe.Type,
+			DataContentType: "application/json",
+			Time:            now,
+			Data:            data,
+		})
+	}
+	return
+}
+
+// newEventId returns a random id for events created by fastce
+func newEventId() string {
+	p := make([]byte, 16)
+	if _, err := rand.Read(p); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(p)
+}
+
+// callHandler calls a handler, turning a panic into a PanicError
+func callHandler(ctx context.Context, handler Handler, ces j.CloudEvents) (res j.CloudEvents, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			res, err = nil, PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+	return handler.ServeCE(ctx, ces)
+}
+
+// logPanic logs a panic with its stack at LevelError
+// Panics are always logged, using the standard logger if l is nil.
+func logPanic(l Logger, err PanicError, fields ...interface{}) {
+	if l == nil {
+		l = NewStdLogger(nil, LevelError)
+	}
+	l.Log(LevelError, "Handler panic", append(fields[:len(fields):len(fields)], "error", err, "stack", string(err.Stack))...)
+}
@@ -0,0 +1,85 @@
+package fastce
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	jsonce "github.com/creativecactus/fast-cloudevents-go/jsonce"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestPanicEvent(t *testing.T) {
+	rec := &recordLogger{}
+	srv := &CEServer{Logger: rec, PanicEvent: &PanicEvent{Type: "com.example.panic"}}
+	handler := srv.RequestHandler(jsonce.DefaultCEToMap, jsonce.DefaultMapToCE, EventHandler(func(ces jsonce.CloudEvents) (jsonce.CloudEvents, error) {
+		panic("boom")
+	}))
+
+	ces := jsonce.GenerateValidEvents(2)
+	ces[1].Id = "second"
+	ctx := &fasthttp.RequestCtx{}
+	if err := SendEvents(jsonce.DefaultCEToMap, &ctx.Request, ces, jsonce.ModeBatch); err != nil {
+		t.Fatalf("TestPanicEvent: %s", err.Error())
+	}
+	handler(ctx)
+	if code := ctx.Response.StatusCode(); code != fasthttp.StatusInternalServerError {
+		t.Fatalf("TestPanicEvent: want 500, have %d", code)
+	}
+
+	res, mode, err := RecvEvents(jsonce.DefaultMapToCE, &ctx.Response)
+	if err != nil {
+		t.Fatalf("TestPanicEvent: %s", err.Error())
+	}
+	if mode != jsonce.ModeBatch || len(res) != 2 {
+		t.Fatalf("TestPanicEvent: want 2 events in batch mode, have %d in mode %d", len(res), mode)
+	}
+	for i, re := range res {
+		if re.Type != "com.example.panic" || re.Source != DefaultPanicEventSource {
+			t.Fatalf("TestPanicEvent: unexpected event %+v", re)
+		}
+		data := map[string]string{}
+		if err = json.Unmarshal(re.Data, &data); err != nil {
+			t.Fatalf("TestPanicEvent: %s: %s", err.Error(), re.Data)
+		}
+		if data["eventid"] != ces[i].Id || data["message"] != "Handler panic: boom" {
+			t.Fatalf("TestPanicEvent: unexpected data %v", data)
+		}
+	}
+
+	panics := rec.find("Handler panic")
+	if len(panics) != 1 || panics[0]["level"] != LevelError || !strings.Contains(panics[0]["stack"].(string), "recovery_test.go") {
+		t.Fatalf("TestPanicEvent: want a logged stack, have %v", rec.records)
+	}
+}
+
+func TestPanicWithoutRecovery(t *testing.T) {
+	srv := &CEServer{Logger: &recordLogger{}}
+	handler := srv.RequestHandler(jsonce.DefaultCEToMap, jsonce.DefaultMapToCE, EventHandler(func(ces jsonce.CloudEvents) (jsonce.CloudEvents, error) {
+		panic("boom")
+	}))
+
+	ctx := &fasthttp.RequestCtx{}
+	if err := SendEvents(jsonce.DefaultCEToMap, &ctx.Request, jsonce.GenerateValidEvents(1), jsonce.ModeBinary); err != nil {
+		t.Fatalf("TestPanicWithoutRecovery: %s", err.Error())
+	}
+	handler(ctx)
+	if code := ctx.Response.StatusCode(); code != fasthttp.StatusInternalServerError {
+		t.Fatalf("TestPanicWithoutRecovery: want 500, have %d", code)
+	}
+	if body := string(ctx.Response.Body()); !strings.Contains(body, "Handler panic: boom") {
+		t.Fatalf("TestPanicWithoutRecovery: unexpected body %q", body)
+	}
+}
+
+func TestLogPanicFields(t *testing.T) {
+	// The fields of the caller are not written to, even with spare capacity
+	fields := make([]interface{}, 2, 4)
+	fields[0], fields[1] = "method", "POST"
+	spare := fields[:4]
+	logPanic(&recordLogger{}, PanicError{Value: "boom"}, fields...)
+	if spare[2] != nil || spare[3] != nil {
+		t.Fatalf("TestLogPanicFields: caller fields overwritten: %v", spare)
+	}
+}
@@ -0,0 +1,168 @@
+package fastce
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	j "github.com/creativecactus/fast-cloudevents-go/jsonce"
+)
+
+/*
+ ██████╗ ███████╗ ██████╗ ██╗   ██╗███████╗███████╗████████╗
+ ██╔══██╗██╔════╝██╔═══██╗██║   ██║██╔════╝██╔════╝╚══██╔══╝
+ ██████╔╝█████╗  ██║   ██║██║   ██║█████╗  ███████╗   ██║
+ ██╔══██╗██╔══╝  ██║▄▄ ██║██║   ██║██╔══╝  ╚════██║   ██║
+ ██║  ██║███████╗╚██████╔╝╚██████╔╝███████╗███████║   ██║
+ ╚═╝  ╚═╝╚══════╝ ╚══▀▀═╝  ╚═════╝ ╚══════╝╚══════╝   ╚═╝
+*/
+
+// CorrelationExtension is the extension tying a reply to the request event it answers
+const CorrelationExtension = "correlationid"
+
+// ErrorReplySuffix ends the type of events describing a failure, see ErrorReply
+const ErrorReplySuffix = ".error"
+
+var (
+	// ErrNoReply is returned by Requester.Request when the response holds no events
+	ErrNoReply = errors.New("No reply")
+	// ErrUnexpectedReply is wrapped by errors of Requester.Request when the reply does not match the request
+	ErrUnexpectedReply = errors.New("Unexpected reply")
+)
+
+// ReplyError is the error of a reply describing a failure
+type ReplyError struct {
+	Reply   j.CloudEvent
+	Message string // The "message" of the data of the reply, or the data itself
+}
+
+// Error implements error
+func (e ReplyError) Error() string {
+	return fmt.Sprintf("Error reply %s from %s: %s", e.Reply.Type, e.Reply.Source, e.Message)
+}
+
+// newReplyError returns the ReplyError of a reply
+func newReplyError(reply j.CloudEvent) ReplyError {
+	data := struct {
+		Message string `json:"message"`
+	}{}
+	if err := json.Unmarshal(reply.Data, &data); err != nil || data.Message == "" {
+		data.Message = string(reply.Data)
+	}
+	return ReplyError{Reply: reply, Message: data.Message}
+}
+
+// IsErrorReply reports whether an event describes a failure, by default those with a type ending
+// in ErrorReplySuffix and those sent by PanicEvent
+func IsErrorReply(ce j.CloudEvent) bool {
+	return strings.HasSuffix(ce.Type, ErrorReplySuffix) || ce.Type == DefaultPanicEventType
+}
+
+// Requester sends command events and waits for the event replied in the response
+type Requester struct {
+	Client    *Client
+	Mode      j.Mode                  // Optional, the mode of requests, defaults to binary
+	ReplyType string                  // Optional, the type a successful reply must have
+	IsError   func(j.CloudEvent) bool // Optional, decides whether a reply describes a failure, defaults to IsErrorReply
+}
+
+// Request sends an event and returns the reply correlated with it
+// The event is given a new correlationid extension unless it has one. The response may be in any
+// mode, and must hold an event with the same correlationid. A reply describing a failure is returned
+// as a ReplyError, and one of a type other than ReplyType wraps ErrUnexpectedReply.
+func (r Requester) Request(ctx context.Context, ce j.CloudEvent) (reply j.CloudEvent, err error) {
+	extensions := map[string]interface{}{}
+	for k, v := range ce.Extensions {
+		extensions[k] = v
+	}
+	id, ok := extensions[CorrelationExtension]
+	if !ok || fmt.Sprint(id) == "" {
+		id = newEventId()
+		extensions[CorrelationExtension] = id
+	}
+	ce.Extensions = extensions
+
+	replies, err := r.Client.Send(ctx, j.CloudEvents{ce}, r.Mode)
+	if err != nil {
+		return reply, err
+	}
+	if len(replies) == 0 {
+		return reply, ErrNoReply
+	}
+	found := false
+	for _, re := range replies {
+		if fmt.Sprint(re.Extensions[CorrelationExtension]) == fmt.Sprint(id) {
+			reply, found = re, true
+			break
+		}
+	}
+	if !found {
+		return replies[0], fmt.Errorf("%w: no reply has %s %v", ErrUnexpectedReply, CorrelationExtension, id)
+	}
+
+	isError := r.IsError
+	if isError == nil {
+		isError = IsErrorReply
+	}
+	if isError(reply) {
+		return reply, newReplyError(reply)
+	}
+	if r.ReplyType != "" && reply.Type != r.ReplyType {
+		return reply, fmt.Errorf("%w: type %s, want %s", ErrUnexpectedReply, reply.Type, r.ReplyType)
+	}
+	return reply, nil
+}
+
+// Correlate copies the correlationid of request events onto replies which have none
+// Each reply takes that of the request event at the same position, or of the only request event.
+func Correlate() Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, ces j.CloudEvents) (res j.CloudEvents, err error) {
+			res, err = next.ServeCE(ctx, ces)
+			for i := range res {
+				request := i
+				if len(ces) == 1 {
+					request = 0
+				}
+				if request >= len(ces) {
+					break
+				}
+				id, ok := ces[request].Extensions[CorrelationExtension]
+				if !ok {
+					continue
+				}
+				if _, ok = res[i].Extensions[CorrelationExtension]; ok {
+					continue
+				}
+				extensions := map[string]interface{}{CorrelationExtension: id}
+				for k, v := range res[i].Extensions {
+					extensions[k] = v
+				}
+				res[i].Extensions = extensions
+			}
+			return
+		})
+	}
+}
+
+// ErrorReply returns an event describing the failure of handling ce, for handlers to reply with
+// Its type is that of ce with ErrorReplySuffix, and its data a JSON object with the "message" of err.
+func ErrorReply(ce j.CloudEvent, source string, err error) j.CloudEvent {
+	data, _ := json.Marshal(map[string]string{"message": err.Error()})
+	reply := j.CloudEvent{
+		Id:              newEventId(),
+		Source:          source,
+		SpecVersion:     "1.0",
+		Type:            ce.Type + ErrorReplySuffix,
+		DataContentType: "application/json",
+		Time:            time.Now(),
+		Data:            data,
+	}
+	if id, ok := ce.Extensions[CorrelationExtension]; ok {
+		reply.Extensions = map[string]interface{}{CorrelationExtension: id}
+	}
+	return reply
+}
@@ -0,0 +1,85 @@
+package fastce
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	jsonce "github.com/creativecactus/fast-cloudevents-go/jsonce"
+)
+
+func TestRequest(t *testing.T) {
+	srv := &CEServer{}
+	srv.Use(Correlate())
+	err := srv.StartHandler("127.0.0.1:0", jsonce.DefaultCEToMap, jsonce.DefaultMapToCE, HandlerFunc(func(ctx context.Context, ces jsonce.CloudEvents) (jsonce.CloudEvents, error) {
+		reply := jsonce.GenerateValidEvents(1)[0]
+		reply.Type = "order.created"
+		switch ces[0].Type {
+		case "order.refused":
+			return jsonce.CloudEvents{ErrorReply(ces[0], "/orders", errors.New("Out of stock"))}, nil
+		case "order.stray":
+			reply.Extensions[CorrelationExtension] = "another"
+		case "order.silent":
+			return nil, nil
+		}
+		return jsonce.CloudEvents{reply}, nil
+	}))
+	if err != nil {
+		t.Fatalf("TestRequest: %s", err.Error())
+	}
+	defer srv.Shutdown(context.Background())
+	client, err := NewClient("POST", srv.Addr(), ClientOptions{})
+	if err != nil {
+		t.Fatalf("TestRequest: %s", err.Error())
+	}
+
+	for _, mode := range []jsonce.Mode{jsonce.ModeBinary, jsonce.ModeStructure, jsonce.ModeBatch} {
+		r := Requester{Client: client, Mode: mode, ReplyType: "order.created"}
+		request := func(typ string) (jsonce.CloudEvent, error) {
+			ce := jsonce.GenerateValidEvents(1)[0]
+			ce.Type = typ
+			return r.Request(context.Background(), ce)
+		}
+
+		reply, err := request("order.create")
+		if err != nil || reply.Type != "order.created" || reply.Extensions[CorrelationExtension] == "" {
+			t.Fatalf("TestRequest: %d: want a correlated reply, have %+v %v", mode, reply, err)
+		}
+
+		var re ReplyError
+		if _, err = request("order.refused"); !errors.As(err, &re) || re.Message != "Out of stock" || re.Reply.Type != "order.refused.error" {
+			t.Fatalf("TestRequest: %d: want a ReplyError, have %v", mode, err)
+		}
+		if _, err = request("order.stray"); !errors.Is(err, ErrUnexpectedReply) {
+			t.Fatalf("TestRequest: %d: want an uncorrelated reply refused, have %v", mode, err)
+		}
+		if _, err = request("order.silent"); err != ErrNoReply {
+			t.Fatalf("TestRequest: %d: want ErrNoReply, have %v", mode, err)
+		}
+		r.ReplyType = "order.updated"
+		if _, err = request("order.create"); !errors.Is(err, ErrUnexpectedReply) {
+			t.Fatalf("TestRequest: %d: want a reply of the wrong type refused, have %v", mode, err)
+		}
+	}
+}
+
+func TestCorrelate(t *testing.T) {
+	ces := jsonce.GenerateValidEvents(2)
+	ces[0].Extensions[CorrelationExtension] = "a"
+	ces[1].Extensions[CorrelationExtension] = "b"
+	h := Chain(HandlerFunc(func(ctx context.Context, ces jsonce.CloudEvents) (jsonce.CloudEvents, error) {
+		res := jsonce.GenerateValidEvents(3)
+		res[1].Extensions[CorrelationExtension] = "kept"
+		return res, nil
+	}), Correlate())
+	res, err := h.ServeCE(context.Background(), ces)
+	if err != nil {
+		t.Fatalf("TestCorrelate: %s", err.Error())
+	}
+	if res[0].Extensions[CorrelationExtension] != "a" || res[1].Extensions[CorrelationExtension] != "kept" {
+		t.Fatalf("TestCorrelate: unexpected correlation %v %v", res[0].Extensions, res[1].Extensions)
+	}
+	if _, ok := res[2].Extensions[CorrelationExtension]; ok {
+		t.Fatalf("TestCorrelate: want no correlation without a matching request event, have %v", res[2].Extensions)
+	}
+}
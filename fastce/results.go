@@ -0,0 +1,281 @@
+package fastce
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	j "github.com/creativecactus/fast-cloudevents-go/jsonce"
+
+	"github.com/valyala/fasthttp"
+)
+
+/*
+ ██████╗ ███████╗███████╗██╗   ██╗██╗  ████████╗███████╗
+ ██╔══██╗██╔════╝██╔════╝██║   ██║██║  ╚══██╔══╝██╔════╝
+ ██████╔╝█████╗  ███████╗██║   ██║██║     ██║   ███████╗
+ ██╔══██╗██╔══╝  ╚════██║██║   ██║██║     ██║   ╚════██║
+ ██║  ██║███████╗███████║╚██████╔╝███████╗██║   ███████║
+ ╚═╝  ╚═╝╚══════╝╚══════╝ ╚═════╝ ╚══════╝╚═╝   ╚══════╝
+*/
+
+// ContentTypeBatchStatus is the content type of a response describing the outcome of each event
+const ContentTypeBatchStatus = "application/vnd.fastce.batch-status+json"
+
+// Outcome is the result of handling a single event
+type Outcome string
+
+const (
+	// OutcomeOK means the event was handled
+	OutcomeOK Outcome = "ok"
+	// OutcomeRetryable means the event was not handled, but may be if sent again
+	OutcomeRetryable Outcome = "retryable"
+	// OutcomePermanent means the event will never be handled, and should not be sent again
+	OutcomePermanent Outcome = "permanent"
+)
+
+// EventResult is the outcome of a single event of a batch, identified by its id and source
+type EventResult struct {
+	Id      string  `json:"id"`
+	Source  string  `json:"source"`
+	Outcome Outcome `json:"outcome"`
+	Reason  string  `json:"reason,omitempty"`
+}
+
+// ResultOf returns the EventResult of handling ce with the given error
+// A nil error is OK. Errors which would be sent as 429 or 5xx are retryable, others permanent.
+func ResultOf(ce j.CloudEvent, err error) EventResult {
+	res := EventResult{Id: ce.Id, Source: ce.Source, Outcome: OutcomeOK}
+	if err == nil {
+		return res
+	}
+	res.Reason = err.Error()
+	res.Outcome = OutcomePermanent
+	if status := StatusFromError(err); status == fasthttp.StatusTooManyRequests || status >= 500 {
+		res.Outcome = OutcomeRetryable
+	}
+	return res
+}
+
+// BatchError reports the outcome of each event of a request where some events failed
+// Handlers return it to reply with a batch status document (207) instead of failing the whole
+// request, and CEClient.RecvEvents returns it when a server replies with one.
+// Events without a result are considered OK.
+type BatchError struct {
+	Results []EventResult `json:"results"`
+}
+
+// NewBatchError returns a BatchError for the given results, or nil if every result is OK
+func NewBatchError(results []EventResult) error {
+	for _, r := range results {
+		if r.Outcome != OutcomeOK {
+			return BatchError{Results: results}
+		}
+	}
+	return nil
+}
+
+// Error implements error
+func (e BatchError) Error() string {
+	failed := e.Failed()
+	reasons := []string{}
+	for _, r := range failed {
+		reasons = append(reasons, fmt.Sprintf("%s (%s): %s", r.Id, r.Outcome, r.Reason))
+	}
+	return fmt.Sprintf("%d of %d events failed: %s", len(failed), len(e.Results), strings.Join(reasons, "; "))
+}
+
+// StatusCode returns 207 Multi-Status, as the response describes several outcomes
+func (e BatchError) StatusCode() int {
+	return fasthttp.StatusMultiStatus
+}
+
+// Failed returns the results which are not OK
+func (e BatchError) Failed() (failed []EventResult) {
+	for _, r := range e.Results {
+		if r.Outcome != OutcomeOK {
+			failed = append(failed, r)
+		}
+	}
+	return
+}
+
+// Retryable returns the events of ces whose results are retryable, in their original order
+func (e BatchError) Retryable(ces j.CloudEvents) (retry j.CloudEvents) {
+	outcomes := map[[2]string]Outcome{}
+	for _, r := range e.Results {
+		outcomes[[2]string{r.Source, r.Id}] = r.Outcome
+	}
+	for _, ce := range ces {
+		if outcomes[[2]string{ce.Source, ce.Id}] == OutcomeRetryable {
+			retry = append(retry, ce)
+		}
+	}
+	return
+}
+
+// SetBatchStatus writes a batch status document to a Response
+func SetBatchStatus(res *fasthttp.Response, be BatchError) (err error) {
+	body, err := json.Marshal(be)
+	if err != nil {
+		return fmt.Errorf("Could not marshal batch status: %s", err.Error())
+	}
+	res.SetStatusCode(be.StatusCode())
+	res.Header.SetContentType(ContentTypeBatchStatus)
+	res.SetBody(body)
+	return nil
+}
+
+// isBatchStatus reports whether a content type is that of a batch status document
+func isBatchStatus(ct string) bool {
+	return strings.HasPrefix(ct, ContentTypeBatchStatus)
+}
+
+// BatchStatus reads a batch status document, which must already be decompressed
+func (rr ReqRes) BatchStatus() (be BatchError, err error) {
+	body, err := rr.Body()
+	if err != nil {
+		err = fmt.Errorf("Could not get Body: %s", err.Error())
+		return
+	}
+	if err = rr.Limits.checkBody(body); err != nil {
+		return
+	}
+	if err = json.Unmarshal(body, &be); err != nil {
+		err = fmt.Errorf("Could not unmarshal batch status: %s", err.Error())
+		return
+	}
+	return be, nil
+}
+
+// Deliver sends events and resends those the server reports as retryable, up to attempts times in total
+// It returns any events sent in reply. If some events were not delivered, err is a BatchError
+// describing the last outcome of every event. Use ModeBatch to deliver more than one event, as
+// structured and binary mode send only the first and so are refused.
+// Requests refused with 429 or 5xx and a Retry-After of at most MaxRetryAfter are resent after waiting.
+func (cec *CEClient) Deliver(CEToMap j.CEToMap, MapToCE j.MapToCE, ces j.CloudEvents, mode j.Mode, attempts int) (res j.CloudEvents, err error) {
+	return cec.deliver(context.Background(), CEToMap, MapToCE, ces, mode, attempts)
+}
+
+// deliver implements Deliver, giving up once ctx is done
+func (cec *CEClient) deliver(ctx context.Context, CEToMap j.CEToMap, MapToCE j.MapToCE, ces j.CloudEvents, mode j.Mode, attempts int) (res j.CloudEvents, err error) {
+	if attempts < 1 {
+		attempts = 1
+	}
+	if mode != j.ModeBatch && len(ces) > 1 {
+		return nil, fmt.Errorf("Could not deliver %d events in %s mode, which sends only one", len(ces), modeName(mode))
+	}
+	final := map[[2]string]EventResult{}
+	pending := ces
+	sent := map[[2]string]int{}
+	status := 0
+	defer func() {
+		if err != nil {
+			cec.deadLetter(undelivered(ces, pending, final, sent, cec.failure(status), err))
+		}
+	}()
+	for attempt := 0; attempt < attempts && len(pending) > 0; attempt++ {
+		if attempt > 0 {
+			metricsOrNop(cec.Metrics).Add(MetricClientRetries, float64(len(pending)), "side", "client")
+		}
+		if err = cec.SendEvents(CEToMap, pending, mode); err != nil {
+			return
+		}
+		status = 0
+		err = cec.send(ctx)
+		for _, ce := range pending {
+			sent[[2]string{ce.Source, ce.Id}] += cec.attempts
+		}
+		if err != nil {
+			cec.responded(0, nil, err)
+			return
+		}
+		status = cec.Response.StatusCode()
+
+		var replies j.CloudEvents
+		var be BatchError
+		var se StatusError
+		replies, err = cec.replies(MapToCE)
+		if errors.As(err, &se) {
+			// The whole request failed, so there are no results per event
+			if attempt+1 < attempts && cec.waitRetryAfter(ctx, se) {
+				continue
+			}
+			return
+		} else if err != nil && !errors.As(err, &be) {
+			return
+		}
+		res = append(res, replies...)
+
+		// Events without a result were delivered
+		for _, ce := range pending {
+			final[[2]string{ce.Source, ce.Id}] = ResultOf(ce, nil)
+		}
+		for _, r := range be.Results {
+			final[[2]string{r.Source, r.Id}] = r
+		}
+		pending = be.Retryable(pending)
+	}
+
+	results := make([]EventResult, 0, len(ces))
+	for _, ce := range ces {
+		r := final[[2]string{ce.Source, ce.Id}]
+		if r.Outcome != OutcomeOK {
+			loggerOrNop(cec.Logger).Log(LevelWarn, "Event not delivered", "id", ce.Id, "type", ce.Type, "source", ce.Source, "outcome", r.Outcome, "error", r.Reason)
+		}
+		results = append(results, r)
+	}
+	return res, NewBatchError(results)
+}
+
+// undelivered returns the events Deliver gave up on, annotated with d and their own attempts and error
+// If the error is not a BatchError, it is that of the events still pending, while other events keep the reason of their result.
+func undelivered(ces, pending j.CloudEvents, final map[[2]string]EventResult, sent map[[2]string]int, d DeadLetter, err error) (dead j.CloudEvents) {
+	var be BatchError
+	whole := !errors.As(err, &be)
+	retrying := map[[2]string]bool{}
+	for _, ce := range pending {
+		retrying[[2]string{ce.Source, ce.Id}] = true
+	}
+	for _, ce := range ces {
+		k := [2]string{ce.Source, ce.Id}
+		r, ok := final[k]
+		switch {
+		case whole && retrying[k]:
+			d.Error = err.Error()
+		case ok && r.Outcome != OutcomeOK:
+			d.Error = r.Reason
+		default:
+			continue
+		}
+		d.Attempts = sent[k]
+		dead = append(dead, d.Annotate(ce))
+	}
+	return dead
+}
+
+// waitRetryAfter waits before resending a refused request, if the server asked to be retried within MaxRetryAfter
+// It reports whether it waited.
+func (cec *CEClient) waitRetryAfter(ctx context.Context, se StatusError) bool {
+	if se.RetryAfter <= 0 || (se.Status != fasthttp.StatusTooManyRequests && se.Status < 500) {
+		return false
+	}
+	max := cec.MaxRetryAfter
+	if max == 0 {
+		max = time.Minute
+	}
+	if se.RetryAfter > max {
+		return false
+	}
+	timer := time.NewTimer(se.RetryAfter)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,91 @@
+package fastce
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	jsonce "github.com/creativecactus/fast-cloudevents-go/jsonce"
+)
+
+func TestResultOf(t *testing.T) {
+	ce := jsonce.GenerateValidEvents(1)[0]
+	for want, err := range map[Outcome]error{
+		OutcomeOK:        nil,
+		OutcomeRetryable: Retryable(errors.New("busy")),
+		OutcomePermanent: Permanent(errors.New("bad")),
+	} {
+		if have := ResultOf(ce, err); have.Outcome != want || have.Id != ce.Id {
+			t.Errorf("TestResultOf: %v: want %s, have %+v", err, want, have)
+		}
+	}
+	if have := ResultOf(ce, errors.New("unknown")).Outcome; have != OutcomeRetryable {
+		t.Errorf("TestResultOf: unclassified errors should be retryable, have %s", have)
+	}
+	if err := NewBatchError([]EventResult{ResultOf(ce, nil)}); err != nil {
+		t.Errorf("TestResultOf: want nil BatchError when all OK, have %v", err)
+	}
+}
+
+func TestDeliverPartialFailure(t *testing.T) {
+	lock := sync.Mutex{}
+	seen := map[string]int{}
+	srv := &CEServer{}
+	err := srv.StartCE("127.0.0.1:0", jsonce.DefaultCEToMap, jsonce.DefaultMapToCE, func(ces jsonce.CloudEvents) (jsonce.CloudEvents, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		results := []EventResult{}
+		for _, ce := range ces {
+			seen[ce.Id]++
+			switch {
+			case ce.Id == "bad":
+				results = append(results, ResultOf(ce, Permanent(errors.New("rejected"))))
+			case ce.Id == "flaky" && seen[ce.Id] < 2:
+				results = append(results, ResultOf(ce, Retryable(errors.New("busy"))))
+			default:
+				results = append(results, ResultOf(ce, nil))
+			}
+		}
+		return nil, NewBatchError(results)
+	})
+	if err != nil {
+		t.Fatalf("TestDeliverPartialFailure: %s", err.Error())
+	}
+	defer srv.Shutdown(context.Background())
+
+	cec, err := NewCEClient("POST", srv.Addr())
+	if err != nil {
+		t.Fatalf("TestDeliverPartialFailure: %s", err.Error())
+	}
+	defer cec.Release()
+
+	ces := jsonce.GenerateValidEvents(3)
+	ces[0].Id, ces[1].Id, ces[2].Id = "good", "flaky", "bad"
+	_, err = cec.Deliver(jsonce.DefaultCEToMap, jsonce.DefaultMapToCE, ces, jsonce.ModeBatch, 3)
+
+	var be BatchError
+	if !errors.As(err, &be) {
+		t.Fatalf("TestDeliverPartialFailure: want BatchError, have %v", err)
+	}
+	failed := be.Failed()
+	if len(be.Results) != 3 || len(failed) != 1 || failed[0].Id != "bad" || failed[0].Outcome != OutcomePermanent {
+		t.Fatalf("TestDeliverPartialFailure: unexpected results %+v", be.Results)
+	}
+	if seen["good"] != 1 || seen["flaky"] != 2 || seen["bad"] != 1 {
+		t.Fatalf("TestDeliverPartialFailure: only retryable events should be resent, sent %v", seen)
+	}
+
+	// Structured mode would send only the first event, so several are refused before sending
+	for _, mode := range []jsonce.Mode{jsonce.ModeStructure, jsonce.ModeBinary} {
+		if _, err = cec.Deliver(jsonce.DefaultCEToMap, jsonce.DefaultMapToCE, ces, mode, 3); err == nil {
+			t.Fatalf("TestDeliverPartialFailure: want several events refused in %s mode", modeName(mode))
+		}
+	}
+	if seen["good"] != 1 {
+		t.Fatalf("TestDeliverPartialFailure: refused events should not be sent, sent %v", seen)
+	}
+	if _, err = cec.Deliver(jsonce.DefaultCEToMap, jsonce.DefaultMapToCE, ces[:1], jsonce.ModeStructure, 3); err != nil {
+		t.Fatalf("TestDeliverPartialFailure: %s", err.Error())
+	}
+}
@@ -0,0 +1,118 @@
+package fastce
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+/*
+ ██████╗ ███████╗████████╗██████╗ ██╗   ██╗
+ ██╔══██╗██╔════╝╚══██╔══╝██╔══██╗╚██╗ ██╔╝
+ ██████╔╝█████╗     ██║   ██████╔╝ ╚████╔╝
+ ██╔══██╗██╔══╝     ██║   ██╔══██╗  ╚██╔╝
+ ██║  ██║███████╗   ██║   ██║  ██║   ██║
+ ╚═╝  ╚═╝╚══════╝   ╚═╝   ╚═╝  ╚═╝   ╚═╝
+*/
+
+// RetryPolicy configures a CEClient to resend requests which failed in a way that may succeed later
+// The same request, and so the same events, is resent each time. Receivers should treat the
+// source and id of an event as an idempotency key, since an event may arrive more than once
+// if a response was lost. Delays grow exponentially with full jitter, and a Retry-After
+// in the response is waited for if it is longer.
+type RetryPolicy struct {
+	MaxAttempts int           // Optional, attempts including the first, defaults to 3
+	BaseDelay   time.Duration // Optional, the largest delay before the second attempt, doubled for each after, defaults to 100ms
+	MaxDelay    time.Duration // Optional, the largest delay between attempts, defaults to 10 seconds
+	MaxElapsed  time.Duration // Optional, no attempt is started after this long since the first
+
+	// Retryable decides whether an attempt may be retried, defaults to IsRetryable
+	Retryable func(status int, err error) bool
+	// OnAttempt is called after each attempt, eg. for logging or metrics
+	OnAttempt func(a Attempt)
+}
+
+// Attempt describes one attempt at sending a request, see RetryPolicy.OnAttempt
+type Attempt struct {
+	Number  int           // From 1
+	Status  int           // The status of the response, or 0 if Err is set
+	Err     error         // The error sending the request, if any
+	Elapsed time.Duration // Since the first attempt was started
+	Delay   time.Duration // Before the next attempt, or 0 if no more attempts will be made
+}
+
+// IsRetryable reports whether a request may succeed if sent again
+// Timeouts, refused or reset connections, 408, 429, 500, 502, 503 and 504 are retryable.
+func IsRetryable(status int, err error) bool {
+	if err != nil {
+		if err == fasthttp.ErrBodyTooLarge {
+			return false
+		}
+		switch err {
+		case fasthttp.ErrTimeout, fasthttp.ErrConnectionClosed, fasthttp.ErrNoFreeConns, io.EOF, io.ErrUnexpectedEOF:
+			return true
+		}
+		var ne net.Error
+		return errors.As(err, &ne) // Dial failures, resets and timeouts
+	}
+	switch status {
+	case fasthttp.StatusRequestTimeout,
+		fasthttp.StatusTooManyRequests,
+		fasthttp.StatusInternalServerError,
+		fasthttp.StatusBadGateway,
+		fasthttp.StatusServiceUnavailable,
+		fasthttp.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// backoff returns a random delay before the attempt after attempt n
+func (p RetryPolicy) backoff(n int) time.Duration {
+	base, max := p.BaseDelay, p.MaxDelay
+	if base <= 0 {
+		base = 100 * time.Millisecond
+	}
+	if max <= 0 {
+		max = 10 * time.Second
+	}
+	d := max
+	if n < 32 {
+		if exp := base << uint(n-1); exp > 0 && exp < max {
+			d = exp
+		}
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// next decides whether to make another attempt, and how long to wait first
+// It also completes the Delay of the attempt and passes it to OnAttempt.
+func (p RetryPolicy) next(a Attempt, retryAfter time.Duration) (time.Duration, bool) {
+	attempts := p.MaxAttempts
+	if attempts < 1 {
+		attempts = 3
+	}
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+
+	ok := a.Number < attempts && retryable(a.Status, a.Err)
+	if ok {
+		a.Delay = p.backoff(a.Number)
+		if retryAfter > a.Delay {
+			a.Delay = retryAfter
+		}
+		if p.MaxElapsed > 0 && a.Elapsed+a.Delay > p.MaxElapsed {
+			ok, a.Delay = false, 0
+		}
+	}
+	if p.OnAttempt != nil {
+		p.OnAttempt(a)
+	}
+	return a.Delay, ok
+}
@@ -0,0 +1,106 @@
+package fastce
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	jsonce "github.com/creativecactus/fast-cloudevents-go/jsonce"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestIsRetryable(t *testing.T) {
+	for _, c := range []struct {
+		status int
+		err    error
+		want   bool
+	}{
+		{fasthttp.StatusOK, nil, false},
+		{fasthttp.StatusBadRequest, nil, false},
+		{fasthttp.StatusTooManyRequests, nil, true},
+		{fasthttp.StatusServiceUnavailable, nil, true},
+		{fasthttp.StatusNotImplemented, nil, false},
+		{0, fasthttp.ErrTimeout, true},
+		{0, &net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+		{0, fasthttp.ErrBodyTooLarge, false},
+		{0, errors.New("tls: bad certificate"), false},
+	} {
+		if have := IsRetryable(c.status, c.err); have != c.want {
+			t.Errorf("TestIsRetryable: %d %v: want %v, have %v", c.status, c.err, c.want, have)
+		}
+	}
+}
+
+func TestRetryPolicyNext(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Second, MaxDelay: 4 * time.Second, MaxElapsed: 10 * time.Second}
+	for n := 1; n < 10; n++ {
+		if d := p.backoff(n); d < 0 || d > 4*time.Second {
+			t.Fatalf("TestRetryPolicyNext: backoff %d out of range: %s", n, d)
+		}
+	}
+	if d, ok := p.next(Attempt{Number: 1, Status: 503}, 5*time.Second); !ok || d != 5*time.Second {
+		t.Fatalf("TestRetryPolicyNext: want Retry-After honoured, have %s %v", d, ok)
+	}
+	if _, ok := p.next(Attempt{Number: 3, Status: 503}, 0); ok {
+		t.Fatalf("TestRetryPolicyNext: want no retry after MaxAttempts")
+	}
+	if _, ok := p.next(Attempt{Number: 1, Status: 503, Elapsed: 6 * time.Second}, 5*time.Second); ok {
+		t.Fatalf("TestRetryPolicyNext: want no retry past MaxElapsed")
+	}
+	if _, ok := p.next(Attempt{Number: 1, Status: 400}, 0); ok {
+		t.Fatalf("TestRetryPolicyNext: want no retry of 400")
+	}
+}
+
+func TestClientRetry(t *testing.T) {
+	lock := sync.Mutex{}
+	ids := []string{}
+	srv := &CEServer{}
+	err := srv.StartCE("127.0.0.1:0", jsonce.DefaultCEToMap, jsonce.DefaultMapToCE, func(ces jsonce.CloudEvents) (jsonce.CloudEvents, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		ids = append(ids, ces[0].Id)
+		if len(ids) < 3 {
+			return nil, Retryable(errors.New("Not yet"))
+		}
+		return ces, nil
+	})
+	if err != nil {
+		t.Fatalf("TestClientRetry: %s", err.Error())
+	}
+	defer srv.Shutdown(context.Background())
+
+	cec, err := NewCEClient("POST", srv.Addr())
+	if err != nil {
+		t.Fatalf("TestClientRetry: %s", err.Error())
+	}
+	defer cec.Release()
+	attempts := []Attempt{}
+	cec.Retry = &RetryPolicy{MaxAttempts: 5, BaseDelay: time.Millisecond, OnAttempt: func(a Attempt) {
+		attempts = append(attempts, a)
+	}}
+	if _, err = ClientTester(cec, jsonce.GenerateValidEvents(1), jsonce.ModeStructure, 1); err != nil {
+		t.Fatalf("TestClientRetry: %s", err.Error())
+	}
+	if len(attempts) != 3 || attempts[0].Status != 503 || attempts[1].Status != 503 || attempts[2].Status != 200 || attempts[2].Delay != 0 {
+		t.Fatalf("TestClientRetry: unexpected attempts %+v", attempts)
+	}
+	if ids[0] != ids[1] || ids[1] != ids[2] {
+		t.Fatalf("TestClientRetry: want the same event resent, have %v", ids)
+	}
+
+	// Network errors are retried too, and returned once attempts run out
+	srv.Shutdown(context.Background())
+	attempts = attempts[:0]
+	cec.Retry.MaxAttempts = 2
+	if err = cec.Send(); err == nil {
+		t.Fatalf("TestClientRetry: want an error sending to a stopped server")
+	}
+	if len(attempts) != 2 || attempts[0].Err == nil {
+		t.Fatalf("TestClientRetry: unexpected attempts %+v", attempts)
+	}
+}
@@ -0,0 +1,204 @@
+package fastce
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+ ████████╗██╗     ███████╗
+ ╚══██╔══╝██║     ██╔════╝
+    ██║   ██║     ███████╗
+    ██║   ██║     ╚════██║
+    ██║   ███████╗███████║
+    ╚═╝   ╚══════╝╚══════╝
+*/
+
+// ServerTLS configures TLS, and optionally mutual TLS, for a CEServer
+type ServerTLS struct {
+	CertFile string // PEM encoded certificate chain, reloaded when it changes on disk
+	KeyFile  string // PEM encoded private key, reloaded when it changes on disk
+
+	// ClientCAFile is a PEM bundle of the CAs trusted to sign client certificates
+	// Set ClientAuth to tls.RequireAndVerifyClientCert for mutual TLS
+	ClientCAFile string
+	ClientAuth   tls.ClientAuthType
+
+	Config *tls.Config // Optional base configuration, which is cloned
+}
+
+// TLSConfig builds a tls.Config from the ServerTLS
+// The certificate is served through a CertReloader, so it may be replaced on disk without a restart
+func (st ServerTLS) TLSConfig() (cfg *tls.Config, err error) {
+	cfg = &tls.Config{}
+	if st.Config != nil {
+		cfg = st.Config.Clone()
+	}
+	if len(cfg.NextProtos) == 0 {
+		cfg.NextProtos = []string{"http/1.1"}
+	}
+
+	if len(st.CertFile) > 0 || len(st.KeyFile) > 0 {
+		reloader, err := NewCertReloader(st.CertFile, st.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.GetCertificate = reloader.GetCertificate
+	}
+	if cfg.GetCertificate == nil && len(cfg.Certificates) == 0 {
+		return nil, fmt.Errorf("TLS requires a certificate")
+	}
+
+	if len(st.ClientCAFile) > 0 {
+		if cfg.ClientCAs, err = loadCertPool(st.ClientCAFile); err != nil {
+			return nil, err
+		}
+	}
+	if st.ClientAuth != tls.NoClientCert {
+		cfg.ClientAuth = st.ClientAuth
+	}
+	return cfg, nil
+}
+
+// ClientTLS configures TLS, and optionally mutual TLS, for a CEClient
+type ClientTLS struct {
+	CAFile     string // PEM bundle of CAs trusted to sign server certificates, defaults to the system pool
+	CertFile   string // PEM encoded client certificate chain, for mutual TLS
+	KeyFile    string // PEM encoded client private key, for mutual TLS
+	ServerName string // Overrides the name used for SNI and verification, defaults to the URL host
+
+	Config *tls.Config // Optional base configuration, which is cloned
+}
+
+// TLSConfig builds a tls.Config from the ClientTLS
+func (ct ClientTLS) TLSConfig() (cfg *tls.Config, err error) {
+	cfg = &tls.Config{}
+	if ct.Config != nil {
+		cfg = ct.Config.Clone()
+	}
+	if len(ct.CAFile) > 0 {
+		if cfg.RootCAs, err = loadCertPool(ct.CAFile); err != nil {
+			return nil, err
+		}
+	}
+	if len(ct.CertFile) > 0 || len(ct.KeyFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(ct.CertFile, ct.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Could not load client certificate: %s", err.Error())
+		}
+		cfg.Certificates = append(cfg.Certificates, cert)
+	}
+	if len(ct.ServerName) > 0 {
+		cfg.ServerName = ct.ServerName
+	}
+	return cfg, nil
+}
+
+// CertReloader serves a certificate from files, reloading it when either file changes
+// Files are checked for changes at most once per CheckInterval.
+type CertReloader struct {
+	CertFile      string
+	KeyFile       string
+	CheckInterval time.Duration // Defaults to 10 seconds
+
+	lock    sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time // Latest modification time of either file when last loaded
+	checked time.Time
+}
+
+// NewCertReloader loads a certificate from the given files
+func NewCertReloader(certFile, keyFile string) (cr *CertReloader, err error) {
+	cr = &CertReloader{
+		CertFile: certFile,
+		KeyFile:  keyFile,
+	}
+	if err = cr.Reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// Reload loads the certificate from disk, keeping the previous one if this fails
+func (cr *CertReloader) Reload() (err error) {
+	modTime, err := cr.latestModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(cr.CertFile, cr.KeyFile)
+	if err != nil {
+		return fmt.Errorf("Could not load certificate: %s", err.Error())
+	}
+
+	cr.lock.Lock()
+	defer cr.lock.Unlock()
+	cr.cert = &cert
+	cr.modTime = modTime
+	cr.checked = time.Now()
+	return nil
+}
+
+// GetCertificate can be used as tls.Config.GetCertificate
+func (cr *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	interval := cr.CheckInterval
+	if interval == 0 {
+		interval = 10 * time.Second
+	}
+
+	cr.lock.RLock()
+	cert, modTime, checked := cr.cert, cr.modTime, cr.checked
+	cr.lock.RUnlock()
+
+	if time.Since(checked) < interval {
+		return cert, nil
+	}
+	latest, err := cr.latestModTime()
+	if err == nil && latest.After(modTime) {
+		err = cr.Reload()
+	}
+	if err != nil {
+		// Keep serving the last good certificate, the files may be mid-update
+		cr.lock.Lock()
+		cr.checked = time.Now()
+		cr.lock.Unlock()
+		return cert, nil
+	}
+
+	cr.lock.Lock()
+	cr.checked = time.Now()
+	cert = cr.cert
+	cr.lock.Unlock()
+	return cert, nil
+}
+
+// latestModTime returns the most recent modification time of the certificate and key files
+func (cr *CertReloader) latestModTime() (t time.Time, err error) {
+	for _, f := range []string{cr.CertFile, cr.KeyFile} {
+		info, err := os.Stat(f)
+		if err != nil {
+			return t, fmt.Errorf("Could not stat %s: %s", f, err.Error())
+		}
+		if info.ModTime().After(t) {
+			t = info.ModTime()
+		}
+	}
+	return t, nil
+}
+
+// loadCertPool reads a PEM bundle of certificates into a pool
+func loadCertPool(file string) (pool *x509.CertPool, err error) {
+	pem, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read CA file: %s", err.Error())
+	}
+	pool = x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("No certificates found in CA file %s", file)
+	}
+	return pool, nil
+}
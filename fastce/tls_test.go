@@ -0,0 +1,212 @@
+package fastce
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	jsonce "github.com/creativecactus/fast-cloudevents-go/jsonce"
+)
+
+// testCert is a generated certificate and key, with the paths they were written to
+type testCert struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	CertFile string
+	KeyFile  string
+}
+
+// generateCert creates a certificate signed by parent, or self signed if parent is nil
+func generateCert(t *testing.T, dir, name string, parent *testCert, isCA bool) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generateCert: %s", err.Error())
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")},
+	}
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("generateCert: %s", err.Error())
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("generateCert: %s", err.Error())
+	}
+
+	tc := &testCert{
+		cert:     cert,
+		key:      key,
+		CertFile: filepath.Join(dir, name+".crt"),
+		KeyFile:  filepath.Join(dir, name+".key"),
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err = ioutil.WriteFile(tc.CertFile, certPEM, 0600); err != nil {
+		t.Fatalf("generateCert: %s", err.Error())
+	}
+	if err = ioutil.WriteFile(tc.KeyFile, keyPEM, 0600); err != nil {
+		t.Fatalf("generateCert: %s", err.Error())
+	}
+	return tc
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fastce-tls")
+	if err != nil {
+		t.Fatalf("TestMutualTLS: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	ca := generateCert(t, dir, "ca", nil, true)
+	server := generateCert(t, dir, "server", ca, false)
+	client := generateCert(t, dir, "client", ca, false)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("TestMutualTLS: %s", err.Error())
+	}
+	defer ln.Close()
+	srv := CEServer{
+		Listener: ln,
+		TLS: &ServerTLS{
+			CertFile:     server.CertFile,
+			KeyFile:      server.KeyFile,
+			ClientCAFile: ca.CertFile,
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+		},
+	}
+	go srv.ListenAndServeHTTP("", ExampleHandler)
+
+	url := fmt.Sprintf("https://localhost:%d", ln.Addr().(*net.TCPAddr).Port)
+
+	// Without a client certificate, the handshake is refused
+	cec, err := NewCEClient("PUT", url)
+	if err != nil {
+		t.Fatalf("TestMutualTLS: %s", err.Error())
+	}
+	defer cec.Release()
+	if err = cec.SetTLS(ClientTLS{CAFile: ca.CertFile}); err != nil {
+		t.Fatalf("TestMutualTLS: %s", err.Error())
+	}
+	if _, err = ClientTester(cec, jsonce.GenerateValidEvents(1), jsonce.ModeStructure, 1); err == nil {
+		t.Fatalf("TestMutualTLS: want handshake failure without client certificate")
+	}
+
+	// With a client certificate signed by the trusted CA, events are echoed
+	mcec, err := NewCEClient("PUT", url)
+	if err != nil {
+		t.Fatalf("TestMutualTLS: %s", err.Error())
+	}
+	defer mcec.Release()
+	err = mcec.SetTLS(ClientTLS{
+		CAFile:   ca.CertFile,
+		CertFile: client.CertFile,
+		KeyFile:  client.KeyFile,
+	})
+	if err != nil {
+		t.Fatalf("TestMutualTLS: %s", err.Error())
+	}
+	if _, err = ClientTester(mcec, jsonce.GenerateValidEvents(3), jsonce.ModeBatch, 3); err != nil {
+		t.Fatalf("TestMutualTLS: %s", err.Error())
+	}
+}
+
+func TestTLSRetryListener(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fastce-tls")
+	if err != nil {
+		t.Fatalf("TestTLSRetryListener: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	ca := generateCert(t, dir, "ca", nil, true)
+	server := generateCert(t, dir, "server", ca, false)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("TestTLSRetryListener: %s", err.Error())
+	}
+	defer ln.Close()
+
+	// A failed TLS config leaves the provided listener open, so Start can be retried with it
+	srv := &CEServer{Listener: ln, TLS: &ServerTLS{CertFile: filepath.Join(dir, "missing.pem"), KeyFile: server.KeyFile}}
+	if err = srv.Start("", ExampleHandler); err == nil {
+		t.Fatalf("TestTLSRetryListener: want TLS failure")
+	}
+	srv.TLS = &ServerTLS{CertFile: server.CertFile, KeyFile: server.KeyFile}
+	if err = srv.Start("", ExampleHandler); err != nil {
+		t.Fatalf("TestTLSRetryListener: %s", err.Error())
+	}
+	defer srv.Shutdown(context.Background())
+
+	cec, err := NewCEClient("PUT", fmt.Sprintf("https://localhost:%d", ln.Addr().(*net.TCPAddr).Port))
+	if err != nil {
+		t.Fatalf("TestTLSRetryListener: %s", err.Error())
+	}
+	defer cec.Release()
+	if err = cec.SetTLS(ClientTLS{CAFile: ca.CertFile}); err != nil {
+		t.Fatalf("TestTLSRetryListener: %s", err.Error())
+	}
+	if _, err = ClientTester(cec, jsonce.GenerateValidEvents(1), jsonce.ModeStructure, 1); err != nil {
+		t.Fatalf("TestTLSRetryListener: %s", err.Error())
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fastce-tls")
+	if err != nil {
+		t.Fatalf("TestCertReloader: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	first := generateCert(t, dir, "server", nil, false)
+	cr, err := NewCertReloader(first.CertFile, first.KeyFile)
+	if err != nil {
+		t.Fatalf("TestCertReloader: %s", err.Error())
+	}
+	cr.CheckInterval = time.Nanosecond
+
+	// Overwrite the files with a new certificate, with a later modification time
+	second := generateCert(t, dir, "server", nil, false)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(second.CertFile, later, later)
+
+	cert, err := cr.GetCertificate(nil)
+	if err != nil {
+		t.Fatalf("TestCertReloader: %s", err.Error())
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("TestCertReloader: %s", err.Error())
+	}
+	if leaf.SerialNumber.Cmp(second.cert.SerialNumber) != 0 {
+		t.Fatalf("TestCertReloader: certificate was not reloaded")
+	}
+}
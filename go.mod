@@ -4,5 +4,6 @@ go 1.13
 
 require (
 	github.com/creativecactus/fast-cloudevents-go v0.2.0
+	github.com/klauspost/compress v1.8.2
 	github.com/valyala/fasthttp v1.8.0
 )